@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+	"chujungeng/camera-roll/pkg/config"
+	"chujungeng/camera-roll/pkg/imaging"
+	"chujungeng/camera-roll/pkg/routes"
+	"chujungeng/camera-roll/pkg/storage"
+	"chujungeng/camera-roll/pkg/worker"
+)
+
+// names of the one-off commands, e.g. ./cameraroll backfill-placeholders
+const (
+	cmdBackfillPlaceholders = "backfill-placeholders"
+	cmdRegenerate           = "regenerate"
+)
+
+// how often the progress of a regeneration is reported
+const progressInterval = 5 * time.Second
+
+// runCommand runs a one-off command instead of the server,
+// args[0] being the name of the command followed by its flags
+func runCommand(ctx context.Context, args []string, service cameraroll.Service, processor *imaging.Processor, options *config.Config) error {
+	switch args[0] {
+	case cmdBackfillPlaceholders:
+		return backfillPlaceholders(ctx, service, processor)
+	case cmdRegenerate:
+		return regenerate(ctx, args[1:], service, processor, options)
+	default:
+		return fmt.Errorf("unknown command [%s]", args[0])
+	}
+}
+
+// backfillPlaceholders computes the placeholders of the images uploaded before placeholders existed
+func backfillPlaceholders(ctx context.Context, service cameraroll.Service, processor *imaging.Processor) error {
+	const pageSize = 100
+
+	count := 0
+	for start := uint64(0); ; start += pageSize {
+		images, err := service.GetImages(ctx, start, pageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, img := range images {
+			if len(img.BlurHash) > 0 {
+				continue
+			}
+
+			placeholder, err := processor.CreatePlaceholder(ctx, storage.KeyFromURL(img.Thumbnail, routes.StaticAssetURL()))
+			if err != nil {
+				// keep going, the image can be fixed by hand later
+				log.Printf("backfillPlaceholders image[%d]: %v", img.ID, err)
+				continue
+			}
+
+			if err := service.UpdatePlaceholderOfImage(ctx, img.ID, placeholder); err != nil {
+				return err
+			}
+			count++
+		}
+
+		if len(images) < pageSize || ctx.Err() != nil {
+			break
+		}
+	}
+
+	log.Printf("backfilled the placeholders of %d images", count)
+
+	return ctx.Err()
+}
+
+// regenerate re-derives the thumbnails, renditions, dimensions and metadata
+// of one image, the images with a tag, the images in an album or every image from their originals.
+//
+// The images are queued as a batch of jobs named after the scope, so an interrupted
+// regeneration picks up where it left off when the same command is run again.
+func regenerate(ctx context.Context, args []string, service cameraroll.Service, processor *imaging.Processor, options *config.Config) error {
+	flags := flag.NewFlagSet(cmdRegenerate, flag.ContinueOnError)
+	imageID := flags.Int64("image", 0, "regenerate a single image")
+	tagID := flags.Int64("tag", 0, "regenerate the images with a tag")
+	albumID := flags.Int64("album", 0, "regenerate the images in an album")
+	all := flags.Bool("all", false, "regenerate every image")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var batch string
+	switch {
+	case *imageID > 0:
+		batch = fmt.Sprintf("regenerate-image-%d", *imageID)
+	case *tagID > 0:
+		batch = fmt.Sprintf("regenerate-tag-%d", *tagID)
+	case *albumID > 0:
+		batch = fmt.Sprintf("regenerate-album-%d", *albumID)
+	case *all:
+		batch = "regenerate-all"
+	default:
+		return fmt.Errorf("%s: one of -image, -tag, -album or -all is required", cmdRegenerate)
+	}
+
+	progress, err := service.GetJobProgress(ctx, batch)
+	if err != nil {
+		return err
+	}
+
+	if progress.Finished() {
+		// start over, the previous run of this batch got through all its jobs
+		if err := service.DeleteJobsOfBatch(ctx, batch); err != nil {
+			return err
+		}
+
+		images, err := imagesInScope(ctx, service, *imageID, *tagID, *albumID)
+		if err != nil {
+			return err
+		}
+
+		for _, img := range images {
+			if err := queueImage(ctx, service, img.ID, batch); err != nil {
+				return err
+			}
+		}
+
+		log.Printf("%s: queued %d images", batch, len(images))
+	} else {
+		log.Printf("%s: resuming, %d of %d images left", batch, progress.Queued+progress.Running, progress.Total())
+	}
+
+	// run the jobs in this process, a running server may claim some of them too.
+	// Jobs left running by an interrupted run are taken over once their leases expire.
+	workers := worker.NewPool(service, processor, options.Jobs.Workers, *options.Images.DuplicateDistance)
+	workers.Start()
+
+	err = waitForBatch(ctx, service, batch)
+
+	log.Println("waiting for workers to finish their jobs")
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), workerDrainTimeout)
+	defer drainCancel()
+	if err := workers.Shutdown(drainCtx); err != nil {
+		log.Println("Workers forced to shutdown: ", err)
+	}
+
+	if ctx.Err() != nil {
+		log.Printf("%s: interrupted, run the same command again to resume", batch)
+	}
+
+	return err
+}
+
+// imagesInScope lists the images of a regeneration, every image unless imageID, tagID or albumID is given
+func imagesInScope(ctx context.Context, service cameraroll.Service, imageID int64, tagID int64, albumID int64) ([]*cameraroll.Image, error) {
+	const pageSize = 100
+
+	switch {
+	case imageID > 0:
+		img, err := service.GetImageByID(ctx, imageID)
+		if err != nil {
+			return nil, err
+		}
+
+		return []*cameraroll.Image{img}, nil
+	case albumID > 0:
+		return service.GetImagesFromAlbum(ctx, albumID)
+	}
+
+	images := []*cameraroll.Image{}
+	for start := uint64(0); ; start += pageSize {
+		var page []*cameraroll.Image
+		var err error
+
+		if tagID > 0 {
+			page, err = service.GetImagesWithTag(ctx, tagID, false, start, pageSize)
+		} else {
+			page, err = service.GetImages(ctx, start, pageSize)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		images = append(images, page...)
+
+		if len(page) < pageSize {
+			return images, nil
+		}
+	}
+}
+
+// queueImage queues the processing of an image as part of a batch
+func queueImage(ctx context.Context, service cameraroll.Service, imageID int64, batch string) error {
+	job := cameraroll.Job{
+		Kind:    cameraroll.JobProcessImage,
+		ImageID: imageID,
+		Batch:   batch,
+	}
+
+	// marked before queueing, a worker may pick up the job right away
+	if err := service.UpdateProcessingStatusOfImage(ctx, imageID, cameraroll.ProcessingPending); err != nil {
+		return err
+	}
+
+	return service.AddJob(ctx, &job)
+}
+
+// waitForBatch reports the progress of a batch until it's finished or ctx is cancelled
+func waitForBatch(ctx context.Context, service cameraroll.Service, batch string) error {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		progress, err := service.GetJobProgress(ctx, batch)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		log.Printf("%s: %d/%d done, %d failed", batch, progress.Done, progress.Total(), progress.Failed)
+
+		if progress.Finished() {
+			return nil
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
@@ -12,18 +13,108 @@ import (
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 
+	"chujungeng/camera-roll/pkg/cameraroll"
 	"chujungeng/camera-roll/pkg/config"
+	"chujungeng/camera-roll/pkg/imaging"
 	"chujungeng/camera-roll/pkg/mysql"
 	"chujungeng/camera-roll/pkg/routes"
+	"chujungeng/camera-roll/pkg/storage"
 	"chujungeng/camera-roll/pkg/url"
+	"chujungeng/camera-roll/pkg/worker"
 )
 
 var commit string
 
+// how long running jobs get to finish when shutting down
+const workerDrainTimeout = 60 * time.Second
+
 func printVersionInfo() {
 	log.Printf("Commit Hash: %s", commit)
 }
 
+// newAssetStores sets up the blob storage chosen in the configs,
+// one for publicly served assets and one for the untouched originals
+func newAssetStores(ctx context.Context, settings *config.StorageSettings) (cameraroll.AssetStore, cameraroll.AssetStore, error) {
+	switch settings.Driver {
+	case config.S3Storage:
+		if settings.S3 == nil {
+			return nil, nil, fmt.Errorf("missing s3 storage settings")
+		}
+
+		store, err := storage.NewS3Store(ctx,
+			settings.S3.Endpoint,
+			settings.S3.AccessKey,
+			settings.S3.SecretKey,
+			settings.S3.Region,
+			settings.S3.Bucket,
+			settings.S3.UseSSL)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		originals, err := storage.NewS3Store(ctx,
+			settings.S3.Endpoint,
+			settings.S3.AccessKey,
+			settings.S3.SecretKey,
+			settings.S3.Region,
+			settings.S3.PrivateBucket,
+			settings.S3.UseSSL)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return store, originals, nil
+	case config.LocalStorage, "":
+		store := storage.NewLocalStore(routes.StaticFileDir(), routes.DeletedFileDir())
+		originals := storage.NewLocalStore(routes.PrivateFileDir(), routes.DeletedPrivateFileDir())
+
+		return store, originals, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown storage driver [%s]", settings.Driver)
+	}
+}
+
+// newWatermark sets up a mark from the configs, nil if there's none
+func newWatermark(settings *config.MarkSettings) (*imaging.Watermark, error) {
+	if settings == nil {
+		return nil, nil
+	}
+
+	if len(settings.Image) == 0 {
+		return imaging.NewTextWatermark(settings.Text, settings.Position, *settings.Opacity, settings.Scale)
+	}
+
+	f, err := os.Open(settings.Image)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return imaging.NewImageWatermark(f, settings.Position, *settings.Opacity, settings.Scale)
+}
+
+// newWatermarks sets up the default mark and the ones albums can use instead
+func newWatermarks(settings *config.WatermarkSettings) (*imaging.Watermark, map[string]*imaging.Watermark, error) {
+	mark, err := newWatermark(settings.Default)
+	if err != nil {
+		return nil, nil, fmt.Errorf("default watermark: %v", err)
+	}
+
+	marks := make(map[string]*imaging.Watermark, len(settings.Albums))
+	for name, markSettings := range settings.Albums {
+		if name == cameraroll.WatermarkNone {
+			return nil, nil, fmt.Errorf("watermark name [%s] is reserved", name)
+		}
+
+		marks[name], err = newWatermark(markSettings)
+		if err != nil {
+			return nil, nil, fmt.Errorf("watermark [%s]: %v", name, err)
+		}
+	}
+
+	return mark, marks, nil
+}
+
 func main() {
 	defer log.Println("Goodbye!")
 
@@ -58,6 +149,61 @@ func main() {
 	}
 	defer dbService.Cleanup()
 
+	// Set up asset storage
+	store, originals, err := newAssetStores(ctx, options.Storage)
+	if err != nil {
+		panic(err)
+	}
+
+	// Set up image processing
+	if !imaging.ValidMetadataPolicy(options.Images.MetadataPolicy) {
+		panic(fmt.Errorf("unknown metadata policy [%s]", options.Images.MetadataPolicy))
+	}
+
+	watermark, watermarks, err := newWatermarks(options.Watermark)
+	if err != nil {
+		panic(err)
+	}
+
+	processor := imaging.NewProcessor(store, originals, url.Join(options.RootURL, routes.StaticAssetURL()), imaging.Options{
+		ThumbnailSize:     options.Images.ThumbnailSize,
+		RenditionWidths:   options.Images.RenditionWidths,
+		MetadataPolicy:    options.Images.MetadataPolicy,
+		WatermarkMinWidth: *options.Watermark.MinWidth,
+		Watermark:         watermark,
+		Watermarks:        watermarks,
+	})
+
+	// Run a one-off command instead of the server if one was given
+	if len(os.Args) > 1 {
+		if err := runCommand(ctx, os.Args[1:], dbService, processor, options); err != nil {
+			panic(err)
+		}
+
+		return
+	}
+
+	// Set up on-the-fly resizing
+	resizeCache, err := storage.NewDiskCache(routes.CacheFileDir(), options.Resize.CacheSize<<20)
+	if err != nil {
+		panic(err)
+	}
+	resizer := imaging.NewResizer(processor, resizeCache, options.Resize.Sizes)
+
+	// Set up WebP copies of the thumbnails and renditions, sharing the cache
+	maxWidth := options.Images.ThumbnailSize
+	for _, width := range options.Images.RenditionWidths {
+		if width > maxWidth {
+			maxWidth = width
+		}
+	}
+	converter := imaging.NewConverter(store, resizeCache, maxWidth)
+
+	// Set up background processing, the jobs interrupted by the previous run
+	// are taken over once their leases expire
+	workers := worker.NewPool(dbService, processor, options.Jobs.Workers, *options.Images.DuplicateDistance)
+	workers.Start()
+
 	// Set up Google OAuth2
 	googleOauthConfig := &oauth2.Config{
 		RedirectURL:  url.Join(options.RootURL, "/auth/google/callback"),
@@ -67,8 +213,15 @@ func main() {
 		Endpoint:     google.Endpoint,
 	}
 
+	// Reject uploads too large to store or decode
+	limits := routes.UploadLimits{
+		MaxFileSize:  options.Images.MaxFileSize << 20,
+		MaxBatchSize: options.Images.MaxBatchSize << 20,
+		MaxPixels:    int64(options.Images.MaxMegapixels * 1e6),
+	}
+
 	// Create a new handler
-	handler := routes.NewHandler(dbService, options.RootURL, options.CorsOrigin, options.JWTSecret, options.AdminID, googleOauthConfig)
+	handler := routes.NewHandler(dbService, store, processor, resizer, converter, workers, limits, *options.Images.DuplicateDistance, options.RootURL, options.CorsOrigin, options.JWTSecret, options.AdminID, googleOauthConfig)
 
 	// Print a JWT token for debug
 	if options.Mode != config.ProdMode {
@@ -109,5 +262,14 @@ func main() {
 		log.Fatal("Server forced to shutdown: ", err)
 	}
 
+	// Let the workers finish the jobs they're running,
+	// the rest stay queued for the next run
+	log.Println("waiting for workers to finish their jobs")
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), workerDrainTimeout)
+	defer drainCancel()
+	if err := workers.Shutdown(drainCtx); err != nil {
+		log.Println("Workers forced to shutdown: ", err)
+	}
+
 	log.Println("Server exiting")
 }
@@ -0,0 +1,12 @@
+ALTER TABLE images
+    DROP COLUMN camera_make,
+    DROP COLUMN camera_model,
+    DROP COLUMN lens,
+    DROP COLUMN focal_length,
+    DROP COLUMN aperture,
+    DROP COLUMN shutter_speed,
+    DROP COLUMN exposure_time,
+    DROP COLUMN iso,
+    DROP COLUMN date_taken,
+    DROP COLUMN latitude,
+    DROP COLUMN longitude;
\ No newline at end of file
@@ -0,0 +1,12 @@
+ALTER TABLE images
+    ADD camera_make VARCHAR(64) NOT NULL DEFAULT '',
+    ADD camera_model VARCHAR(64) NOT NULL DEFAULT '',
+    ADD lens VARCHAR(128) NOT NULL DEFAULT '',
+    ADD focal_length DOUBLE DEFAULT NULL,
+    ADD aperture DOUBLE DEFAULT NULL,
+    ADD shutter_speed VARCHAR(16) NOT NULL DEFAULT '',
+    ADD exposure_time DOUBLE DEFAULT NULL,
+    ADD iso INT DEFAULT NULL,
+    ADD date_taken DATETIME DEFAULT NULL,
+    ADD latitude DOUBLE DEFAULT NULL,
+    ADD longitude DOUBLE DEFAULT NULL;
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE images
+    DROP COLUMN blurhash,
+    DROP COLUMN lqip,
+    DROP COLUMN color;
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE images
+    ADD blurhash VARCHAR(64) NOT NULL DEFAULT '',
+    ADD lqip VARCHAR(2048) NOT NULL DEFAULT '',
+    ADD color CHAR(7) NOT NULL DEFAULT '';
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE images
+    ADD phash BIGINT UNSIGNED DEFAULT NULL;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE images
+    DROP COLUMN processing_status,
+    ADD UNIQUE (thumbnail);
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE images
+    ADD processing_status VARCHAR(16) NOT NULL DEFAULT 'ready',
+    DROP INDEX thumbnail;
\ No newline at end of file
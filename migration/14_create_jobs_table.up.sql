@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS jobs(
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    kind VARCHAR(32) NOT NULL,
+    image_id INT NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'queued',
+    attempts INT NOT NULL DEFAULT 0,
+    error VARCHAR(1024) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idx_job_status (status),
+    CONSTRAINT fk_image_job
+    FOREIGN KEY (image_id)
+    REFERENCES images(id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE jobs
+    DROP INDEX idx_job_batch,
+    DROP COLUMN batch;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE jobs
+    ADD batch VARCHAR(64) NOT NULL DEFAULT '',
+    ADD INDEX idx_job_batch (batch);
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE image_edits;
\ No newline at end of file
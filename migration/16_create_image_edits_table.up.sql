@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS image_edits(
+    image_id INT NOT NULL PRIMARY KEY,
+    rotation SMALLINT NOT NULL DEFAULT 0,
+    flip_horizontal BOOLEAN NOT NULL DEFAULT FALSE,
+    flip_vertical BOOLEAN NOT NULL DEFAULT FALSE,
+    straighten DOUBLE NOT NULL DEFAULT 0,
+    crop_x DOUBLE DEFAULT NULL,
+    crop_y DOUBLE DEFAULT NULL,
+    crop_width DOUBLE DEFAULT NULL,
+    crop_height DOUBLE DEFAULT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    CONSTRAINT fk_image_edit
+    FOREIGN KEY (image_id)
+    REFERENCES images(id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE images
+    DROP COLUMN focal_x,
+    DROP COLUMN focal_y;
\ No newline at end of file
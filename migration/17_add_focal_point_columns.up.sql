@@ -0,0 +1,3 @@
+ALTER TABLE images
+    ADD focal_x DOUBLE NOT NULL DEFAULT 0.5,
+    ADD focal_y DOUBLE NOT NULL DEFAULT 0.5;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE images
+    DROP COLUMN color_space;
\ No newline at end of file
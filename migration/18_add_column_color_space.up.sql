@@ -0,0 +1,2 @@
+ALTER TABLE images
+    ADD color_space VARCHAR(128) NOT NULL DEFAULT '';
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE albums
+    ADD watermark VARCHAR(64) NOT NULL DEFAULT '';
\ No newline at end of file
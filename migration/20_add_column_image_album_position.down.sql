@@ -0,0 +1,2 @@
+ALTER TABLE image_albums
+    DROP COLUMN position;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE image_albums
+    ADD position INT NOT NULL DEFAULT 0;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE albums
+    DROP COLUMN sort_mode;
\ No newline at end of file
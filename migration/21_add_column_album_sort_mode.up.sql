@@ -0,0 +1,2 @@
+ALTER TABLE albums
+    ADD sort_mode VARCHAR(16) NOT NULL DEFAULT 'manual';
\ No newline at end of file
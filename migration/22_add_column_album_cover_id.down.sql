@@ -0,0 +1,3 @@
+ALTER TABLE albums
+    DROP CONSTRAINT fk_album_cover,
+    DROP COLUMN cover_id;
\ No newline at end of file
@@ -0,0 +1,7 @@
+ALTER TABLE albums
+    ADD cover_id INT DEFAULT NULL,
+    ADD CONSTRAINT fk_album_cover
+    FOREIGN KEY (cover_id)
+    REFERENCES images(id)
+        ON UPDATE CASCADE
+        ON DELETE SET NULL;
\ No newline at end of file
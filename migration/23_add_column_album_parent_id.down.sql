@@ -0,0 +1,3 @@
+ALTER TABLE albums
+    DROP CONSTRAINT fk_album_parent,
+    DROP COLUMN parent_id;
\ No newline at end of file
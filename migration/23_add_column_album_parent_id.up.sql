@@ -0,0 +1,7 @@
+ALTER TABLE albums
+    ADD parent_id INT DEFAULT NULL,
+    ADD CONSTRAINT fk_album_parent
+    FOREIGN KEY (parent_id)
+    REFERENCES albums(id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE tags
+    DROP CONSTRAINT fk_tag_parent,
+    DROP COLUMN parent_id;
\ No newline at end of file
@@ -0,0 +1,7 @@
+ALTER TABLE tags
+    ADD parent_id INT DEFAULT NULL,
+    ADD CONSTRAINT fk_tag_parent
+    FOREIGN KEY (parent_id)
+    REFERENCES tags(id)
+        ON UPDATE CASCADE
+        ON DELETE SET NULL;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE tag_aliases;
\ No newline at end of file
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS tag_aliases(
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(32) NOT NULL UNIQUE,
+    tag_id INT NOT NULL,
+    CONSTRAINT fk_alias_tag
+    FOREIGN KEY (tag_id)
+    REFERENCES tags(id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
\ No newline at end of file
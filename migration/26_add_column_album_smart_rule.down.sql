@@ -0,0 +1,2 @@
+ALTER TABLE albums
+    DROP COLUMN smart_rule;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE albums
+    ADD smart_rule JSON DEFAULT NULL;
\ No newline at end of file
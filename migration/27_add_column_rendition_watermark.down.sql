@@ -0,0 +1,2 @@
+ALTER TABLE image_renditions
+    DROP COLUMN watermark;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE image_renditions
+    ADD watermark VARCHAR(64) NOT NULL DEFAULT '';
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE images
+    DROP CONSTRAINT fk_image_duplicate,
+    DROP COLUMN duplicate_of;
\ No newline at end of file
@@ -0,0 +1,7 @@
+ALTER TABLE images
+    ADD duplicate_of INT DEFAULT NULL,
+    ADD CONSTRAINT fk_image_duplicate
+    FOREIGN KEY (duplicate_of)
+    REFERENCES images(id)
+        ON UPDATE CASCADE
+        ON DELETE SET NULL;
\ No newline at end of file
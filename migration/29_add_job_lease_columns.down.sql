@@ -0,0 +1,3 @@
+ALTER TABLE jobs
+    DROP COLUMN heartbeat_at,
+    DROP COLUMN owner;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE jobs
+    ADD owner VARCHAR(64) NOT NULL DEFAULT '',
+    ADD heartbeat_at TIMESTAMP NULL DEFAULT NULL;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE images DROP COLUMN mime_type;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE images ADD mime_type VARCHAR(32) NOT NULL DEFAULT 'image/jpeg';
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE image_renditions;
\ No newline at end of file
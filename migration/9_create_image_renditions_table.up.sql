@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS image_renditions(
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    image_id INT NOT NULL,
+    path VARCHAR(256) NOT NULL UNIQUE,
+    mime_type VARCHAR(32) NOT NULL,
+    width INT,
+    height INT,
+    size BIGINT,
+    CONSTRAINT fk_image_rendition
+    FOREIGN KEY (image_id)
+    REFERENCES images(id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
\ No newline at end of file
@@ -2,15 +2,80 @@ package cameraroll
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
+// WatermarkNone is the watermark of albums whose images are left unmarked
+const WatermarkNone = "none"
+
+// how the images of an album are sorted
+const (
+	SortManual    = "manual"     // in the order set by the admin, the most recently added first otherwise
+	SortDateTaken = "date_taken" // oldest shot first, images without a date last
+	SortUploaded  = "uploaded"   // most recently uploaded first
+)
+
+// ValidSortMode checks if images can be sorted by mode
+func ValidSortMode(mode string) bool {
+	return mode == SortManual || mode == SortDateTaken || mode == SortUploaded
+}
+
 type Album struct {
-	ID          int64     `json:"id"`
-	CreatedAt   time.Time `json:"created_at,omitempty"`
-	Title       string    `json:"title,omitempty"`
-	Description string    `json:"description,omitempty"`
-	Cover       *Image    `json:"cover,omitempty"`
+	ID          int64      `json:"id"`
+	CreatedAt   time.Time  `json:"created_at,omitempty"`
+	Title       string     `json:"title,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Watermark   string     `json:"watermark,omitempty"` // name of the watermark used instead of the default one
+	SortMode    string     `json:"sort_mode,omitempty"`
+	CoverID     int64      `json:"cover_id,omitempty"`  // id of the pinned cover, 0 to pick the most recently added image
+	ParentID    int64      `json:"parent_id,omitempty"` // id of the album this one is nested in, 0 at the top level
+	Rule        *SmartRule `json:"rule,omitempty"`      // picks the images of a smart album, nil for albums curated by hand
+	Cover       *Image     `json:"cover,omitempty"`
+}
+
+// SmartRule picks the images of a smart album, which have to meet every condition that's set.
+// Ranges include both of their ends, except for TakenBefore.
+type SmartRule struct {
+	Tags           []string   `json:"tags,omitempty"` // names or aliases of tags, images under tags nested in them count too
+	TakenAfter     *time.Time `json:"taken_after,omitempty"`
+	TakenBefore    *time.Time `json:"taken_before,omitempty"`
+	MinFocalLength *float64   `json:"min_focal_length,omitempty"` // in millimeters
+	MaxFocalLength *float64   `json:"max_focal_length,omitempty"`
+	MinAperture    *float64   `json:"min_aperture,omitempty"` // f-number
+	MaxAperture    *float64   `json:"max_aperture,omitempty"`
+	MinISO         *int       `json:"min_iso,omitempty"`
+	MaxISO         *int       `json:"max_iso,omitempty"`
+	CameraMake     string     `json:"camera_make,omitempty"`
+	CameraModel    string     `json:"camera_model,omitempty"`
+	Lens           string     `json:"lens,omitempty"`
+}
+
+// Validate checks that a rule can pick any image at all
+func (rule *SmartRule) Validate() error {
+	for _, tag := range rule.Tags {
+		if len(tag) == 0 {
+			return errors.New("empty tag name in rule")
+		}
+	}
+
+	if rule.TakenAfter != nil && rule.TakenBefore != nil && !rule.TakenAfter.Before(*rule.TakenBefore) {
+		return errors.New("taken_after must be before taken_before")
+	}
+
+	if rule.MinFocalLength != nil && rule.MaxFocalLength != nil && *rule.MinFocalLength > *rule.MaxFocalLength {
+		return errors.New("min_focal_length is above max_focal_length")
+	}
+
+	if rule.MinAperture != nil && rule.MaxAperture != nil && *rule.MinAperture > *rule.MaxAperture {
+		return errors.New("min_aperture is above max_aperture")
+	}
+
+	if rule.MinISO != nil && rule.MaxISO != nil && *rule.MinISO > *rule.MaxISO {
+		return errors.New("min_iso is above max_iso")
+	}
+
+	return nil
 }
 
 type AlbumService interface {
@@ -18,5 +83,8 @@ type AlbumService interface {
 	GetAlbums(ctx context.Context, start uint64, count uint64) ([]*Album, error)
 	GetAlbumByID(ctx context.Context, id int64) (*Album, error)
 	UpdateAlbumByID(ctx context.Context, id int64, newAlb *Album) error
-	DeleteAlbumByID(ctx context.Context, id int64) error
+	UpdateCoverOfAlbum(ctx context.Context, id int64, imageID int64) error
+	DeleteAlbumByID(ctx context.Context, id int64, cascade bool) error
+	GetChildrenOfAlbum(ctx context.Context, id int64) ([]*Album, error)
+	GetPathOfAlbum(ctx context.Context, id int64) ([]*Album, error)
 }
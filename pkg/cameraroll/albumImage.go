@@ -11,6 +11,8 @@ type AlbumImage struct {
 type AlbumImageService interface {
 	AddImageToAlbum(ctx context.Context, albumID int64, imageID int64) error
 	GetImagesFromAlbum(ctx context.Context, id int64) ([]*Image, error)
+	ReorderImagesOfAlbum(ctx context.Context, albumID int64, imageIDs []int64) error
 	GetAlbumsOfImage(ctx context.Context, id int64) ([]*Album, error)
+	GetWatermarksOfImage(ctx context.Context, id int64) ([]string, error)
 	RemoveImageFromAlbum(ctx context.Context, albumID int64, imageID int64) error
 }
@@ -10,7 +10,7 @@ type AlbumTag struct {
 
 type AlbumTagService interface {
 	AddTagToAlbum(ctx context.Context, albumID int64, tagID int64) error
-	GetAlbumsWithTag(ctx context.Context, tagID int64, start uint64, count uint64) ([]*Album, error)
+	GetAlbumsWithTag(ctx context.Context, tagID int64, includeDescendants bool, start uint64, count uint64) ([]*Album, error)
 	GetTagsOfAlbum(ctx context.Context, albumID int64) ([]*Tag, error)
 	RemoveTagFromAlbum(ctx context.Context, albumID int64, tagID int64) error
 }
@@ -0,0 +1,29 @@
+package cameraroll
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrAssetNotFound is returned by an AssetStore when the requested key doesn't exist
+var ErrAssetNotFound = errors.New("asset not found")
+
+// AssetInfo describes a blob kept in an AssetStore
+type AssetInfo struct {
+	Key         string    `json:"key"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type,omitempty"`
+	ModTime     time.Time `json:"mod_time"`
+}
+
+// AssetStore is the blob storage that holds image files and their derivatives.
+// Keys are slash separated paths relative to the root of the store.
+type AssetStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Delete(ctx context.Context, key string) error
+	Stat(ctx context.Context, key string) (*AssetInfo, error)
+	List(ctx context.Context, prefix string) ([]*AssetInfo, error)
+}
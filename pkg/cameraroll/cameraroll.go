@@ -7,4 +7,6 @@ type Service interface {
 	AlbumImageService
 	AlbumTagService
 	ImageTagService
+	JobService
+	EditService
 }
@@ -0,0 +1,8 @@
+package cameraroll
+
+// Duplicate is an image that looks like another one,
+// Distance being the number of bits their perceptual hashes differ in
+type Duplicate struct {
+	*Image
+	Distance int `json:"distance"`
+}
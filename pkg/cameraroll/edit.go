@@ -0,0 +1,34 @@
+package cameraroll
+
+import (
+	"context"
+	"time"
+)
+
+// Edits are non-destructive adjustments of an image.
+// They're applied to its upright original, in the order of the fields,
+// whenever its thumbnail and renditions are rendered. The original is never touched.
+type Edits struct {
+	ImageID        int64     `json:"image_id"`
+	Rotation       int       `json:"rotation"`        // clockwise, one of 0, 90, 180 or 270 degrees
+	FlipHorizontal bool      `json:"flip_horizontal"` // mirror left to right
+	FlipVertical   bool      `json:"flip_vertical"`   // mirror top to bottom
+	Straighten     float64   `json:"straighten"`      // clockwise, between -45 and 45 degrees
+	Crop           *Crop     `json:"crop,omitempty"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Crop is a rectangle relative to the size of the rotated and straightened image,
+// each value between 0 and 1 so that it still fits if the original is replaced
+type Crop struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+type EditService interface {
+	GetEditsOfImage(ctx context.Context, imageID int64) (*Edits, error)
+	UpdateEditsOfImage(ctx context.Context, imageID int64, edits *Edits) error
+	DeleteEditsOfImage(ctx context.Context, imageID int64) error
+}
@@ -0,0 +1,19 @@
+package cameraroll
+
+import "time"
+
+// Exif is the shooting information extracted from an image's EXIF data.
+// Fields are left empty when the camera didn't record them.
+type Exif struct {
+	CameraMake   string     `json:"camera_make,omitempty"`
+	CameraModel  string     `json:"camera_model,omitempty"`
+	Lens         string     `json:"lens,omitempty"`
+	FocalLength  *float64   `json:"focal_length,omitempty"`  // in millimeters
+	Aperture     *float64   `json:"aperture,omitempty"`      // f-number
+	ShutterSpeed string     `json:"shutter_speed,omitempty"` // e.g. 1/250
+	ExposureTime *float64   `json:"exposure_time,omitempty"` // in seconds
+	ISO          *int       `json:"iso,omitempty"`
+	DateTaken    *time.Time `json:"date_taken,omitempty"`
+	Latitude     *float64   `json:"latitude,omitempty"`
+	Longitude    *float64   `json:"longitude,omitempty"`
+}
@@ -5,17 +5,37 @@ import (
 	"time"
 )
 
+// processing statuses of images
+const (
+	ProcessingPending = "pending" // waiting in the job queue
+	ProcessingRunning = "processing"
+	ProcessingReady   = "ready"
+	ProcessingFailed  = "failed"
+)
+
 type Image struct {
-	ID              int64     `json:"id"`
-	Path            string    `json:"path"`
-	Width           int       `json:"width"`
-	Height          int       `json:"height"`
-	Thumbnail       string    `json:"thumbnail"`
-	ThumbnailWidth  int       `json:"width_thumb"`
-	ThumbnailHeight int       `json:"height_thumb"`
-	Title           string    `json:"title,omitempty"`
-	Description     string    `json:"description,omitempty"`
-	CreatedAt       time.Time `json:"created_at,omitempty"`
+	ID               int64     `json:"id"`
+	Path             string    `json:"path"`
+	MimeType         string    `json:"mime_type"`
+	Width            int       `json:"width"`
+	Height           int       `json:"height"`
+	Thumbnail        string    `json:"thumbnail"`
+	ThumbnailWidth   int       `json:"width_thumb"`
+	ThumbnailHeight  int       `json:"height_thumb"`
+	Title            string    `json:"title,omitempty"`
+	Description      string    `json:"description,omitempty"`
+	CreatedAt        time.Time `json:"created_at,omitempty"`
+	PerceptualHash   *uint64   `json:"-"`
+	ProcessingStatus string    `json:"processing_status"`
+	FocalX           float64   `json:"focal_x"`                // point kept in frame when cropping, from 0 (left) to 1 (right)
+	FocalY           float64   `json:"focal_y"`                // point kept in frame when cropping, from 0 (top) to 1 (bottom)
+	ColorSpace       string    `json:"color_space"`            // name of the ICC profile of the original, empty if untagged
+	DuplicateOf      int64     `json:"duplicate_of,omitempty"` // id of the closest existing image it looks like, 0 if none
+
+	Exif
+	Placeholder
+
+	Renditions []*Rendition `json:"renditions"`
 }
 
 type ImageService interface {
@@ -24,4 +44,9 @@ type ImageService interface {
 	GetImageByID(ctx context.Context, id int64) (*Image, error)
 	UpdateImageByID(ctx context.Context, id int64, newImg *Image) error
 	DeleteImageByID(ctx context.Context, id int64) error
+	UpdatePlaceholderOfImage(ctx context.Context, id int64, placeholder Placeholder) error
+	GetDuplicatesOfImage(ctx context.Context, id int64, hash uint64, maxDistance int) ([]*Duplicate, error)
+	UpdateDerivativesOfImage(ctx context.Context, id int64, img *Image) error
+	UpdateProcessingStatusOfImage(ctx context.Context, id int64, status string) error
+	GetRenditionsOfImage(ctx context.Context, id int64) ([]*Rendition, error)
 }
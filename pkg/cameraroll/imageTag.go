@@ -10,7 +10,7 @@ type ImageTag struct {
 
 type ImageTagService interface {
 	AddTagToImage(ctx context.Context, imageID int64, tagID int64) error
-	GetImagesWithTag(ctx context.Context, tagID int64, start uint64, count uint64) ([]*Image, error)
+	GetImagesWithTag(ctx context.Context, tagID int64, includeDescendants bool, start uint64, count uint64) ([]*Image, error)
 	GetTagsOfImage(ctx context.Context, imageID int64) ([]*Tag, error)
 	RemoveTagFromImage(ctx context.Context, imageID int64, tagID int64) error
 }
@@ -0,0 +1,61 @@
+package cameraroll
+
+import (
+	"context"
+	"time"
+)
+
+// kinds of jobs
+const (
+	JobProcessImage = "process_image" // derive thumbnail, renditions and metadata from the original
+)
+
+// statuses of jobs
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Job is a unit of background work on an image
+type Job struct {
+	ID        int64     `json:"id"`
+	Kind      string    `json:"kind"`
+	ImageID   int64     `json:"image_id"`
+	Batch     string    `json:"batch,omitempty"` // groups the jobs queued together
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+	Owner     string    `json:"owner,omitempty"` // the process that claimed the job last
+}
+
+// JobProgress counts the jobs of a batch by status
+type JobProgress struct {
+	Queued  int `json:"queued"`
+	Running int `json:"running"`
+	Done    int `json:"done"`
+	Failed  int `json:"failed"`
+}
+
+// Total is the number of jobs in the batch
+func (p JobProgress) Total() int {
+	return p.Queued + p.Running + p.Done + p.Failed
+}
+
+// Finished checks if there's nothing left to run in the batch
+func (p JobProgress) Finished() bool {
+	return p.Queued == 0 && p.Running == 0
+}
+
+type JobService interface {
+	AddJob(ctx context.Context, job *Job) error
+	ClaimJob(ctx context.Context, owner string) (*Job, error)
+	UpdateJobStatus(ctx context.Context, id int64, owner string, status string, message string) error
+	HeartbeatJobs(ctx context.Context, owner string) error
+	RequeueExpiredJobs(ctx context.Context, lease time.Duration, maxAttempts int) error
+	GetJobProgress(ctx context.Context, batch string) (*JobProgress, error)
+	DeleteJobsOfBatch(ctx context.Context, batch string) error
+}
@@ -0,0 +1,8 @@
+package cameraroll
+
+// Placeholder is what a client shows while an image is still loading
+type Placeholder struct {
+	BlurHash string `json:"blurhash,omitempty"`
+	LQIP     string `json:"lqip,omitempty"`  // tiny copy of the image as a base64 data URI
+	Color    string `json:"color,omitempty"` // average color in #rrggbb format
+}
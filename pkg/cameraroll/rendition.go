@@ -0,0 +1,15 @@
+package cameraroll
+
+// Rendition is a resized copy of an image, used for responsive srcset
+type Rendition struct {
+	ID       int64  `json:"id,omitempty"`
+	ImageID  int64  `json:"image_id,omitempty"`
+	URL      string `json:"url"`
+	MimeType string `json:"mime_type"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Size     int64  `json:"size"`
+
+	// album watermark the rendition carries instead of the default one, empty for the default one
+	Watermark string `json:"-"`
+}
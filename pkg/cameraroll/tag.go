@@ -5,14 +5,20 @@ import (
 )
 
 type Tag struct {
-	ID   int64  `json:"id"`
-	Name string `json:"name"`
+	ID       int64    `json:"id"`
+	Name     string   `json:"name"`
+	ParentID int64    `json:"parent_id,omitempty"` // id of the broader tag this one narrows down, 0 at the top level
+	Aliases  []string `json:"aliases,omitempty"`   // other names that resolve to this tag
 }
 
 type TagService interface {
 	AddTag(ctx context.Context, tag *Tag) error
 	GetTags(ctx context.Context) ([]*Tag, error)
 	GetTagByID(ctx context.Context, id int64) (*Tag, error)
+	GetTagByName(ctx context.Context, name string) (*Tag, error)
 	UpdateTagByID(ctx context.Context, id int64, newTag *Tag) error
 	DeleteTagByID(ctx context.Context, id int64) error
+	AddAliasToTag(ctx context.Context, id int64, alias string) error
+	RemoveAliasFromTag(ctx context.Context, id int64, alias string) error
+	MergeTags(ctx context.Context, id int64, duplicateID int64) error
 }
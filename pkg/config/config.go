@@ -25,12 +25,53 @@ const (
 	ProdMode = "prod"
 )
 
+// default derivatives of uploaded images
+const defaultThumbnailSize = 400
+
+var defaultRenditionWidths = []int{320, 640, 1280, 2048}
+
+// maximum Hamming distance between the perceptual hashes of near-duplicates
+const defaultDuplicateDistance = 8
+
+// default limits of uploaded images, in megabytes and megapixels
+const (
+	defaultMaxFileSize   = 100
+	defaultMaxBatchSize  = 1024
+	defaultMaxMegapixels = 100
+)
+
+// publicly served originals carry no metadata unless told otherwise
+const defaultMetadataPolicy = "strip_all"
+
+// default number of goroutines processing images in the background
+const defaultWorkers = 2
+
+// default size of the resize cache in megabytes
+const defaultResizeCacheSize = 512
+
+// renditions narrower than this many pixels are left unmarked by default
+const defaultWatermarkMinWidth = 1280
+
+// default placement of watermarks
+const (
+	defaultWatermarkPosition = "bottom_right"
+	defaultWatermarkOpacity  = 0.5
+	defaultWatermarkScale    = 0.2
+)
+
+const (
+	LocalStorage = "local"
+	S3Storage    = "s3"
+)
+
 const (
-	addressKey   = "DB_ADDR"
-	userKey      = "DB_USER"
-	passwordKey  = "DB_PASS"
-	nameKey      = "DB_NAME"
-	jwtSecretKey = "JWT_SECRET"
+	addressKey     = "DB_ADDR"
+	userKey        = "DB_USER"
+	passwordKey    = "DB_PASS"
+	nameKey        = "DB_NAME"
+	jwtSecretKey   = "JWT_SECRET"
+	s3AccessKeyKey = "S3_ACCESS_KEY"
+	s3SecretKeyKey = "S3_SECRET_KEY"
 )
 
 // DatabaseSettings contains the configs of the MySQL database that the server connects to
@@ -47,6 +88,61 @@ type GoogleOAuthSettings struct {
 	ClientSecret string `json:"client_secret"`
 }
 
+// S3Settings contains the configs of an S3 compatible object storage
+type S3Settings struct {
+	Endpoint      string `json:"endpoint"` // endpoint in host:port format
+	Region        string `json:"region"`
+	Bucket        string `json:"bucket"`
+	PrivateBucket string `json:"private_bucket"` // bucket holding the untouched originals, never served publicly
+	AccessKey     string `json:"access_key"`
+	SecretKey     string `json:"secret_key"`
+	UseSSL        bool   `json:"use_ssl"`
+}
+
+// StorageSettings decides where uploaded images and their derivatives are kept
+type StorageSettings struct {
+	Driver string      `json:"driver"` // either "local" or "s3"
+	S3     *S3Settings `json:"s3"`
+}
+
+// ImageSettings controls the derivatives generated from every uploaded image
+type ImageSettings struct {
+	ThumbnailSize     int     `json:"thumbnail_size"`     // maximum width and height of thumbnails in pixels
+	RenditionWidths   []int   `json:"rendition_widths"`   // widths of the responsive renditions in pixels
+	MetadataPolicy    string  `json:"metadata_policy"`    // "strip_all", "strip_gps" or "keep"
+	DuplicateDistance *int    `json:"duplicate_distance"` // maximum number of bits the perceptual hashes of near-duplicates differ in, 0 for exact matches
+	MaxFileSize       int64   `json:"max_file_size"`      // maximum size of an uploaded file in megabytes
+	MaxBatchSize      int64   `json:"max_batch_size"`     // maximum size of all the files of a batch upload together in megabytes
+	MaxMegapixels     float64 `json:"max_megapixels"`     // maximum number of pixels of an uploaded image in millions
+}
+
+// ResizeSettings controls the on-the-fly resizing of original images
+type ResizeSettings struct {
+	Sizes     []string `json:"sizes"`      // whitelist of sizes in WxH format, a side of 0 is unbounded
+	CacheSize int64    `json:"cache_size"` // maximum size of the disk cache in megabytes
+}
+
+// MarkSettings describes a watermark, either a picture or a line of text
+type MarkSettings struct {
+	Image    string   `json:"image"`    // path to a picture, relative to the binary; takes precedence over the text
+	Text     string   `json:"text"`     // line of text drawn in white
+	Position string   `json:"position"` // "top_left", "top_right", "bottom_left", "bottom_right" or "center"
+	Opacity  *float64 `json:"opacity"`  // from 0 to 1
+	Scale    float64  `json:"scale"`    // width of the mark relative to the width of the rendition
+}
+
+// WatermarkSettings controls the marks composited onto the public renditions of images
+type WatermarkSettings struct {
+	MinWidth *int                     `json:"min_width"` // renditions narrower than this are left unmarked, 0 marks all of them
+	Default  *MarkSettings            `json:"default"`   // mark of every image, none if left out
+	Albums   map[string]*MarkSettings `json:"albums"`    // named marks that albums can use instead
+}
+
+// JobSettings controls the background processing of uploaded images
+type JobSettings struct {
+	Workers int `json:"workers"` // number of goroutines running jobs
+}
+
 // Config contains all the configs this server requires
 type Config struct {
 	Mode        string
@@ -57,15 +153,51 @@ type Config struct {
 	AdminID     string               `json:"admin_account"`
 	GoogleOAuth *GoogleOAuthSettings `json:"google_oauth"`
 	Database    *DatabaseSettings    `json:"database"`
+	Storage     *StorageSettings     `json:"storage"`
+	Images      *ImageSettings       `json:"images"`
+	Resize      *ResizeSettings      `json:"resize"`
+	Watermark   *WatermarkSettings   `json:"watermark"`
+	Jobs        *JobSettings         `json:"jobs"`
 }
 
-func (config *Config) loadFromFile() {
-	// find the path to current executable
+// setDefaults fills in the placement of a mark left out of the config file
+func (mark *MarkSettings) setDefaults(dir string) {
+	if mark == nil {
+		return
+	}
+
+	if len(mark.Image) > 0 && !filepath.IsAbs(mark.Image) {
+		mark.Image = filepath.Join(dir, mark.Image)
+	}
+
+	if len(mark.Position) == 0 {
+		mark.Position = defaultWatermarkPosition
+	}
+
+	// an opacity of 0 is kept, it hides the mark
+	if mark.Opacity == nil {
+		opacity := defaultWatermarkOpacity
+		mark.Opacity = &opacity
+	}
+
+	if mark.Scale <= 0 {
+		mark.Scale = defaultWatermarkScale
+	}
+}
+
+// executableDir finds the folder of the running binary
+func executableDir() string {
 	ex, err := os.Executable()
 	if err != nil {
 		panic(err)
 	}
-	exPath := filepath.Dir(ex)
+
+	return filepath.Dir(ex)
+}
+
+func (config *Config) loadFromFile() {
+	// find the path to current executable
+	exPath := executableDir()
 
 	// open config.json file
 	file, err := os.Open(filepath.Join(exPath, filename))
@@ -116,6 +248,100 @@ func (config *Config) loadFromEnv(suffix string) {
 	if len(jwtSecret) > 0 {
 		config.JWTSecret = jwtSecret
 	}
+
+	if config.Storage.S3 != nil {
+		s3AccessKey := os.Getenv(s3AccessKeyKey + suffix)
+		if len(s3AccessKey) > 0 {
+			config.Storage.S3.AccessKey = s3AccessKey
+		}
+
+		s3SecretKey := os.Getenv(s3SecretKeyKey + suffix)
+		if len(s3SecretKey) > 0 {
+			config.Storage.S3.SecretKey = s3SecretKey
+		}
+	}
+}
+
+// setDefaults fills in the settings left out of the config file,
+// resolving the paths of watermark images relative to dir
+func (config *Config) setDefaults(dir string) {
+	// keep assets on the local filesystem unless told otherwise
+	if config.Storage == nil {
+		config.Storage = &StorageSettings{Driver: LocalStorage}
+	}
+
+	// fall back to the default derivatives
+	if config.Images == nil {
+		config.Images = &ImageSettings{}
+	}
+
+	if config.Images.ThumbnailSize <= 0 {
+		config.Images.ThumbnailSize = defaultThumbnailSize
+	}
+
+	if config.Images.RenditionWidths == nil {
+		config.Images.RenditionWidths = defaultRenditionWidths
+	}
+
+	// a distance of 0 is kept, it only flags exact matches
+	if config.Images.DuplicateDistance == nil {
+		distance := defaultDuplicateDistance
+		config.Images.DuplicateDistance = &distance
+	}
+
+	if config.Images.MaxFileSize <= 0 {
+		config.Images.MaxFileSize = defaultMaxFileSize
+	}
+
+	if config.Images.MaxBatchSize <= 0 {
+		config.Images.MaxBatchSize = defaultMaxBatchSize
+	}
+
+	if config.Images.MaxMegapixels <= 0 {
+		config.Images.MaxMegapixels = defaultMaxMegapixels
+	}
+
+	if len(config.Images.MetadataPolicy) == 0 {
+		config.Images.MetadataPolicy = defaultMetadataPolicy
+	}
+
+	// originals are kept in a bucket of their own
+	if config.Storage.S3 != nil && len(config.Storage.S3.PrivateBucket) == 0 {
+		config.Storage.S3.PrivateBucket = config.Storage.S3.Bucket + "-private"
+	}
+
+	// nothing can be resized on the fly unless it's whitelisted
+	if config.Resize == nil {
+		config.Resize = &ResizeSettings{}
+	}
+
+	if config.Resize.CacheSize <= 0 {
+		config.Resize.CacheSize = defaultResizeCacheSize
+	}
+
+	// nothing gets a watermark unless configured
+	if config.Watermark == nil {
+		config.Watermark = &WatermarkSettings{}
+	}
+
+	// a width of 0 is kept, it marks every rendition
+	if config.Watermark.MinWidth == nil {
+		minWidth := defaultWatermarkMinWidth
+		config.Watermark.MinWidth = &minWidth
+	}
+
+	config.Watermark.Default.setDefaults(dir)
+	for _, mark := range config.Watermark.Albums {
+		mark.setDefaults(dir)
+	}
+
+	if config.Jobs == nil {
+		config.Jobs = &JobSettings{}
+	}
+
+	if config.Jobs.Workers <= 0 {
+		config.Jobs.Workers = defaultWorkers
+	}
 }
 
 func NewConfig() *Config {
@@ -125,6 +351,9 @@ func NewConfig() *Config {
 	// read config.json first
 	config.loadFromFile()
 
+	// fill in whatever the file left out
+	config.setDefaults(executableDir())
+
 	// check if it's dev or test mode
 	mode := os.Getenv(modeKey)
 	suffix := ""
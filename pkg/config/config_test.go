@@ -0,0 +1,123 @@
+package config
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSetDefaultsEmpty(t *testing.T) {
+	config := Config{}
+	config.setDefaults("/opt/cameraroll")
+
+	if config.Storage == nil || config.Storage.Driver != LocalStorage {
+		t.Errorf("storage = %+v, want the local driver", config.Storage)
+	}
+
+	images := config.Images
+	if images.ThumbnailSize != defaultThumbnailSize ||
+		!reflect.DeepEqual(images.RenditionWidths, defaultRenditionWidths) ||
+		images.MetadataPolicy != defaultMetadataPolicy ||
+		*images.DuplicateDistance != defaultDuplicateDistance ||
+		images.MaxFileSize != defaultMaxFileSize ||
+		images.MaxBatchSize != defaultMaxBatchSize ||
+		images.MaxMegapixels != defaultMaxMegapixels {
+		t.Errorf("images = %+v, want the defaults", images)
+	}
+
+	if config.Resize.CacheSize != defaultResizeCacheSize || len(config.Resize.Sizes) != 0 {
+		t.Errorf("resize = %+v, want the default cache and no sizes", config.Resize)
+	}
+
+	if *config.Watermark.MinWidth != defaultWatermarkMinWidth || config.Watermark.Default != nil {
+		t.Errorf("watermark = %+v, want the default width and no mark", config.Watermark)
+	}
+
+	if config.Jobs.Workers != defaultWorkers {
+		t.Errorf("workers = %d, want %d", config.Jobs.Workers, defaultWorkers)
+	}
+}
+
+func TestSetDefaultsKeepsSettings(t *testing.T) {
+	file := `{
+		"storage": {"driver": "s3", "s3": {"bucket": "photos"}},
+		"images": {
+			"thumbnail_size": 300,
+			"rendition_widths": [],
+			"metadata_policy": "keep",
+			"duplicate_distance": 0,
+			"max_file_size": 20,
+			"max_batch_size": 200,
+			"max_megapixels": 50
+		},
+		"resize": {"sizes": ["200x200"], "cache_size": 64},
+		"watermark": {"min_width": 0, "default": {"text": "© Me", "opacity": 0}},
+		"jobs": {"workers": 4}
+	}`
+
+	config := Config{}
+	if err := json.Unmarshal([]byte(file), &config); err != nil {
+		t.Fatal(err)
+	}
+	config.setDefaults("/opt/cameraroll")
+
+	// zeros are settings of their own, not left out
+	distance := 0
+	want := ImageSettings{
+		ThumbnailSize:     300,
+		RenditionWidths:   []int{},
+		MetadataPolicy:    "keep",
+		DuplicateDistance: &distance,
+		MaxFileSize:       20,
+		MaxBatchSize:      200,
+		MaxMegapixels:     50,
+	}
+	if !reflect.DeepEqual(*config.Images, want) {
+		t.Errorf("images = %+v, want %+v", *config.Images, want)
+	}
+
+	// originals go to a bucket of their own
+	if config.Storage.S3.PrivateBucket != "photos-private" {
+		t.Errorf("private bucket = %s, want photos-private", config.Storage.S3.PrivateBucket)
+	}
+
+	if config.Resize.CacheSize != 64 || *config.Watermark.MinWidth != 0 || config.Jobs.Workers != 4 {
+		t.Errorf("resize = %+v, watermark = %+v, jobs = %+v", config.Resize, config.Watermark, config.Jobs)
+	}
+
+	if opacity := *config.Watermark.Default.Opacity; opacity != 0 {
+		t.Errorf("default mark opacity = %g, want 0", opacity)
+	}
+}
+
+func TestSetDefaultsOfMarks(t *testing.T) {
+	absolute := filepath.Join(t.TempDir(), "logo.png")
+
+	opacity := 0.3
+	config := Config{
+		Watermark: &WatermarkSettings{
+			Default: &MarkSettings{Text: "© Me"},
+			Albums: map[string]*MarkSettings{
+				"client":  {Image: "client.png", Position: "center", Opacity: &opacity, Scale: 0.5},
+				"partner": {Image: absolute},
+			},
+		},
+	}
+	config.setDefaults("/opt/cameraroll")
+
+	mark := config.Watermark.Default
+	if mark.Text != "© Me" || mark.Position != defaultWatermarkPosition || *mark.Opacity != defaultWatermarkOpacity || mark.Scale != defaultWatermarkScale {
+		t.Errorf("default mark = %+v, want the default placement", *mark)
+	}
+
+	// images are found next to the binary unless given in full
+	client := config.Watermark.Albums["client"]
+	if client.Image != filepath.Join("/opt/cameraroll", "client.png") || client.Position != "center" || *client.Opacity != 0.3 || client.Scale != 0.5 {
+		t.Errorf("client mark = %+v, want its own placement", *client)
+	}
+
+	if partner := config.Watermark.Albums["partner"]; partner.Image != absolute {
+		t.Errorf("partner image = %s, want %s", partner.Image, absolute)
+	}
+}
@@ -0,0 +1,146 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+
+	// register decoders for image.Decode
+	_ "image/gif"
+
+	_ "golang.org/x/image/webp"
+
+	"github.com/chai2010/webp"
+)
+
+// names of the image formats, as reported by image.Decode
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+	FormatWebP = "webp"
+	FormatAVIF = "avif"
+)
+
+// quality of lossy WebP encoding, from 0 to 100
+const webpQuality = 80
+
+// Decode decodes an image in any of the supported formats,
+// returning the name of the format along with the image
+func Decode(r io.Reader) (image.Image, string, error) {
+	img, format, err := image.Decode(r)
+	if err != nil {
+		return nil, format, fmt.Errorf("Decode: %v", err)
+	}
+
+	return img, format, nil
+}
+
+// DecodeConfig reads the dimensions and format of an image without decoding all of it
+func DecodeConfig(r io.Reader) (image.Config, string, error) {
+	config, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return config, format, fmt.Errorf("DecodeConfig: %v", err)
+	}
+
+	return config, format, nil
+}
+
+// MimeType returns the MIME type of an image format
+func MimeType(format string) string {
+	return "image/" + format
+}
+
+// Extension returns the file extension used for an image format
+func Extension(format string) string {
+	if format == FormatJPEG {
+		return "jpg"
+	}
+
+	return format
+}
+
+// FormatFromExtension finds the output format for a file extension,
+// reporting false if images cannot be encoded in that format
+func FormatFromExtension(ext string) (string, bool) {
+	switch strings.ToLower(ext) {
+	case "jpg", "jpeg":
+		return FormatJPEG, true
+	case "png":
+		return FormatPNG, true
+	default:
+		return "", false
+	}
+}
+
+// OutputFormat picks the format that derivatives of img are encoded in:
+// PNG if img has any transparency, JPEG otherwise
+func OutputFormat(img image.Image) string {
+	if o, ok := img.(interface{ Opaque() bool }); ok && !o.Opaque() {
+		return FormatPNG
+	}
+
+	return FormatJPEG
+}
+
+// EncodeTagged encodes img in the given output format,
+// tagging it with an ICC profile unless profile is nil
+func EncodeTagged(img image.Image, format string, profile []byte) ([]byte, error) {
+	buf := bytes.Buffer{}
+	if err := Encode(&buf, img, format); err != nil {
+		return nil, err
+	}
+
+	if profile == nil {
+		return buf.Bytes(), nil
+	}
+
+	return EmbedColorProfile(buf.Bytes(), format, profile)
+}
+
+// Encode writes img to w in the given output format
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case FormatJPEG:
+		return jpeg.Encode(w, img, nil)
+	case FormatPNG:
+		return png.Encode(w, img)
+	case FormatWebP:
+		return encodeWebP(w, img)
+	default:
+		return fmt.Errorf("Encode: unsupported output format [%s]", format)
+	}
+}
+
+// CanEncode checks if images can be encoded in a format
+func CanEncode(format string) bool {
+	switch format {
+	case FormatJPEG, FormatPNG, FormatWebP:
+		return true
+	default:
+		return false
+	}
+}
+
+// encodeWebP writes img to w as a lossy WebP, dropping the alpha channel of opaque images
+func encodeWebP(w io.Writer, img image.Image) error {
+	var data []byte
+	var err error
+
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		data, err = webp.EncodeRGB(img, webpQuality)
+	} else {
+		data, err = webp.EncodeRGBA(img, webpQuality)
+	}
+
+	if err != nil {
+		return fmt.Errorf("Encode: %v", err)
+	}
+
+	_, err = w.Write(data)
+	return err
+}
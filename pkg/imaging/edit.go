@@ -0,0 +1,137 @@
+package imaging
+
+import (
+	"image"
+	"math"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+)
+
+// ApplyEdits renders the edits of an image onto its upright original:
+// rotation, flips, straightening and at last the crop
+func ApplyEdits(img image.Image, edits *cameraroll.Edits) image.Image {
+	if edits == nil {
+		return img
+	}
+
+	// quarter turns and flips move pixels around like EXIF orientations do
+	switch edits.Rotation {
+	case 90:
+		img = Orient(img, OrientationRotate90CW)
+	case 180:
+		img = Orient(img, OrientationRotate180)
+	case 270:
+		img = Orient(img, OrientationRotate90CCW)
+	}
+
+	if edits.FlipHorizontal {
+		img = Orient(img, OrientationFlipH)
+	}
+
+	if edits.FlipVertical {
+		img = Orient(img, OrientationFlipV)
+	}
+
+	if edits.Straighten != 0 {
+		img = straighten(img, edits.Straighten)
+	}
+
+	if edits.Crop != nil {
+		img = crop(img, edits.Crop)
+	}
+
+	return img
+}
+
+// straighten rotates img clockwise by degrees around its center,
+// then crops it to the largest rectangle of the same aspect ratio without blank corners
+func straighten(img image.Image, degrees float64) image.Image {
+	src := toRGBA(img)
+	w, h := float64(src.Bounds().Dx()), float64(src.Bounds().Dy())
+
+	theta := degrees * math.Pi / 180
+	cos, sin := math.Cos(theta), math.Sin(theta)
+
+	// shrink the rectangle until its rotated corners stay inside the image
+	c, s := math.Abs(cos), math.Abs(sin)
+	scale := math.Min(w/(w*c+h*s), h/(w*s+h*c))
+
+	dstW, dstH := int(w*scale), int(h*scale)
+	if dstW < 1 || dstH < 1 {
+		return img
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			// rotate the center of each destination pixel back onto the source
+			dx := float64(x) + 0.5 - float64(dstW)/2
+			dy := float64(y) + 0.5 - float64(dstH)/2
+			sx := dx*cos + dy*sin + w/2 - 0.5
+			sy := -dx*sin + dy*cos + h/2 - 0.5
+
+			di := dst.PixOffset(x, y)
+			bilinear(src, sx, sy, dst.Pix[di:di+4])
+		}
+	}
+
+	return dst
+}
+
+// bilinear samples src at a fractional position, writing the RGBA value to out
+func bilinear(src *image.RGBA, x float64, y float64, out []uint8) {
+	maxX, maxY := src.Bounds().Dx()-1, src.Bounds().Dy()-1
+
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	fx, fy := x-float64(x0), y-float64(y0)
+
+	x1, y1 := clamp(x0+1, 0, maxX), clamp(y0+1, 0, maxY)
+	x0, y0 = clamp(x0, 0, maxX), clamp(y0, 0, maxY)
+
+	p00 := src.PixOffset(x0, y0)
+	p10 := src.PixOffset(x1, y0)
+	p01 := src.PixOffset(x0, y1)
+	p11 := src.PixOffset(x1, y1)
+
+	for i := 0; i < 4; i++ {
+		top := float64(src.Pix[p00+i])*(1-fx) + float64(src.Pix[p10+i])*fx
+		bottom := float64(src.Pix[p01+i])*(1-fx) + float64(src.Pix[p11+i])*fx
+		out[i] = uint8(top*(1-fy) + bottom*fy + 0.5)
+	}
+}
+
+// crop cuts out a rectangle given relative to the size of img, keeping at least a pixel.
+// The result shares its pixels with img.
+func crop(img image.Image, rect *cameraroll.Crop) image.Image {
+	b := img.Bounds()
+	w, h := float64(b.Dx()), float64(b.Dy())
+
+	x0 := clamp(int(math.Round(rect.X*w)), 0, b.Dx()-1)
+	y0 := clamp(int(math.Round(rect.Y*h)), 0, b.Dy()-1)
+	x1 := clamp(int(math.Round((rect.X+rect.Width)*w)), x0+1, b.Dx())
+	y1 := clamp(int(math.Round((rect.Y+rect.Height)*h)), y0+1, b.Dy())
+
+	r := image.Rect(x0, y0, x1, y1).Add(b.Min)
+
+	// every image type of the standard library can be cut without copying
+	if sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(r)
+	}
+
+	return toRGBA(img).SubImage(r.Sub(b.Min))
+}
+
+// clamp limits v to the range from lo to hi
+func clamp(v int, lo int, hi int) int {
+	if v < lo {
+		return lo
+	}
+
+	if v > hi {
+		return hi
+	}
+
+	return v
+}
@@ -0,0 +1,140 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+)
+
+// numberedImage is an opaque image whose pixels tell where they came from,
+// the red channel holding x and the green channel y
+func numberedImage(width int, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x), G: uint8(y), A: 255})
+		}
+	}
+
+	return img
+}
+
+// origin finds where a pixel of an edited numberedImage came from
+func origin(img image.Image, x int, y int) image.Point {
+	c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+	return image.Pt(int(c.R), int(c.G))
+}
+
+func TestApplyEditsNone(t *testing.T) {
+	src := numberedImage(4, 3)
+
+	if dst := ApplyEdits(src, nil); dst != image.Image(src) {
+		t.Error("image without edits was changed")
+	}
+
+	if dst := ApplyEdits(src, &cameraroll.Edits{}); dst.Bounds() != src.Bounds() || origin(dst, 3, 2) != image.Pt(3, 2) {
+		t.Error("zero edits changed the image")
+	}
+}
+
+func TestApplyEditsRotationAndFlips(t *testing.T) {
+	src := numberedImage(4, 2)
+
+	tests := []struct {
+		name    string
+		edits   cameraroll.Edits
+		size    image.Point
+		topLeft image.Point // where the top left pixel came from
+	}{
+		{"90", cameraroll.Edits{Rotation: 90}, image.Pt(2, 4), image.Pt(0, 1)},
+		{"180", cameraroll.Edits{Rotation: 180}, image.Pt(4, 2), image.Pt(3, 1)},
+		{"270", cameraroll.Edits{Rotation: 270}, image.Pt(2, 4), image.Pt(3, 0)},
+		{"horizontal flip", cameraroll.Edits{FlipHorizontal: true}, image.Pt(4, 2), image.Pt(3, 0)},
+		{"vertical flip", cameraroll.Edits{FlipVertical: true}, image.Pt(4, 2), image.Pt(0, 1)},
+		{"90 then horizontal flip", cameraroll.Edits{Rotation: 90, FlipHorizontal: true}, image.Pt(2, 4), image.Pt(0, 0)},
+	}
+
+	for _, tt := range tests {
+		dst := ApplyEdits(src, &tt.edits)
+
+		if size := dst.Bounds().Size(); size != tt.size {
+			t.Errorf("%s: size = %v, want %v", tt.name, size, tt.size)
+			continue
+		}
+
+		if got := origin(dst, 0, 0); got != tt.topLeft {
+			t.Errorf("%s: top left pixel came from %v, want %v", tt.name, got, tt.topLeft)
+		}
+	}
+}
+
+func TestApplyEditsCrop(t *testing.T) {
+	src := numberedImage(8, 4)
+
+	dst := ApplyEdits(src, &cameraroll.Edits{Crop: &cameraroll.Crop{X: 0.25, Y: 0.5, Width: 0.5, Height: 0.5}})
+	if size := dst.Bounds().Size(); size != image.Pt(4, 2) {
+		t.Fatalf("size = %v", size)
+	}
+
+	// the crop is cut out of the image, not copied
+	b := dst.Bounds()
+	if got := origin(dst, b.Min.X, b.Min.Y); got != image.Pt(2, 2) {
+		t.Errorf("top left pixel came from %v, want (2,2)", got)
+	}
+
+	if _, ok := dst.(*image.RGBA); !ok || &dst.(*image.RGBA).Pix[0] != &src.Pix[src.PixOffset(2, 2)] {
+		t.Error("crop copied the pixels")
+	}
+
+	// a crop of a crop is relative to its own bounds
+	dst = ApplyEdits(dst, &cameraroll.Edits{Crop: &cameraroll.Crop{X: 0.5, Y: 0.5, Width: 0.5, Height: 0.5}})
+	if b := dst.Bounds(); b.Size() != image.Pt(2, 1) || origin(dst, b.Min.X, b.Min.Y) != image.Pt(4, 3) {
+		t.Errorf("crop of a crop = %v from %v, want 2x1 from (4,3)", b, origin(dst, b.Min.X, b.Min.Y))
+	}
+
+	// the crop is relative to the rotated image
+	dst = ApplyEdits(src, &cameraroll.Edits{Rotation: 90, Crop: &cameraroll.Crop{X: 0, Y: 0, Width: 0.5, Height: 0.25}})
+	if size := dst.Bounds().Size(); size != image.Pt(2, 2) {
+		t.Fatalf("rotated size = %v", size)
+	}
+
+	// a crop out of the image still keeps a pixel
+	dst = ApplyEdits(src, &cameraroll.Edits{Crop: &cameraroll.Crop{X: 2, Y: -1, Width: 0, Height: 0}})
+	if size := dst.Bounds().Size(); size != image.Pt(1, 1) {
+		t.Errorf("out of bounds crop = %v", size)
+	}
+}
+
+func TestApplyEditsStraighten(t *testing.T) {
+	src := numberedImage(200, 100)
+
+	for _, degrees := range []float64{-30, 5, 45} {
+		dst := ApplyEdits(src, &cameraroll.Edits{Straighten: degrees})
+		b := dst.Bounds()
+
+		if b.Dx() >= 200 || b.Dy() >= 100 {
+			t.Errorf("%g degrees: size = %v, want smaller than the original", degrees, b.Size())
+		}
+
+		if aspect := float64(b.Dx()) / float64(b.Dy()); math.Abs(aspect-2) > 0.05 {
+			t.Errorf("%g degrees: aspect ratio = %g, want 2", degrees, aspect)
+		}
+
+		// no blank corners
+		for _, p := range []image.Point{{0, 0}, {b.Dx() - 1, 0}, {0, b.Dy() - 1}, {b.Dx() - 1, b.Dy() - 1}} {
+			if _, _, _, a := dst.At(p.X, p.Y).RGBA(); a != 0xFFFF {
+				t.Errorf("%g degrees: corner %v isn't opaque", degrees, p)
+			}
+		}
+	}
+
+	// the center stays where it was
+	dst := ApplyEdits(src, &cameraroll.Edits{Straighten: 10})
+	center := origin(dst, dst.Bounds().Dx()/2, dst.Bounds().Dy()/2)
+	if math.Abs(float64(center.X-100)) > 2 || math.Abs(float64(center.Y-50)) > 2 {
+		t.Errorf("center came from %v, want about (100,50)", center)
+	}
+}
@@ -0,0 +1,114 @@
+package imaging
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+)
+
+// maximum lengths of the EXIF strings kept in the database
+const (
+	maxCameraLength = 64
+	maxLensLength   = 128
+)
+
+// layout of EXIF date and time values, which carry no time zone
+const exifDateTimeLayout = "2006:01:02 15:04:05"
+
+// ExtractExif reads the shooting information from an image's EXIF data.
+// Images without EXIF data yield an empty Exif.
+func ExtractExif(r io.Reader) cameraroll.Exif {
+	info := cameraroll.Exif{}
+
+	x, err := exif.Decode(r)
+	if x == nil || err != nil && exif.IsCriticalError(err) {
+		return info
+	}
+
+	info.CameraMake = exifString(x, exif.Make, maxCameraLength)
+	info.CameraModel = exifString(x, exif.Model, maxCameraLength)
+	info.Lens = exifString(x, exif.LensModel, maxLensLength)
+	info.FocalLength = exifFloat(x, exif.FocalLength)
+	info.Aperture = exifFloat(x, exif.FNumber)
+	info.ExposureTime = exifFloat(x, exif.ExposureTime)
+	info.ShutterSpeed = formatShutterSpeed(info.ExposureTime)
+
+	if tag, err := x.Get(exif.ISOSpeedRatings); err == nil {
+		if iso, err := tag.Int(0); err == nil {
+			info.ISO = &iso
+		}
+	}
+
+	// keep the wall clock time of the camera
+	for _, field := range []exif.FieldName{exif.DateTimeOriginal, exif.DateTime} {
+		if dt := exifString(x, field, 0); len(dt) > 0 {
+			if t, err := time.Parse(exifDateTimeLayout, dt); err == nil {
+				info.DateTaken = &t
+				break
+			}
+		}
+	}
+
+	if lat, long, err := x.LatLong(); err == nil && !math.IsNaN(lat) && !math.IsNaN(long) {
+		info.Latitude = &lat
+		info.Longitude = &long
+	}
+
+	return info
+}
+
+// exifString reads a string tag, truncated to maxLength if maxLength is positive
+func exifString(x *exif.Exif, field exif.FieldName, maxLength int) string {
+	tag, err := x.Get(field)
+	if err != nil {
+		return ""
+	}
+
+	s, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+
+	s = strings.TrimSpace(strings.TrimRight(s, "\x00"))
+	if maxLength > 0 && len(s) > maxLength {
+		s = s[:maxLength]
+	}
+
+	return s
+}
+
+// exifFloat reads a rational tag as a float
+func exifFloat(x *exif.Exif, field exif.FieldName) *float64 {
+	tag, err := x.Get(field)
+	if err != nil {
+		return nil
+	}
+
+	num, den, err := tag.Rat2(0)
+	if err != nil || den == 0 {
+		return nil
+	}
+
+	f := float64(num) / float64(den)
+
+	return &f
+}
+
+// formatShutterSpeed writes an exposure time the way cameras display it, e.g. 1/250 or 2
+func formatShutterSpeed(exposureTime *float64) string {
+	if exposureTime == nil || *exposureTime <= 0 {
+		return ""
+	}
+
+	if *exposureTime < 1 {
+		return fmt.Sprintf("1/%d", int(math.Round(1 / *exposureTime)))
+	}
+
+	return fmt.Sprintf("%g", *exposureTime)
+}
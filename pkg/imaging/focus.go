@@ -0,0 +1,35 @@
+package imaging
+
+import (
+	"image"
+	"math"
+)
+
+// Focus is the point of an image kept in frame when cropping,
+// relative to its size from 0 to 1
+type Focus struct {
+	X float64
+	Y float64
+}
+
+// CropToAspect cuts img down to the aspect ratio of width x height,
+// keeping focus as close to the center of the frame as the edges allow
+func CropToAspect(img image.Image, width int, height int, focus Focus) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// the largest frame of the aspect ratio that fits in img
+	cropW, cropH := w, int(math.Round(float64(w)*float64(height)/float64(width)))
+	if cropH > h {
+		cropW, cropH = int(math.Round(float64(h)*float64(width)/float64(height))), h
+	}
+	cropW, cropH = clamp(cropW, 1, w), clamp(cropH, 1, h)
+
+	// center the frame on the focal point without going past the edges
+	x0 := clamp(int(math.Round(focus.X*float64(w)))-cropW/2, 0, w-cropW)
+	y0 := clamp(int(math.Round(focus.Y*float64(h)))-cropH/2, 0, h-cropH)
+
+	src := toRGBA(img)
+
+	return toRGBA(src.SubImage(image.Rect(x0, y0, x0+cropW, y0+cropH)))
+}
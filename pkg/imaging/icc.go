@@ -0,0 +1,661 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"strings"
+	"unicode/utf16"
+)
+
+// largest ICC profile read from an image
+const maxProfileSize = 4 << 20
+
+var (
+	iccJPEGHeader = []byte("ICC_PROFILE\x00")
+	errNoProfile  = errors.New("no ICC profile")
+)
+
+// ColorProfile is the ICC profile embedded in an image
+type ColorProfile struct {
+	Name       string // description of the color space, e.g. Display P3
+	ColorSpace string // color space of the image data, e.g. RGB, CMYK or GRAY
+	Data       []byte // the profile as it was embedded
+
+	// set for RGB profiles made of a matrix and tone curves
+	toSRGB *[3][3]float64
+	curves [3]func(float64) float64
+}
+
+// the primaries of sRGB adapted to the D50 white point of the ICC profile connection space
+var srgbD50 = [3][3]float64{
+	{0.4360747, 0.3850649, 0.1430804},
+	{0.2225045, 0.7168786, 0.0606169},
+	{0.0139322, 0.0971045, 0.7141733},
+}
+
+// IsSRGB checks if the profile describes sRGB or something close enough to leave as it is
+func (p *ColorProfile) IsSRGB() bool {
+	if p.toSRGB == nil {
+		return strings.Contains(strings.ToLower(p.Name), "srgb")
+	}
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			identity := 0.0
+			if i == j {
+				identity = 1
+			}
+
+			if math.Abs(p.toSRGB[i][j]-identity) > 0.002 {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Convertible checks if images in this profile can be converted to sRGB
+func (p *ColorProfile) Convertible() bool {
+	return p.toSRGB != nil
+}
+
+// NeedsTag checks if images in this profile have to carry it along to be displayed right,
+// which is the case for RGB profiles that can't be converted to sRGB
+func (p *ColorProfile) NeedsTag() bool {
+	return p.ColorSpace == "RGB" && !p.Convertible() && !p.IsSRGB()
+}
+
+// ToSRGB converts the colors of img from the profile to sRGB
+func (p *ColorProfile) ToSRGB(img image.Image) image.Image {
+	if !p.Convertible() || p.IsSRGB() {
+		return img
+	}
+
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+
+	// tone curves of the profile for every 8-bit value
+	var linear [3][256]float64
+	for c := 0; c < 3; c++ {
+		for v := 0; v < 256; v++ {
+			linear[c][v] = p.curves[c](float64(v) / 255)
+		}
+	}
+
+	// sRGB gamma for linear values in steps of 1/4095
+	const steps = 4095
+	encode := make([]uint8, steps+1)
+	for i := range encode {
+		encode[i] = uint8(linearToSRGB(float64(i) / steps))
+	}
+
+	m := p.toSRGB
+	for i := 0; i < len(dst.Pix); i += 4 {
+		r := linear[0][dst.Pix[i]]
+		g := linear[1][dst.Pix[i+1]]
+		bl := linear[2][dst.Pix[i+2]]
+
+		for c := 0; c < 3; c++ {
+			v := m[c][0]*r + m[c][1]*g + m[c][2]*bl
+			if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+				v = 0
+			} else if v > 1 {
+				v = 1
+			}
+			dst.Pix[i+c] = encode[int(v*steps+0.5)]
+		}
+	}
+
+	return dst
+}
+
+// ReadColorProfile finds the ICC profile embedded in an image of the given format,
+// returning nil if it has none or it can't be read
+func ReadColorProfile(r io.Reader, format string) *ColorProfile {
+	var data []byte
+	var err error
+
+	br := bufio.NewReader(r)
+	switch format {
+	case FormatJPEG:
+		data, err = readJPEGProfile(br)
+	case FormatPNG:
+		data, err = readPNGProfile(br)
+	case FormatWebP:
+		data, err = readWebPProfile(br)
+	default:
+		return nil
+	}
+
+	if err != nil {
+		return nil
+	}
+
+	profile, err := parseProfile(data)
+	if err != nil {
+		return nil
+	}
+
+	return profile
+}
+
+// readJPEGProfile joins the ICC profile split over the APP2 segments of a JPEG file
+func readJPEGProfile(r *bufio.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil || header[0] != 0xFF || header[1] != markerSOI {
+		return nil, errMalformedImage
+	}
+
+	chunks := map[int][]byte{}
+	count := 0
+	for {
+		marker, err := nextJPEGMarker(r)
+		if err != nil {
+			return nil, err
+		}
+
+		// the profile comes before the image data
+		if marker == markerSOS || marker == 0xD9 {
+			break
+		}
+
+		// standalone markers carry no length
+		if marker == 0x01 || marker >= 0xD0 && marker <= 0xD7 {
+			continue
+		}
+
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil || length < 2 {
+			return nil, errMalformedImage
+		}
+
+		if marker != markerAPP2 {
+			if _, err := r.Discard(int(length) - 2); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		segment := make([]byte, length-2)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, err
+		}
+
+		if !bytes.HasPrefix(segment, iccJPEGHeader) || len(segment) < len(iccJPEGHeader)+2 {
+			continue
+		}
+
+		seq := int(segment[len(iccJPEGHeader)])
+		count = int(segment[len(iccJPEGHeader)+1])
+		chunks[seq] = segment[len(iccJPEGHeader)+2:]
+	}
+
+	if count == 0 {
+		return nil, errNoProfile
+	}
+
+	// chunks are numbered from 1
+	data := []byte{}
+	for seq := 1; seq <= count; seq++ {
+		chunk, ok := chunks[seq]
+		if !ok {
+			return nil, errMalformedImage
+		}
+		data = append(data, chunk...)
+	}
+
+	return data, nil
+}
+
+// nextJPEGMarker reads up to the next marker of a JPEG file, skipping fill bytes
+func nextJPEGMarker(r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	if b != 0xFF {
+		return 0, errMalformedImage
+	}
+
+	for b == 0xFF {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+
+	return b, nil
+}
+
+// readPNGProfile inflates the iCCP chunk of a PNG file
+func readPNGProfile(r *bufio.Reader) ([]byte, error) {
+	if _, err := r.Discard(8); err != nil {
+		return nil, err
+	}
+
+	for {
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+
+		chunkType := make([]byte, 4)
+		if _, err := io.ReadFull(r, chunkType); err != nil {
+			return nil, err
+		}
+
+		switch string(chunkType) {
+		case "IDAT", "IEND":
+			// the profile comes before the image data
+			return nil, errNoProfile
+		case "iCCP":
+			if length > maxProfileSize {
+				return nil, errMalformedImage
+			}
+
+			chunk := make([]byte, length)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return nil, err
+			}
+
+			// a profile name, then the compression method
+			name := bytes.IndexByte(chunk, 0)
+			if name < 0 || name+2 > len(chunk) {
+				return nil, errMalformedImage
+			}
+
+			zr, err := zlib.NewReader(bytes.NewReader(chunk[name+2:]))
+			if err != nil {
+				return nil, err
+			}
+			defer zr.Close()
+
+			return io.ReadAll(io.LimitReader(zr, maxProfileSize))
+		}
+
+		// skip the data and CRC
+		if _, err := r.Discard(int(length) + 4); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readWebPProfile reads the ICCP chunk of a WebP file
+func readWebPProfile(r *bufio.Reader) ([]byte, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:4]) != "RIFF" || string(header[8:12]) != "WEBP" {
+		return nil, errMalformedImage
+	}
+
+	for {
+		chunkHeader := make([]byte, 8)
+		if _, err := io.ReadFull(r, chunkHeader); err != nil {
+			return nil, err
+		}
+
+		length := binary.LittleEndian.Uint32(chunkHeader[4:])
+		switch string(chunkHeader[:4]) {
+		case "VP8 ", "VP8L", "ANIM":
+			// the profile comes before the image data
+			return nil, errNoProfile
+		case "ICCP":
+			if length > maxProfileSize {
+				return nil, errMalformedImage
+			}
+
+			data := make([]byte, length)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, err
+			}
+
+			return data, nil
+		}
+
+		// chunks are padded to an even size
+		if _, err := r.Discard(int(length + length%2)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseProfile reads the color space, name, primaries and tone curves of an ICC profile
+func parseProfile(data []byte) (*ColorProfile, error) {
+	const headerSize = 128
+
+	if len(data) < headerSize+4 || string(data[36:40]) != "acsp" {
+		return nil, errMalformedImage
+	}
+
+	profile := ColorProfile{
+		ColorSpace: strings.TrimSpace(string(data[16:20])),
+		Data:       data,
+	}
+
+	// find the tags by signature
+	tags := map[string][]byte{}
+	count := int(binary.BigEndian.Uint32(data[headerSize:]))
+	for i := 0; i < count; i++ {
+		entry := headerSize + 4 + i*12
+		if entry+12 > len(data) {
+			return nil, errMalformedImage
+		}
+
+		offset := int(binary.BigEndian.Uint32(data[entry+4:]))
+		size := int(binary.BigEndian.Uint32(data[entry+8:]))
+		if offset < 0 || size < 0 || offset+size > len(data) {
+			return nil, errMalformedImage
+		}
+
+		tags[string(data[entry:entry+4])] = data[offset : offset+size]
+	}
+
+	profile.Name = profileDescription(tags["desc"])
+
+	// only matrix and tone curve RGB profiles are converted
+	if profile.ColorSpace != "RGB" || string(data[20:24]) != "XYZ " {
+		return &profile, nil
+	}
+
+	var primaries [3][3]float64
+	for c, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		xyz, ok := readXYZ(tags[sig])
+		if !ok {
+			return &profile, nil
+		}
+
+		// the primaries are the columns of the matrix to XYZ
+		for i := 0; i < 3; i++ {
+			primaries[i][c] = xyz[i]
+		}
+	}
+
+	for c, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		curve, ok := readCurve(tags[sig])
+		if !ok {
+			return &profile, nil
+		}
+		profile.curves[c] = curve
+	}
+
+	toSRGB, ok := invert(srgbD50)
+	if !ok {
+		return &profile, nil
+	}
+	toSRGB = multiply(toSRGB, primaries)
+	profile.toSRGB = &toSRGB
+
+	return &profile, nil
+}
+
+// profileDescription reads the text of a desc tag, in either of its ICC v2 or v4 forms
+func profileDescription(tag []byte) string {
+	if len(tag) < 12 {
+		return ""
+	}
+
+	switch string(tag[:4]) {
+	case "desc":
+		length := int(binary.BigEndian.Uint32(tag[8:]))
+		if length <= 0 || 12+length > len(tag) {
+			return ""
+		}
+
+		return strings.TrimRight(string(tag[12:12+length]), "\x00")
+	case "mluc":
+		// the first of the localized records will do
+		if len(tag) < 28 || binary.BigEndian.Uint32(tag[8:]) == 0 {
+			return ""
+		}
+
+		length := int(binary.BigEndian.Uint32(tag[20:]))
+		offset := int(binary.BigEndian.Uint32(tag[24:]))
+		if offset+length > len(tag) {
+			return ""
+		}
+
+		text := make([]uint16, length/2)
+		for i := range text {
+			text[i] = binary.BigEndian.Uint16(tag[offset+i*2:])
+		}
+
+		return strings.TrimRight(string(utf16.Decode(text)), "\x00")
+	default:
+		return ""
+	}
+}
+
+// readXYZ reads a single XYZ value out of an XYZ tag
+func readXYZ(tag []byte) ([3]float64, bool) {
+	var xyz [3]float64
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return xyz, false
+	}
+
+	for i := 0; i < 3; i++ {
+		xyz[i] = s15Fixed16(tag[8+i*4:])
+	}
+
+	return xyz, true
+}
+
+// readCurve reads a tone curve from either a curv or para tag,
+// as a function from encoded to linear values
+func readCurve(tag []byte) (func(float64) float64, bool) {
+	if len(tag) < 12 {
+		return nil, false
+	}
+
+	switch string(tag[:4]) {
+	case "curv":
+		count := int(binary.BigEndian.Uint32(tag[8:]))
+		if 12+count*2 > len(tag) {
+			return nil, false
+		}
+
+		switch count {
+		case 0:
+			return func(x float64) float64 { return x }, true
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(x float64) float64 { return math.Pow(x, gamma) }, true
+		}
+
+		table := make([]float64, count)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+i*2:])) / 65535
+		}
+
+		return func(x float64) float64 {
+			pos := x * float64(count-1)
+			i := int(pos)
+			if i >= count-1 {
+				return table[count-1]
+			}
+			frac := pos - float64(i)
+			return table[i]*(1-frac) + table[i+1]*frac
+		}, true
+	case "para":
+		// number of parameters of each function type
+		counts := []int{1, 3, 4, 5, 7}
+
+		function := int(binary.BigEndian.Uint16(tag[8:]))
+		if function >= len(counts) || 12+counts[function]*4 > len(tag) {
+			return nil, false
+		}
+
+		var p [7]float64
+		for i := 0; i < counts[function]; i++ {
+			p[i] = s15Fixed16(tag[12+i*4:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+
+		// the power functions would divide by zero or raise a negative base
+		if function > 0 && a <= 0 {
+			return nil, false
+		}
+
+		if (function == 3 || function == 4) && a*math.Max(d, 0)+b < 0 {
+			return nil, false
+		}
+
+		return func(x float64) float64 {
+			switch function {
+			case 0:
+				return math.Pow(x, g)
+			case 1:
+				if x >= -b/a {
+					return math.Pow(a*x+b, g)
+				}
+				return 0
+			case 2:
+				if x >= -b/a {
+					return math.Pow(a*x+b, g) + c
+				}
+				return c
+			case 3:
+				if x >= d {
+					return math.Pow(a*x+b, g)
+				}
+				return c * x
+			default:
+				if x >= d {
+					return math.Pow(a*x+b, g) + e
+				}
+				return c*x + f
+			}
+		}, true
+	default:
+		return nil, false
+	}
+}
+
+// s15Fixed16 reads a signed 15.16 fixed point number
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// invert inverts a 3x3 matrix, reporting false if it's singular
+func invert(m [3][3]float64) ([3][3]float64, bool) {
+	var inv [3][3]float64
+
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	if math.Abs(det) < 1e-12 {
+		return inv, false
+	}
+
+	inv[0][0] = (m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det
+	inv[0][1] = (m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det
+	inv[0][2] = (m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det
+	inv[1][0] = (m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det
+	inv[1][1] = (m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det
+	inv[1][2] = (m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det
+	inv[2][0] = (m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det
+	inv[2][1] = (m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det
+	inv[2][2] = (m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det
+
+	return inv, true
+}
+
+// multiply multiplies two 3x3 matrices
+func multiply(a [3][3]float64, b [3][3]float64) [3][3]float64 {
+	var m [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				m[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+
+	return m
+}
+
+// EmbedColorProfile tags an encoded JPEG or PNG image with an ICC profile
+func EmbedColorProfile(data []byte, format string, profile []byte) ([]byte, error) {
+	switch format {
+	case FormatJPEG:
+		return embedJPEGProfile(data, profile)
+	case FormatPNG:
+		return embedPNGProfile(data, profile)
+	default:
+		return data, nil
+	}
+}
+
+// embedJPEGProfile splits a profile into APP2 segments right after the start of image
+func embedJPEGProfile(data []byte, profile []byte) ([]byte, error) {
+	const maxChunk = 0xFFFF - 2 - 14
+
+	if len(data) < 2 || data[0] != 0xFF || data[1] != markerSOI {
+		return nil, errMalformedImage
+	}
+
+	count := (len(profile) + maxChunk - 1) / maxChunk
+	if count > 255 {
+		return nil, errors.New("ICC profile too large")
+	}
+
+	out := bytes.Buffer{}
+	out.Write(data[:2])
+
+	for seq := 1; seq <= count; seq++ {
+		chunk := profile[(seq-1)*maxChunk:]
+		if len(chunk) > maxChunk {
+			chunk = chunk[:maxChunk]
+		}
+
+		out.Write([]byte{0xFF, markerAPP2})
+		binary.Write(&out, binary.BigEndian, uint16(2+len(iccJPEGHeader)+2+len(chunk)))
+		out.Write(iccJPEGHeader)
+		out.Write([]byte{byte(seq), byte(count)})
+		out.Write(chunk)
+	}
+
+	out.Write(data[2:])
+
+	return out.Bytes(), nil
+}
+
+// embedPNGProfile adds an iCCP chunk right after the IHDR chunk
+func embedPNGProfile(data []byte, profile []byte) ([]byte, error) {
+	// signature, then the length, type, data and CRC of IHDR
+	const afterIHDR = 8 + 4 + 4 + 13 + 4
+
+	if len(data) < afterIHDR || string(data[12:16]) != "IHDR" {
+		return nil, errMalformedImage
+	}
+
+	compressed := bytes.Buffer{}
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(profile); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	// a profile name, then the compression method
+	chunk := []byte("iCCPICC Profile\x00\x00")
+	chunk = append(chunk, compressed.Bytes()...)
+
+	out := bytes.Buffer{}
+	out.Write(data[:afterIHDR])
+	binary.Write(&out, binary.BigEndian, uint32(len(chunk)-4))
+	out.Write(chunk)
+	binary.Write(&out, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	out.Write(data[afterIHDR:])
+
+	return out.Bytes(), nil
+}
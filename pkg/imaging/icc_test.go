@@ -0,0 +1,234 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+// appendS15Fixed16 appends a signed 15.16 fixed point number
+func appendS15Fixed16(b []byte, v float64) []byte {
+	return binary.BigEndian.AppendUint32(b, uint32(int32(math.Round(v*65536))))
+}
+
+// paraTag builds a para tag of a function type from its parameters
+func paraTag(function uint16, params ...float64) []byte {
+	tag := []byte("para\x00\x00\x00\x00")
+	tag = binary.BigEndian.AppendUint16(tag, function)
+	tag = append(tag, 0, 0)
+	for _, p := range params {
+		tag = appendS15Fixed16(tag, p)
+	}
+
+	return tag
+}
+
+// curvTag builds a curv tag from its entries
+func curvTag(entries ...uint16) []byte {
+	tag := []byte("curv\x00\x00\x00\x00")
+	tag = binary.BigEndian.AppendUint32(tag, uint32(len(entries)))
+	for _, entry := range entries {
+		tag = binary.BigEndian.AppendUint16(tag, entry)
+	}
+
+	return tag
+}
+
+// testProfile builds an RGB matrix and tone curve profile
+// from the D50 adapted XYZ of its primaries and a gamma shared by its channels
+func testProfile(name string, primaries [3][3]float64, gamma float64) []byte {
+	const headerSize = 128
+
+	desc := []byte("desc\x00\x00\x00\x00")
+	desc = binary.BigEndian.AppendUint32(desc, uint32(len(name)+1))
+	desc = append(desc, name...)
+	desc = append(desc, 0)
+
+	sigs := []string{"desc", "rXYZ", "gXYZ", "bXYZ", "rTRC", "gTRC", "bTRC"}
+	tags := [][]byte{desc}
+	for c := 0; c < 3; c++ {
+		xyz := []byte("XYZ \x00\x00\x00\x00")
+		for i := 0; i < 3; i++ {
+			xyz = appendS15Fixed16(xyz, primaries[i][c])
+		}
+		tags = append(tags, xyz)
+	}
+	for c := 0; c < 3; c++ {
+		tags = append(tags, curvTag(uint16(gamma*256)))
+	}
+
+	data := make([]byte, headerSize)
+	copy(data[16:], "RGB ")
+	copy(data[20:], "XYZ ")
+	copy(data[36:], "acsp")
+
+	data = binary.BigEndian.AppendUint32(data, uint32(len(tags)))
+	offset := headerSize + 4 + len(tags)*12
+	for i, tag := range tags {
+		data = append(data, sigs[i]...)
+		data = binary.BigEndian.AppendUint32(data, uint32(offset))
+		data = binary.BigEndian.AppendUint32(data, uint32(len(tag)))
+		offset += len(tag)
+	}
+	for _, tag := range tags {
+		data = append(data, tag...)
+	}
+
+	binary.BigEndian.PutUint32(data, uint32(len(data)))
+
+	return data
+}
+
+// the primaries of Display P3 adapted to D50
+var displayP3D50 = [3][3]float64{
+	{0.5151, 0.2920, 0.1571},
+	{0.2412, 0.6922, 0.0666},
+	{-0.0011, 0.0419, 0.7841},
+}
+
+func TestReadCurve(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  []byte
+		x    float64
+		want float64
+	}{
+		{"identity curv", curvTag(), 0.3, 0.3},
+		{"gamma curv", curvTag(2 * 256), 0.5, 0.25},
+		{"table curv", curvTag(0, 65535/4, 65535), 0.25, 0.125},
+		{"gamma para", paraTag(0, 2), 0.5, 0.25},
+		{"sRGB para", paraTag(3, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045), 1, 1},
+		{"sRGB para linear part", paraTag(3, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045), 0.02, 0.02 / 12.92},
+	}
+
+	for _, tt := range tests {
+		curve, ok := readCurve(tt.tag)
+		if !ok {
+			t.Errorf("%s: couldn't read the curve", tt.name)
+			continue
+		}
+
+		if got := curve(tt.x); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("%s: curve(%g) = %g, want %g", tt.name, tt.x, got, tt.want)
+		}
+	}
+}
+
+func TestReadCurveRejectsDegenerateParams(t *testing.T) {
+	tests := map[string][]byte{
+		"zero a":             paraTag(1, 2.2, 0, 0),
+		"negative a":         paraTag(2, 2.2, -1, 0, 0),
+		"negative base":      paraTag(3, 2.2, 1, -0.5, 1, 0.1),
+		"unknown function":   paraTag(5, 2.2),
+		"missing params":     paraTag(4, 2.2, 1),
+		"curv past its size": []byte("curv\x00\x00\x00\x00\x00\x00\x00\x09"),
+	}
+
+	for name, tag := range tests {
+		if _, ok := readCurve(tag); ok {
+			t.Errorf("%s: curve was accepted", name)
+		}
+	}
+}
+
+func TestParseProfile(t *testing.T) {
+	srgb, err := parseProfile(testProfile("sRGB built-in", srgbD50, 2.2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if srgb.Name != "sRGB built-in" || srgb.ColorSpace != "RGB" {
+		t.Errorf("name = %q, color space = %q", srgb.Name, srgb.ColorSpace)
+	}
+
+	if !srgb.Convertible() || !srgb.IsSRGB() || srgb.NeedsTag() {
+		t.Error("sRGB profile isn't recognized as sRGB")
+	}
+
+	p3, err := parseProfile(testProfile("Display P3", displayP3D50, 2.2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !p3.Convertible() || p3.IsSRGB() || p3.NeedsTag() {
+		t.Error("Display P3 profile isn't converted")
+	}
+
+	if _, err := parseProfile([]byte("not a profile")); err == nil {
+		t.Error("garbage was parsed as a profile")
+	}
+}
+
+func TestToSRGB(t *testing.T) {
+	p3, err := parseProfile(testProfile("Display P3", displayP3D50, 2.2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	img.Set(0, 0, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	img.Set(1, 0, color.NRGBA{A: 255})
+	img.Set(2, 0, color.NRGBA{R: 255, A: 255})
+
+	out := p3.ToSRGB(img).(*image.NRGBA)
+
+	// white and black stay put, pure P3 red is out of the sRGB gamut
+	if c := out.NRGBAAt(0, 0); c.R < 250 || c.G < 250 || c.B < 250 {
+		t.Errorf("white = %v", c)
+	}
+
+	if c := out.NRGBAAt(1, 0); c.R > 5 || c.G > 5 || c.B > 5 {
+		t.Errorf("black = %v", c)
+	}
+
+	if c := out.NRGBAAt(2, 0); c.R != 255 || c.G != 0 || c.B != 0 {
+		t.Errorf("red = %v", c)
+	}
+}
+
+func TestToSRGBNonFinite(t *testing.T) {
+	p3, err := parseProfile(testProfile("Display P3", displayP3D50, 2.2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for c := range p3.curves {
+		p3.curves[c] = func(float64) float64 { return math.NaN() }
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	img.Set(0, 0, color.NRGBA{R: 128, G: 128, B: 128, A: 255})
+
+	if c := p3.ToSRGB(img).(*image.NRGBA).NRGBAAt(0, 0); c.R != 0 || c.G != 0 || c.B != 0 {
+		t.Errorf("NaN = %v, want black", c)
+	}
+}
+
+func TestEmbedAndReadColorProfile(t *testing.T) {
+	buf := bytes.Buffer{}
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if profile := ReadColorProfile(bytes.NewReader(buf.Bytes()), FormatJPEG); profile != nil {
+		t.Errorf("untagged JPEG has profile %q", profile.Name)
+	}
+
+	data, err := EmbedColorProfile(buf.Bytes(), FormatJPEG, testProfile("Display P3", displayP3D50, 2.2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile := ReadColorProfile(bytes.NewReader(data), FormatJPEG)
+	if profile == nil || profile.Name != "Display P3" {
+		t.Fatalf("profile = %+v", profile)
+	}
+
+	if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("tagged JPEG doesn't decode: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package imaging
+
+import (
+	"image"
+	"image/draw"
+	"io"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// values of the EXIF Orientation tag
+const (
+	OrientationNormal      = 1
+	OrientationFlipH       = 2
+	OrientationRotate180   = 3
+	OrientationFlipV       = 4
+	OrientationTranspose   = 5
+	OrientationRotate90CW  = 6
+	OrientationTransverse  = 7
+	OrientationRotate90CCW = 8
+)
+
+// ReadOrientation reads the EXIF Orientation tag of an image,
+// defaulting to OrientationNormal when it's missing or invalid
+func ReadOrientation(r io.Reader) int {
+	x, err := exif.Decode(r)
+	if x == nil || err != nil && exif.IsCriticalError(err) {
+		return OrientationNormal
+	}
+
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return OrientationNormal
+	}
+
+	orientation, err := tag.Int(0)
+	if err != nil || orientation < OrientationNormal || orientation > OrientationRotate90CCW {
+		return OrientationNormal
+	}
+
+	return orientation
+}
+
+// Orient transforms img according to an EXIF orientation so that it's upright
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation <= OrientationNormal || orientation > OrientationRotate90CCW {
+		return img
+	}
+
+	src := toRGBA(img)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	// orientations 5 to 8 swap width and height
+	dstW, dstH := w, h
+	if orientation >= OrientationTranspose {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			// find the source pixel of each destination pixel
+			var sx, sy int
+			switch orientation {
+			case OrientationFlipH:
+				sx, sy = w-1-x, y
+			case OrientationRotate180:
+				sx, sy = w-1-x, h-1-y
+			case OrientationFlipV:
+				sx, sy = x, h-1-y
+			case OrientationTranspose:
+				sx, sy = y, x
+			case OrientationRotate90CW:
+				sx, sy = y, h-1-x
+			case OrientationTransverse:
+				sx, sy = w-1-y, h-1-x
+			case OrientationRotate90CCW:
+				sx, sy = w-1-y, x
+			}
+
+			si := src.PixOffset(sx, sy)
+			di := dst.PixOffset(x, y)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+
+	return dst
+}
+
+// toRGBA converts img to an *image.RGBA whose bounds start at (0, 0)
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+
+	return rgba
+}
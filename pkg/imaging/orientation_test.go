@@ -0,0 +1,117 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// testJPEG encodes a small gray JPEG with the given segments right after its SOI marker
+func testJPEG(t *testing.T, segments ...[]byte) []byte {
+	t.Helper()
+
+	img := image.NewGray(image.Rect(0, 0, 16, 8))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+
+	buf := bytes.Buffer{}
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	out := append([]byte{}, data[:2]...)
+	for _, segment := range segments {
+		out = append(out, segment...)
+	}
+
+	return append(out, data[2:]...)
+}
+
+func TestOrient(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+
+	// a 3x2 image with its top left pixel marked
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	src.Set(0, 0, red)
+
+	tests := []struct {
+		orientation int
+		size        image.Point
+		marked      image.Point // where the top left pixel ends up
+	}{
+		{OrientationNormal, image.Pt(3, 2), image.Pt(0, 0)},
+		{OrientationFlipH, image.Pt(3, 2), image.Pt(2, 0)},
+		{OrientationRotate180, image.Pt(3, 2), image.Pt(2, 1)},
+		{OrientationFlipV, image.Pt(3, 2), image.Pt(0, 1)},
+		{OrientationTranspose, image.Pt(2, 3), image.Pt(0, 0)},
+		{OrientationRotate90CW, image.Pt(2, 3), image.Pt(1, 0)},
+		{OrientationTransverse, image.Pt(2, 3), image.Pt(1, 2)},
+		{OrientationRotate90CCW, image.Pt(2, 3), image.Pt(0, 2)},
+		{0, image.Pt(3, 2), image.Pt(0, 0)},
+		{9, image.Pt(3, 2), image.Pt(0, 0)},
+	}
+
+	for _, tt := range tests {
+		dst := Orient(src, tt.orientation)
+
+		if size := dst.Bounds().Size(); size != tt.size {
+			t.Errorf("orientation %d: size = %v, want %v", tt.orientation, size, tt.size)
+			continue
+		}
+
+		if c := color.RGBAModel.Convert(dst.At(tt.marked.X, tt.marked.Y)); c != red {
+			t.Errorf("orientation %d: pixel at %v = %v, want the marked one", tt.orientation, tt.marked, c)
+		}
+	}
+}
+
+func TestOrientSubImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	src.Set(2, 1, color.RGBA{G: 255, A: 255})
+
+	// sub-images don't start at (0, 0)
+	dst := Orient(src.SubImage(image.Rect(2, 1, 4, 4)), OrientationFlipV)
+
+	if b := dst.Bounds(); b != image.Rect(0, 0, 2, 3) {
+		t.Fatalf("bounds = %v", b)
+	}
+
+	if _, g, _, _ := dst.At(0, 2).RGBA(); g == 0 {
+		t.Error("marked pixel didn't move to the bottom")
+	}
+}
+
+func TestReadOrientation(t *testing.T) {
+	if orientation := ReadOrientation(bytes.NewReader(testJPEG(t))); orientation != OrientationNormal {
+		t.Errorf("without EXIF: orientation = %d, want %d", orientation, OrientationNormal)
+	}
+
+	for orientation := OrientationNormal; orientation <= OrientationRotate90CCW; orientation++ {
+		data := testJPEG(t, orientationSegment(orientation))
+
+		if got := ReadOrientation(bytes.NewReader(data)); got != orientation {
+			t.Errorf("orientation = %d, want %d", got, orientation)
+		}
+	}
+
+	// out of range values are ignored
+	if got := ReadOrientation(bytes.NewReader(testJPEG(t, orientationSegment(9)))); got != OrientationNormal {
+		t.Errorf("invalid orientation = %d, want %d", got, OrientationNormal)
+	}
+}
+
+func TestReadDimensionsUpright(t *testing.T) {
+	// testJPEG is 16x8
+	width, height, format, err := ReadDimensions(bytes.NewReader(testJPEG(t, orientationSegment(OrientationRotate90CW))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if width != 8 || height != 16 || format != FormatJPEG {
+		t.Errorf("dimensions = %dx%d %s, want 8x16 %s", width, height, format, FormatJPEG)
+	}
+}
@@ -0,0 +1,33 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/nfnt/resize"
+)
+
+// PerceptualHash computes the 64-bit difference hash (dHash) of img.
+// Copies of the same picture exported at different sizes or qualities
+// end up with hashes only a few bits apart.
+func PerceptualHash(img image.Image) uint64 {
+	const size = 8
+
+	// one extra column to compare the last one against
+	small := resize.Resize(size+1, size, img, resize.Bilinear)
+	bounds := small.Bounds()
+
+	var hash uint64
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			left := color.GrayModel.Convert(small.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y
+			right := color.GrayModel.Convert(small.At(bounds.Min.X+x+1, bounds.Min.Y+y)).(color.Gray).Y
+
+			if left < right {
+				hash |= 1 << (y*size + x)
+			}
+		}
+	}
+
+	return hash
+}
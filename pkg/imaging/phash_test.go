@@ -0,0 +1,84 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"math/bits"
+	"testing"
+
+	"github.com/nfnt/resize"
+)
+
+// testPattern draws smooth waves of gray, different for each seed
+func testPattern(width int, height int, seed float64) image.Image {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			u, v := float64(x)/float64(width), float64(y)/float64(height)
+			value := math.Sin(u*seed*7)*math.Cos(v*seed*5) + math.Sin((u+v)*seed*3)
+			img.SetGray(x, y, color.Gray{Y: uint8(127 + 63*value)})
+		}
+	}
+
+	return img
+}
+
+func TestPerceptualHashGradients(t *testing.T) {
+	rising := image.NewGray(image.Rect(0, 0, 90, 80))
+	falling := image.NewGray(image.Rect(0, 0, 90, 80))
+	flat := image.NewGray(image.Rect(0, 0, 90, 80))
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 90; x++ {
+			rising.SetGray(x, y, color.Gray{Y: uint8(x * 2)})
+			falling.SetGray(x, y, color.Gray{Y: uint8(255 - x*2)})
+			flat.SetGray(x, y, color.Gray{Y: 100})
+		}
+	}
+
+	// each bit is set where the next column is brighter
+	if hash := PerceptualHash(rising); hash != math.MaxUint64 {
+		t.Errorf("rising gradient = %016x, want all bits set", hash)
+	}
+
+	if hash := PerceptualHash(falling); hash != 0 {
+		t.Errorf("falling gradient = %016x, want no bits set", hash)
+	}
+
+	if hash := PerceptualHash(flat); hash != 0 {
+		t.Errorf("flat image = %016x, want no bits set", hash)
+	}
+}
+
+func TestPerceptualHashNearDuplicates(t *testing.T) {
+	const maxDistance = 8
+
+	original := testPattern(400, 300, 1)
+	hash := PerceptualHash(original)
+
+	// smaller copy
+	small := resize.Resize(120, 90, original, resize.Lanczos3)
+	if d := bits.OnesCount64(hash ^ PerceptualHash(small)); d > maxDistance {
+		t.Errorf("resized copy is %d bits away", d)
+	}
+
+	// lower quality copy
+	buf := bytes.Buffer{}
+	if err := jpeg.Encode(&buf, original, &jpeg.Options{Quality: 30}); err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := bits.OnesCount64(hash ^ PerceptualHash(compressed)); d > maxDistance {
+		t.Errorf("compressed copy is %d bits away", d)
+	}
+
+	// another picture altogether
+	if d := bits.OnesCount64(hash ^ PerceptualHash(testPattern(400, 300, 2.3))); d <= maxDistance {
+		t.Errorf("different picture is only %d bits away", d)
+	}
+}
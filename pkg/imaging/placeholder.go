@@ -0,0 +1,96 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/jpeg"
+
+	"github.com/nfnt/resize"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+)
+
+const (
+	// BlurHash and the average color are computed from a copy this small
+	blurHashSampleSize = 32
+
+	// number of BlurHash components along the longer side of the image
+	blurHashComponents = 4
+
+	// maximum width and height of the LQIP in pixels
+	lqipSize = 16
+
+	// JPEG quality of the LQIP, it's going to be blurred anyway
+	lqipQuality = 40
+)
+
+// NewPlaceholder computes the BlurHash, LQIP and average color of img,
+// which is usually a thumbnail
+func NewPlaceholder(img image.Image) (cameraroll.Placeholder, error) {
+	sample := resize.Thumbnail(blurHashSampleSize, blurHashSampleSize, img, resize.Bilinear)
+
+	// keep the components roughly square
+	xComponents, yComponents := blurHashComponents, blurHashComponents
+	if w, h := sample.Bounds().Dx(), sample.Bounds().Dy(); w > h {
+		yComponents = (blurHashComponents*h + w - 1) / w
+	} else if h > w {
+		xComponents = (blurHashComponents*w + h - 1) / h
+	}
+
+	lqip, err := encodeLQIP(img)
+	if err != nil {
+		return cameraroll.Placeholder{}, err
+	}
+
+	placeholder := cameraroll.Placeholder{
+		BlurHash: encodeBlurHash(sample, xComponents, yComponents),
+		LQIP:     lqip,
+		Color:    averageColor(sample),
+	}
+
+	return placeholder, nil
+}
+
+// encodeLQIP shrinks img to lqipSize and encodes it as a data URI
+func encodeLQIP(img image.Image) (string, error) {
+	tiny := resize.Thumbnail(lqipSize, lqipSize, img, resize.Bilinear)
+	format := OutputFormat(tiny)
+
+	buf := bytes.Buffer{}
+	var err error
+	if format == FormatJPEG {
+		err = jpeg.Encode(&buf, tiny, &jpeg.Options{Quality: lqipQuality})
+	} else {
+		err = Encode(&buf, tiny, format)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("encodeLQIP: %v", err)
+	}
+
+	return fmt.Sprintf("data:%s;base64,%s", MimeType(format), base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+// averageColor finds the average color of img in #rrggbb format
+func averageColor(img image.Image) string {
+	var r, g, b, n uint64
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pr, pg, pb, _ := img.At(x, y).RGBA()
+			r += uint64(pr >> 8)
+			g += uint64(pg >> 8)
+			b += uint64(pb >> 8)
+			n++
+		}
+	}
+
+	if n == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("#%02x%02x%02x", r/n, g/n, b/n)
+}
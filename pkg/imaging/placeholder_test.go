@@ -0,0 +1,118 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"testing"
+)
+
+// decode83 reads a base 83 number of BlurHash
+func decode83(s string) int {
+	value := 0
+	for _, c := range s {
+		value = value*83 + strings.IndexRune(base83Chars, c)
+	}
+
+	return value
+}
+
+// uniformImage fills an image of the given size with a single color
+func uniformImage(width int, height int, c color.Color) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+
+	return img
+}
+
+func TestNewPlaceholderUniform(t *testing.T) {
+	placeholder, err := NewPlaceholder(uniformImage(100, 50, color.NRGBA{R: 0x33, G: 0x66, B: 0x99, A: 255}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if placeholder.Color != "#336699" {
+		t.Errorf("color = %s, want #336699", placeholder.Color)
+	}
+
+	// 4x2 components for a landscape image: a size flag, the maximum AC value, the DC value, then 7 AC values
+	hash := placeholder.BlurHash
+	if len(hash) != 6+2*7 {
+		t.Fatalf("BlurHash %q has %d characters, want %d", hash, len(hash), 6+2*7)
+	}
+
+	if flag := decode83(hash[:1]); flag != 3+1*9 {
+		t.Errorf("size flag = %d, want %d", flag, 3+1*9)
+	}
+
+	if dc := decode83(hash[2:6]); dc != 0x336699 {
+		t.Errorf("DC value = %06x, want 336699", dc)
+	}
+
+	if !strings.HasPrefix(placeholder.LQIP, "data:image/jpeg;base64,") {
+		t.Fatalf("LQIP = %.40s...", placeholder.LQIP)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(placeholder.LQIP, "data:image/jpeg;base64,"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tiny, _, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size := tiny.Bounds().Size(); size != image.Pt(lqipSize, lqipSize/2) {
+		t.Errorf("LQIP size = %v, want %dx%d", size, lqipSize, lqipSize/2)
+	}
+}
+
+func TestNewPlaceholderPortrait(t *testing.T) {
+	img := uniformImage(30, 90, color.NRGBA{R: 200, A: 255})
+	for y := 45; y < 90; y++ {
+		for x := 0; x < 30; x++ {
+			img.Set(x, y, color.NRGBA{B: 200, A: 255})
+		}
+	}
+
+	placeholder, err := NewPlaceholder(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2x4 components for a portrait image
+	if flag := decode83(placeholder.BlurHash[:1]); flag != 1+3*9 {
+		t.Errorf("size flag = %d, want %d", flag, 1+3*9)
+	}
+
+	if len(placeholder.BlurHash) != 6+2*7 {
+		t.Errorf("BlurHash %q has %d characters", placeholder.BlurHash, len(placeholder.BlurHash))
+	}
+
+	// halfway between red and blue, give or take the resampling
+	var r, g, b int
+	if _, err := fmt.Sscanf(placeholder.Color, "#%02x%02x%02x", &r, &g, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	if r < 95 || r > 105 || g != 0 || b < 95 || b > 105 {
+		t.Errorf("color = %s, want about #640064", placeholder.Color)
+	}
+}
+
+func TestNewPlaceholderTransparent(t *testing.T) {
+	placeholder, err := NewPlaceholder(uniformImage(20, 20, color.NRGBA{G: 255, A: 128}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// transparency is kept in PNG
+	if !strings.HasPrefix(placeholder.LQIP, "data:image/png;base64,") {
+		t.Errorf("LQIP = %.40s...", placeholder.LQIP)
+	}
+}
@@ -0,0 +1,482 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/nfnt/resize"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+	"chujungeng/camera-roll/pkg/storage"
+	"chujungeng/camera-roll/pkg/url"
+)
+
+// Options controls which derivatives the Processor generates
+type Options struct {
+	// maximum width and height of thumbnails in pixels
+	ThumbnailSize int
+
+	// widths of the responsive renditions in pixels
+	RenditionWidths []int
+
+	// what to strip from the publicly served copies of originals
+	MetadataPolicy string
+
+	// renditions at least this wide get a watermark
+	WatermarkMinWidth int
+
+	// mark of every image, nil for none
+	Watermark *Watermark
+
+	// named marks that albums can use instead of the default one
+	Watermarks map[string]*Watermark
+}
+
+// Processor derives thumbnails and renditions from original images,
+// saving them to an AssetStore. The untouched originals are kept apart
+// in a private AssetStore, while the public one gets copies without metadata.
+type Processor struct {
+	store     cameraroll.AssetStore
+	originals cameraroll.AssetStore
+	baseURL   string
+	options   Options
+}
+
+// NewProcessor is the constructor method for Processor.
+// baseURL is the public URL that assets in the store are served from.
+func NewProcessor(store cameraroll.AssetStore, originals cameraroll.AssetStore, baseURL string, options Options) *Processor {
+	processor := Processor{
+		store:     store,
+		originals: originals,
+		baseURL:   baseURL,
+		options:   options,
+	}
+
+	return &processor
+}
+
+// Derivatives describes an original image and everything derived from it
+type Derivatives struct {
+	Format          string
+	MimeType        string
+	Width           int
+	Height          int
+	Thumbnail       string
+	ThumbnailWidth  int
+	ThumbnailHeight int
+	Renditions      []*cameraroll.Rendition
+	Exif            cameraroll.Exif
+	Placeholder     cameraroll.Placeholder
+	PerceptualHash  uint64
+	ColorSpace      string // name of the embedded ICC profile, empty if untagged
+
+	// keys of the assets written to the store
+	keys []string
+
+	// ICC profile the derivatives are tagged with, if they couldn't be converted to sRGB
+	profile []byte
+}
+
+// ApplyTo copies the derivatives onto img
+func (d *Derivatives) ApplyTo(img *cameraroll.Image) {
+	hash := d.PerceptualHash
+
+	img.MimeType = d.MimeType
+	img.Width = d.Width
+	img.Height = d.Height
+	img.Thumbnail = d.Thumbnail
+	img.ThumbnailWidth = d.ThumbnailWidth
+	img.ThumbnailHeight = d.ThumbnailHeight
+	img.Renditions = d.Renditions
+	img.Exif = d.Exif
+	img.Placeholder = d.Placeholder
+	img.ColorSpace = d.ColorSpace
+	img.PerceptualHash = &hash
+}
+
+// Watermark finds the mark of images whose albums use the named watermark,
+// the default one for an empty name. Nil is returned for WatermarkNone.
+func (p Processor) Watermark(name string) *Watermark {
+	if len(name) == 0 {
+		return p.options.Watermark
+	}
+
+	return p.options.Watermarks[name]
+}
+
+// ApplyWatermark puts the named mark on img the way renditions get it,
+// only if img is at least WatermarkMinWidth wide
+func (p Processor) ApplyWatermark(img image.Image, watermark string) image.Image {
+	mark := p.Watermark(watermark)
+	if mark == nil || img.Bounds().Dx() < p.options.WatermarkMinWidth {
+		return img
+	}
+
+	return mark.Apply(img)
+}
+
+// HasWatermark checks if albums can use the named watermark
+func (p Processor) HasWatermark(name string) bool {
+	if len(name) == 0 || name == cameraroll.WatermarkNone {
+		return true
+	}
+
+	_, ok := p.options.Watermarks[name]
+	return ok
+}
+
+// PublishesLocation checks if the MetadataPolicy lets the public see where images were shot
+func (p Processor) PublishesLocation() bool {
+	return p.options.MetadataPolicy == MetadataKeep
+}
+
+// Process reads the EXIF data of an original image,
+// then decodes it to create its thumbnail and renditions with the edits applied, if any.
+// Renditions of at least WatermarkMinWidth get the default mark, unless there's none,
+// and another set of them is made for each of the named watermarks that albums use instead.
+// The dimensions are those of the original.
+func (p Processor) Process(ctx context.Context, r io.ReadSeeker, edits *cameraroll.Edits, watermarks []string) (*Derivatives, error) {
+	// read the shooting information
+	info := ExtractExif(r)
+
+	// decode the original image upright
+	img, format, profile, err := decodeUpright(r)
+	if err != nil {
+		return nil, err
+	}
+
+	d := Derivatives{
+		Format:   format,
+		MimeType: MimeType(format),
+		Width:    img.Bounds().Dx(),
+		Height:   img.Bounds().Dy(),
+		Exif:     info,
+	}
+
+	if profile != nil {
+		d.ColorSpace = profile.Name
+
+		// derivatives of images that couldn't be converted to sRGB carry the profile along
+		if profile.NeedsTag() {
+			d.profile = profile.Data
+		}
+	}
+
+	img = ApplyEdits(img, edits)
+
+	if err := p.createThumbnail(ctx, img, &d); err != nil {
+		p.Discard(ctx, &d)
+		return nil, err
+	}
+
+	if err := p.createRenditions(ctx, img, watermarks, &d); err != nil {
+		p.Discard(ctx, &d)
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+// PerceptualHashOf decodes an image from the start of r to compute its perceptual hash,
+// without creating any derivatives
+func PerceptualHashOf(r io.ReadSeeker) (uint64, error) {
+	img, _, _, err := decodeUpright(r)
+	if err != nil {
+		return 0, err
+	}
+
+	return PerceptualHash(img), nil
+}
+
+// decodeUpright decodes an image from the start of r,
+// rotating and flipping it according to its EXIF orientation.
+// Colors are converted to sRGB from the embedded ICC profile where possible,
+// the profile is returned if there's one.
+func decodeUpright(r io.ReadSeeker) (image.Image, string, *ColorProfile, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, "", nil, err
+	}
+
+	orientation := ReadOrientation(r)
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, "", nil, err
+	}
+
+	img, format, err := Decode(r)
+	if err != nil {
+		return nil, format, nil, err
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, format, nil, err
+	}
+
+	profile := ReadColorProfile(r, format)
+	if profile != nil {
+		img = profile.ToSRGB(img)
+	}
+
+	return Orient(img, orientation), format, profile, nil
+}
+
+// SaveOriginal keeps the untouched original in the private store,
+// and puts a copy stripped according to the MetadataPolicy into the public one
+func (p Processor) SaveOriginal(ctx context.Context, key string, r io.Reader, format string) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("SaveOriginal [%s]: %v", key, err)
+	}
+
+	stripped, err := StripMetadata(data, format, p.options.MetadataPolicy)
+	if err != nil {
+		return fmt.Errorf("SaveOriginal [%s]: %v", key, err)
+	}
+
+	if err := p.originals.Put(ctx, key, bytes.NewReader(data), int64(len(data)), MimeType(format)); err != nil {
+		return err
+	}
+
+	if err := p.store.Put(ctx, key, bytes.NewReader(stripped), int64(len(stripped)), MimeType(format)); err != nil {
+		p.DeleteOriginal(ctx, key)
+		return err
+	}
+
+	return nil
+}
+
+// OpenOriginal opens the untouched original saved under key
+func (p Processor) OpenOriginal(ctx context.Context, key string) (io.ReadSeekCloser, *cameraroll.AssetInfo, error) {
+	return openOriginal(ctx, p.originals, p.store, key)
+}
+
+// DeleteOriginal moves the original saved under key out of both stores
+func (p Processor) DeleteOriginal(ctx context.Context, key string) {
+	if err := p.originals.Delete(ctx, key); err != nil && !errors.Is(err, cameraroll.ErrAssetNotFound) {
+		log.Println(err)
+	}
+
+	if err := p.store.Delete(ctx, key); err != nil && !errors.Is(err, cameraroll.ErrAssetNotFound) {
+		log.Println(err)
+	}
+}
+
+// openOriginal opens an original from the private store, falling back to
+// the public one for images uploaded before originals were kept apart
+func openOriginal(ctx context.Context, originals cameraroll.AssetStore, store cameraroll.AssetStore, key string) (io.ReadSeekCloser, *cameraroll.AssetInfo, error) {
+	info, err := originals.Stat(ctx, key)
+	if errors.Is(err, cameraroll.ErrAssetNotFound) {
+		originals = store
+		info, err = originals.Stat(ctx, key)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := originals.Get(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return f, info, nil
+}
+
+// KeyFromURL finds the key of an asset in the stores from its public URL
+func (p Processor) KeyFromURL(assetURL string) string {
+	return storage.KeyFromURL(assetURL, url.GetPathFromURL(p.baseURL))
+}
+
+// DeleteDerivatives moves the thumbnail and renditions of img out of the store
+func (p Processor) DeleteDerivatives(ctx context.Context, img *cameraroll.Image) {
+	keys := []string{p.KeyFromURL(img.Thumbnail)}
+	for _, rendition := range img.Renditions {
+		keys = append(keys, p.KeyFromURL(rendition.URL))
+	}
+
+	for _, key := range keys {
+		if len(key) == 0 {
+			continue
+		}
+
+		if err := p.store.Delete(ctx, key); err != nil && !errors.Is(err, cameraroll.ErrAssetNotFound) {
+			log.Println(err)
+		}
+	}
+}
+
+// ReadDimensions reads the format and upright dimensions of an image from the start of r,
+// without decoding its pixels
+func ReadDimensions(r io.ReadSeeker) (int, int, string, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, "", err
+	}
+
+	orientation := ReadOrientation(r)
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, "", err
+	}
+
+	config, format, err := DecodeConfig(r)
+	if err != nil {
+		return 0, 0, format, err
+	}
+
+	// the sides are swapped for images rotated by 90 degrees
+	if orientation >= OrientationTranspose {
+		return config.Height, config.Width, format, nil
+	}
+
+	return config.Width, config.Height, format, nil
+}
+
+// Discard removes the derivatives from the store, cleaning up after a failed upload
+func (p Processor) Discard(ctx context.Context, d *Derivatives) {
+	for _, key := range d.keys {
+		if err := p.store.Delete(ctx, key); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// createThumbnail shrinks img to fit in a box of ThumbnailSize,
+// computing the placeholder and perceptual hash of the image from the thumbnail
+func (p Processor) createThumbnail(ctx context.Context, img image.Image, d *Derivatives) error {
+	size := uint(p.options.ThumbnailSize)
+	thumb := resize.Thumbnail(size, size, img, resize.Lanczos3)
+
+	d.PerceptualHash = PerceptualHash(thumb)
+
+	placeholder, err := NewPlaceholder(thumb)
+	if err != nil {
+		return err
+	}
+	d.Placeholder = placeholder
+
+	// keep transparency in PNG, everything else goes to JPEG
+	format := OutputFormat(thumb)
+
+	// get a uuid for the thumbnail's filename
+	key := fmt.Sprintf("%s.%s", uuid.New().String(), Extension(format))
+
+	if _, err := p.save(ctx, key, thumb, format, d.profile); err != nil {
+		return err
+	}
+	d.keys = append(d.keys, key)
+
+	d.Thumbnail = url.Join(p.baseURL, key)
+	d.ThumbnailWidth = thumb.Bounds().Dx()
+	d.ThumbnailHeight = thumb.Bounds().Dy()
+
+	return nil
+}
+
+// CreatePlaceholder computes the placeholder of an image from its thumbnail in the store,
+// covering images uploaded before placeholders existed
+func (p Processor) CreatePlaceholder(ctx context.Context, thumbnailKey string) (cameraroll.Placeholder, error) {
+	f, err := p.store.Get(ctx, thumbnailKey)
+	if err != nil {
+		return cameraroll.Placeholder{}, err
+	}
+	defer f.Close()
+
+	thumb, _, err := Decode(f)
+	if err != nil {
+		return cameraroll.Placeholder{}, err
+	}
+
+	return NewPlaceholder(thumb)
+}
+
+// createRenditions resizes img to each of the RenditionWidths no wider than itself,
+// and to its own width in place of the ones it's too narrow for,
+// putting the default mark on the ones wide enough.
+// The named watermarks get their own copies of the renditions wide enough, the narrower ones are shared.
+func (p Processor) createRenditions(ctx context.Context, img image.Image, watermarks []string, d *Derivatives) error {
+	// each set of renditions shares a uuid
+	watermarks = append([]string{""}, watermarks...)
+	names := make(map[string]string, len(watermarks))
+	for _, watermark := range watermarks {
+		names[watermark] = uuid.New().String()
+	}
+
+	for _, width := range renditionWidths(p.options.RenditionWidths, img.Bounds().Dx()) {
+		resized := resize.Resize(uint(width), 0, img, resize.Lanczos3)
+
+		for _, watermark := range watermarks {
+			if len(watermark) > 0 && width < p.options.WatermarkMinWidth {
+				continue
+			}
+
+			rendition := p.ApplyWatermark(resized, watermark)
+			format := OutputFormat(rendition)
+			key := fmt.Sprintf("%s-%dw.%s", names[watermark], width, Extension(format))
+
+			size, err := p.save(ctx, key, rendition, format, d.profile)
+			if err != nil {
+				return err
+			}
+			d.keys = append(d.keys, key)
+
+			d.Renditions = append(d.Renditions, &cameraroll.Rendition{
+				URL:       url.Join(p.baseURL, key),
+				MimeType:  MimeType(format),
+				Width:     rendition.Bounds().Dx(),
+				Height:    rendition.Bounds().Dy(),
+				Size:      size,
+				Watermark: watermark,
+			})
+		}
+	}
+
+	return nil
+}
+
+// renditionWidths picks the widths of the renditions of an image maxWidth pixels wide.
+// Images are never upscaled, the widths wider than the image make way for a single one at its own width
+// so that even small images get a rendition.
+func renditionWidths(widths []int, maxWidth int) []int {
+	picked := []int{}
+	full := false
+
+	for _, width := range widths {
+		if width > maxWidth {
+			width = maxWidth
+		}
+
+		// one rendition at full width is enough
+		if width == maxWidth {
+			if full {
+				continue
+			}
+			full = true
+		}
+
+		picked = append(picked, width)
+	}
+
+	return picked
+}
+
+// save encodes img, tagged with the ICC profile if given, and puts it into the store,
+// returning its size in bytes
+func (p Processor) save(ctx context.Context, key string, img image.Image, format string, profile []byte) (int64, error) {
+	data, err := EncodeTagged(img, format, profile)
+	if err != nil {
+		return 0, err
+	}
+
+	size := int64(len(data))
+	if err := p.store.Put(ctx, key, bytes.NewReader(data), size, MimeType(format)); err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
@@ -0,0 +1,28 @@
+package imaging
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRenditionWidths(t *testing.T) {
+	widths := []int{320, 640, 1280, 2048}
+
+	tests := map[int][]int{
+		4000: {320, 640, 1280, 2048},
+		2048: {320, 640, 1280, 2048},
+		1000: {320, 640, 1000},
+		640:  {320, 640},
+		200:  {200},
+	}
+
+	for maxWidth, want := range tests {
+		if got := renditionWidths(widths, maxWidth); !reflect.DeepEqual(got, want) {
+			t.Errorf("renditionWidths(%d) = %v, want %v", maxWidth, got, want)
+		}
+	}
+
+	if got := renditionWidths(nil, 1000); len(got) != 0 {
+		t.Errorf("renditionWidths without widths = %v, want none", got)
+	}
+}
@@ -0,0 +1,148 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/nfnt/resize"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+	"chujungeng/camera-roll/pkg/storage"
+)
+
+// Resizer resizes original images on the fly, keeping the results in a DiskCache
+type Resizer struct {
+	processor *Processor
+	cache     *storage.DiskCache
+
+	// whitelist of sizes in WxH format
+	sizes map[string]bool
+}
+
+// NewResizer is the constructor method for Resizer.
+// Originals are opened and marked by the processor.
+// Only the sizes listed in WxH format can be requested,
+// where a side of 0 is not bounded.
+func NewResizer(processor *Processor, cache *storage.DiskCache, sizes []string) *Resizer {
+	resizer := Resizer{
+		processor: processor,
+		cache:     cache,
+		sizes:     make(map[string]bool, len(sizes)),
+	}
+
+	for _, size := range sizes {
+		resizer.sizes[size] = true
+	}
+
+	return &resizer
+}
+
+// Allowed checks if a size is on the whitelist
+func (resizer Resizer) Allowed(width int, height int) bool {
+	return resizer.sizes[fmt.Sprintf("%dx%d", width, height)]
+}
+
+// cacheKey is where a resized image is kept in the cache, apart for each mark
+func cacheKey(imageID int64, width int, height int, format string, cropped bool, watermark string) string {
+	name := fmt.Sprintf("%dx%d", width, height)
+	if cropped {
+		name += "-crop"
+	}
+
+	mark := "wm"
+	if len(watermark) > 0 {
+		mark += "-" + watermark
+	}
+
+	return fmt.Sprintf("%d/%s.%s.%s", imageID, name, mark, Extension(format))
+}
+
+// Resize shrinks the original image of imageID, with the edits applied if any, to fit in width x height,
+// returning the encoded result and when it was created.
+// Given a focus and both sides, the image is cropped to the aspect ratio of width x height
+// around the focus instead. The image is never upscaled.
+// The result gets the named mark the way renditions do, the default one for an empty name.
+func (resizer Resizer) Resize(ctx context.Context, imageID int64, originalKey string, width int, height int, format string, focus *Focus, edits *cameraroll.Edits, watermark string) (io.ReadSeekCloser, time.Time, error) {
+	if !resizer.Allowed(width, height) {
+		return nil, time.Time{}, fmt.Errorf("Resize [%d]: size %dx%d is not allowed", imageID, width, height)
+	}
+
+	// cropping needs an aspect ratio
+	if width == 0 || height == 0 {
+		focus = nil
+	}
+
+	key := cacheKey(imageID, width, height, format, focus != nil, watermark)
+
+	// serve from the cache if possible
+	if f, ok := resizer.cache.Get(key); ok {
+		fi, err := f.Stat()
+		if err == nil {
+			return f, fi.ModTime(), nil
+		}
+		f.Close()
+	}
+
+	// decode the original upright
+	original, _, err := resizer.processor.OpenOriginal(ctx, originalKey)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer original.Close()
+
+	img, _, profile, err := decodeUpright(original)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	// images that couldn't be converted to sRGB carry their profile along
+	var tag []byte
+	if profile != nil && profile.NeedsTag() {
+		tag = profile.Data
+	}
+
+	img = ApplyEdits(img, edits)
+
+	if focus != nil {
+		img = CropToAspect(img, width, height, *focus)
+	}
+
+	// a side of 0 is only bounded by the original
+	maxWidth, maxHeight := uint(width), uint(height)
+	if width == 0 {
+		maxWidth = uint(img.Bounds().Dx())
+	}
+	if height == 0 {
+		maxHeight = uint(img.Bounds().Dy())
+	}
+
+	resized := resize.Thumbnail(maxWidth, maxHeight, img, resize.Lanczos3)
+	resized = resizer.processor.ApplyWatermark(resized, watermark)
+
+	data, err := EncodeTagged(resized, format, tag)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if err := resizer.cache.Put(key, data); err != nil {
+		log.Println(err)
+	}
+
+	return nopCloser{bytes.NewReader(data)}, time.Now(), nil
+}
+
+// Purge drops all the cached copies of an image
+func (resizer Resizer) Purge(imageID int64) {
+	resizer.cache.Purge(fmt.Sprintf("%d/", imageID))
+}
+
+// nopCloser adds a no-op Close method to an io.ReadSeeker
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
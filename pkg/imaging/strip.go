@@ -0,0 +1,393 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// policies for the metadata of the publicly served copies of originals
+const (
+	MetadataKeep     = "keep"
+	MetadataStripGPS = "strip_gps"
+	MetadataStripAll = "strip_all"
+)
+
+// ValidMetadataPolicy checks if policy is one of the known metadata policies
+func ValidMetadataPolicy(policy string) bool {
+	return policy == MetadataKeep || policy == MetadataStripGPS || policy == MetadataStripAll
+}
+
+var errMalformedImage = errors.New("malformed image")
+
+// StripMetadata removes metadata from an encoded image according to policy,
+// leaving the pixels untouched. Formats that carry no metadata are returned as they are.
+func StripMetadata(data []byte, format string, policy string) ([]byte, error) {
+	if policy == MetadataKeep {
+		return data, nil
+	}
+
+	switch format {
+	case FormatJPEG:
+		return stripJPEG(data, policy)
+	case FormatPNG:
+		return stripPNG(data, policy)
+	case FormatWebP:
+		return stripWebP(data, policy)
+	default:
+		return data, nil
+	}
+}
+
+// JPEG markers
+const (
+	markerSOI   = 0xD8
+	markerSOS   = 0xDA
+	markerAPP0  = 0xE0
+	markerAPP1  = 0xE1
+	markerAPP2  = 0xE2
+	markerAPP14 = 0xEE
+	markerAPP15 = 0xEF
+	markerCOM   = 0xFE
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/")
+)
+
+// stripJPEG rewrites the segments of a JPEG file up to its image data.
+// Stripping everything keeps JFIF, ICC profiles and Adobe color transforms,
+// plus a minimal EXIF segment with the orientation so the image stays upright.
+func stripJPEG(data []byte, policy string) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != markerSOI {
+		return nil, errMalformedImage
+	}
+
+	out := bytes.Buffer{}
+	out.Write(data[:2])
+
+	// the orientation goes after the JFIF segment, which has to come right after SOI
+	var orientation []byte
+	if policy == MetadataStripAll {
+		if o := ReadOrientation(bytes.NewReader(data)); o != OrientationNormal {
+			orientation = orientationSegment(o)
+		}
+	}
+
+	writeOrientation := func() {
+		out.Write(orientation)
+		orientation = nil
+	}
+
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF || i+1 >= len(data) {
+			return nil, errMalformedImage
+		}
+
+		marker := data[i+1]
+
+		// fill bytes
+		if marker == 0xFF {
+			i++
+			continue
+		}
+
+		// everything from the start of scan on is image data
+		if marker == markerSOS {
+			writeOrientation()
+			out.Write(data[i:])
+			break
+		}
+
+		// standalone markers carry no length
+		if marker == 0x01 || marker >= 0xD0 && marker <= 0xD7 {
+			writeOrientation()
+			out.Write(data[i : i+2])
+			i += 2
+			continue
+		}
+
+		if i+4 > len(data) {
+			return nil, errMalformedImage
+		}
+
+		// the length counts its own 2 bytes
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errMalformedImage
+		}
+
+		segment := data[i:end]
+		payload := segment[4:]
+		i = end
+
+		switch {
+		case policy == MetadataStripAll && isMetadataMarker(marker):
+			continue
+		case policy == MetadataStripGPS && marker == markerAPP1 && bytes.HasPrefix(payload, xmpHeader):
+			// XMP may repeat the location, there's no telling where
+			continue
+		case policy == MetadataStripGPS && marker == markerAPP1 && bytes.HasPrefix(payload, exifHeader):
+			segment = append([]byte{}, segment...)
+			if err := zeroGPS(segment[4+len(exifHeader):]); err != nil {
+				return nil, err
+			}
+		}
+
+		// the orientation follows JFIF, or comes first if there's none
+		if marker == markerAPP0 {
+			out.Write(segment)
+			writeOrientation()
+		} else {
+			writeOrientation()
+			out.Write(segment)
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+// isMetadataMarker checks if a JPEG marker holds metadata that isn't needed to display the image
+func isMetadataMarker(marker byte) bool {
+	if marker == markerAPP0 || marker == markerAPP2 || marker == markerAPP14 {
+		return false
+	}
+
+	return marker >= markerAPP1 && marker <= markerAPP15 || marker == markerCOM
+}
+
+// orientationSegment builds an APP1 segment whose EXIF data only holds the orientation
+func orientationSegment(orientation int) []byte {
+	const (
+		tagOrientation = 0x0112
+		typeShort      = 3
+	)
+
+	tiff := []byte{'M', 'M', 0x00, 0x2A}
+	tiff = binary.BigEndian.AppendUint32(tiff, 8) // offset of IFD0
+	tiff = binary.BigEndian.AppendUint16(tiff, 1) // number of entries
+	tiff = binary.BigEndian.AppendUint16(tiff, tagOrientation)
+	tiff = binary.BigEndian.AppendUint16(tiff, typeShort)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, uint16(orientation))
+	tiff = binary.BigEndian.AppendUint16(tiff, 0)
+	tiff = binary.BigEndian.AppendUint32(tiff, 0) // no next IFD
+
+	segment := []byte{0xFF, markerAPP1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(2+len(exifHeader)+len(tiff)))
+	segment = append(segment, exifHeader...)
+	segment = append(segment, tiff...)
+
+	return segment
+}
+
+// zeroGPS wipes the GPS IFD of TIFF structured EXIF data in place,
+// leaving an empty GPS IFD behind so that no offsets have to move
+func zeroGPS(tiff []byte) error {
+	const (
+		tagGPSInfo = 0x8825
+		entrySize  = 12
+	)
+
+	if len(tiff) < 8 {
+		return errMalformedImage
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return errMalformedImage
+	}
+
+	// find the GPS IFD from the entries of IFD0
+	ifd0 := int(order.Uint32(tiff[4:8]))
+	if ifd0+2 > len(tiff) {
+		return errMalformedImage
+	}
+
+	gpsIFD := -1
+	count := int(order.Uint16(tiff[ifd0:]))
+	for n := 0; n < count; n++ {
+		entry := ifd0 + 2 + n*entrySize
+		if entry+entrySize > len(tiff) {
+			return errMalformedImage
+		}
+
+		if order.Uint16(tiff[entry:]) == tagGPSInfo {
+			gpsIFD = int(order.Uint32(tiff[entry+8:]))
+			break
+		}
+	}
+
+	// nothing to strip
+	if gpsIFD < 0 {
+		return nil
+	}
+
+	if gpsIFD+2 > len(tiff) {
+		return errMalformedImage
+	}
+
+	count = int(order.Uint16(tiff[gpsIFD:]))
+	for n := 0; n < count; n++ {
+		entry := gpsIFD + 2 + n*entrySize
+		if entry+entrySize > len(tiff) {
+			return errMalformedImage
+		}
+
+		// values over 4 bytes are kept outside of the entry
+		size := tiffTypeSize(order.Uint16(tiff[entry+2:])) * int(order.Uint32(tiff[entry+4:]))
+		if size > 4 {
+			offset := int(order.Uint32(tiff[entry+8:]))
+			if offset >= 0 && offset+size <= len(tiff) {
+				zero(tiff[offset : offset+size])
+			}
+		}
+
+		zero(tiff[entry : entry+entrySize])
+	}
+
+	// an empty GPS IFD, followed by a zeroed next IFD offset
+	order.PutUint16(tiff[gpsIFD:], 0)
+
+	return nil
+}
+
+// zero overwrites b with zeros
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// tiffTypeSize returns the size in bytes of a TIFF field type
+func tiffTypeSize(fieldType uint16) int {
+	switch fieldType {
+	case 1, 2, 6, 7:
+		return 1
+	case 3, 8:
+		return 2
+	case 4, 9, 11:
+		return 4
+	case 5, 10, 12:
+		return 8
+	default:
+		return 0
+	}
+}
+
+// stripPNG drops the metadata chunks of a PNG file.
+// Stripping GPS wipes the location from the eXIf chunk and drops XMP.
+func stripPNG(data []byte, policy string) ([]byte, error) {
+	const signatureLength = 8
+
+	if len(data) < signatureLength {
+		return nil, errMalformedImage
+	}
+
+	out := bytes.Buffer{}
+	out.Write(data[:signatureLength])
+
+	i := signatureLength
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, errMalformedImage
+		}
+
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errMalformedImage
+		}
+
+		chunk := data[i:end]
+		chunkType := string(chunk[4:8])
+		chunkData := chunk[8 : 8+length]
+		i = end
+
+		switch {
+		case policy == MetadataStripAll && (chunkType == "eXIf" || chunkType == "tEXt" || chunkType == "zTXt" || chunkType == "iTXt" || chunkType == "tIME"):
+			continue
+		case policy == MetadataStripGPS && chunkType == "iTXt" && bytes.HasPrefix(chunkData, []byte("XML:com.adobe.xmp\x00")):
+			continue
+		case policy == MetadataStripGPS && chunkType == "eXIf":
+			chunk = append([]byte{}, chunk...)
+			if err := zeroGPS(chunk[8 : 8+length]); err != nil {
+				return nil, err
+			}
+			binary.BigEndian.PutUint32(chunk[8+length:], crc32.ChecksumIEEE(chunk[4:8+length]))
+		}
+
+		out.Write(chunk)
+	}
+
+	return out.Bytes(), nil
+}
+
+// stripWebP drops the EXIF and XMP chunks of a WebP file, updating the VP8X flags.
+// Stripping GPS wipes the location from the EXIF chunk and drops XMP.
+func stripWebP(data []byte, policy string) ([]byte, error) {
+	const (
+		headerLength = 12
+		flagEXIF     = 0x08
+		flagXMP      = 0x04
+	)
+
+	if len(data) < headerLength || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errMalformedImage
+	}
+
+	out := bytes.Buffer{}
+	out.Write(data[:headerLength])
+
+	i := headerLength
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, errMalformedImage
+		}
+
+		length := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + length + length%2 // chunks are padded to an even size
+		if length < 0 || end > len(data) {
+			return nil, errMalformedImage
+		}
+
+		chunk := data[i:end]
+		fourCC := string(chunk[:4])
+		i = end
+
+		switch {
+		case fourCC == "XMP ":
+			continue
+		case fourCC == "EXIF" && policy == MetadataStripAll:
+			continue
+		case fourCC == "EXIF" && policy == MetadataStripGPS:
+			chunk = append([]byte{}, chunk...)
+			if err := zeroGPS(bytes.TrimPrefix(chunk[8:8+length], exifHeader)); err != nil {
+				return nil, err
+			}
+		case fourCC == "VP8X" && length > 0:
+			// the flags must match the chunks that are left
+			chunk = append([]byte{}, chunk...)
+			chunk[8] &^= flagXMP
+			if policy == MetadataStripAll {
+				chunk[8] &^= flagEXIF
+			}
+		}
+
+		out.Write(chunk)
+	}
+
+	result := out.Bytes()
+	binary.LittleEndian.PutUint32(result[4:8], uint32(len(result)-8))
+
+	return result, nil
+}
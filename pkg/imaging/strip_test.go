@@ -0,0 +1,275 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// jpegSegment builds a JPEG segment, its length counting its own 2 bytes
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(2+len(payload)))
+	return append(segment, payload...)
+}
+
+// gpsTIFF builds big endian EXIF data whose IFD0 only points to a GPS IFD
+// holding a latitude reference and a latitude, returning it along with the offset of the latitude
+func gpsTIFF() ([]byte, int) {
+	const (
+		tagGPSInfo     = 0x8825
+		tagLatitudeRef = 0x0001
+		tagLatitude    = 0x0002
+		typeASCII      = 2
+		typeLong       = 4
+		typeRational   = 5
+	)
+
+	// header, IFD0 with 1 entry at 8, GPS IFD with 2 entries at 26, latitude at 56
+	tiff := []byte{'M', 'M', 0x00, 0x2A}
+	tiff = binary.BigEndian.AppendUint32(tiff, 8)
+
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, tagGPSInfo)
+	tiff = binary.BigEndian.AppendUint16(tiff, typeLong)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint32(tiff, 26)
+	tiff = binary.BigEndian.AppendUint32(tiff, 0)
+
+	tiff = binary.BigEndian.AppendUint16(tiff, 2)
+	tiff = binary.BigEndian.AppendUint16(tiff, tagLatitudeRef)
+	tiff = binary.BigEndian.AppendUint16(tiff, typeASCII)
+	tiff = binary.BigEndian.AppendUint32(tiff, 2)
+	tiff = append(tiff, 'N', 0, 0, 0)
+	tiff = binary.BigEndian.AppendUint16(tiff, tagLatitude)
+	tiff = binary.BigEndian.AppendUint16(tiff, typeRational)
+	tiff = binary.BigEndian.AppendUint32(tiff, 3)
+	tiff = binary.BigEndian.AppendUint32(tiff, 56)
+	tiff = binary.BigEndian.AppendUint32(tiff, 0)
+
+	latitude := len(tiff)
+	for _, v := range []uint32{48, 1, 51, 1, 30, 1} {
+		tiff = binary.BigEndian.AppendUint32(tiff, v)
+	}
+
+	return tiff, latitude
+}
+
+func TestStripMetadataKeep(t *testing.T) {
+	data := testJPEG(t, jpegSegment(markerCOM, []byte("hello")))
+
+	stripped, err := StripMetadata(data, FormatJPEG, MetadataKeep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(stripped, data) {
+		t.Error("keep changed the file")
+	}
+}
+
+func TestStripJPEGAll(t *testing.T) {
+	data := testJPEG(t,
+		orientationSegment(OrientationRotate90CW),
+		jpegSegment(markerAPP1, append([]byte{}, xmpHeader...)),
+		jpegSegment(markerCOM, []byte("secret")))
+
+	stripped, err := StripMetadata(data, FormatJPEG, MetadataStripAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(stripped, xmpHeader) || bytes.Contains(stripped, []byte("secret")) {
+		t.Error("metadata was left in")
+	}
+
+	if orientation := ReadOrientation(bytes.NewReader(stripped)); orientation != OrientationRotate90CW {
+		t.Errorf("orientation = %d, want %d", orientation, OrientationRotate90CW)
+	}
+
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped file doesn't decode: %v", err)
+	}
+}
+
+func TestStripJPEGKeepsJFIFFirst(t *testing.T) {
+	jfif := jpegSegment(markerAPP0, []byte("JFIF\x00\x01\x02\x00\x00\x01\x00\x01\x00\x00"))
+	data := testJPEG(t,
+		jfif,
+		orientationSegment(OrientationRotate90CW),
+		jpegSegment(markerCOM, []byte("secret")))
+
+	stripped, err := StripMetadata(data, FormatJPEG, MetadataStripAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// JFIF has to come right after SOI, the orientation follows it
+	want := append(append([]byte{0xFF, markerSOI}, jfif...), orientationSegment(OrientationRotate90CW)...)
+	if !bytes.HasPrefix(stripped, want) {
+		t.Errorf("stripped file starts with % x, want % x", stripped[:len(want)], want)
+	}
+}
+
+func TestStripJPEGGPS(t *testing.T) {
+	tiff, latitude := gpsTIFF()
+	data := testJPEG(t,
+		jpegSegment(markerAPP1, append(append([]byte{}, exifHeader...), tiff...)),
+		jpegSegment(markerCOM, []byte("comment")))
+
+	stripped, err := StripMetadata(data, FormatJPEG, MetadataStripGPS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(stripped, []byte("comment")) {
+		t.Error("comment was stripped along with the location")
+	}
+
+	// the EXIF segment keeps its place and size
+	start := bytes.Index(stripped, exifHeader) + len(exifHeader)
+	if start < len(exifHeader) {
+		t.Fatal("EXIF segment is gone")
+	}
+	stripped = stripped[start:]
+
+	if count := binary.BigEndian.Uint16(stripped[26:]); count != 0 {
+		t.Errorf("GPS IFD has %d entries left", count)
+	}
+
+	if !bytes.Equal(stripped[latitude:latitude+24], make([]byte, 24)) {
+		t.Error("latitude was left in")
+	}
+}
+
+func TestStripJPEGMalformed(t *testing.T) {
+	tests := map[string][]byte{
+		"missing SOI":      {0x00, 0x00, 0x00, 0x00},
+		"length below 2":   {0xFF, markerSOI, 0xFF, markerCOM, 0x00, 0x01, 0xFF, markerSOS},
+		"length past end":  {0xFF, markerSOI, 0xFF, markerCOM, 0x00, 0x10, 'a', 'b'},
+		"truncated length": {0xFF, markerSOI, 0xFF, markerCOM, 0x00},
+		"missing marker":   {0xFF, markerSOI, 0x00, 0x00, 0x00},
+	}
+
+	for name, data := range tests {
+		if _, err := StripMetadata(data, FormatJPEG, MetadataStripAll); !errors.Is(err, errMalformedImage) {
+			t.Errorf("%s: err = %v, want %v", name, err, errMalformedImage)
+		}
+	}
+}
+
+func TestStripPNGAll(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 1, color.NRGBA{R: 255, A: 255})
+
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// put a tEXt chunk right after IHDR, which takes up 25 bytes after the signature
+	text := []byte("tEXtComment\x00secret")
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(text)-4))
+	chunk = append(chunk, text...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(text))
+
+	withText := append(append(append([]byte{}, data[:33]...), chunk...), data[33:]...)
+	if _, err := png.Decode(bytes.NewReader(withText)); err != nil {
+		t.Fatal(err)
+	}
+
+	stripped, err := StripMetadata(withText, FormatPNG, MetadataStripAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(stripped, data) {
+		t.Error("tEXt chunk was left in")
+	}
+}
+
+func TestStripWebPMalformed(t *testing.T) {
+	if _, err := StripMetadata([]byte("RIFF\x00\x00\x00\x00WEBPVP8X\xff\xff\xff\x7f"), FormatWebP, MetadataStripAll); !errors.Is(err, errMalformedImage) {
+		t.Errorf("err = %v, want %v", err, errMalformedImage)
+	}
+}
+
+// webpChunk builds a RIFF chunk, padded to an even size
+func webpChunk(fourCC string, payload []byte) []byte {
+	chunk := append([]byte(fourCC), binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))...)
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+
+	return chunk
+}
+
+// testWebP builds a WebP file out of chunks
+func testWebP(chunks ...[]byte) []byte {
+	body := []byte("WEBP")
+	for _, chunk := range chunks {
+		body = append(body, chunk...)
+	}
+
+	data := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	return append(data, body...)
+}
+
+func TestStripWebP(t *testing.T) {
+	const (
+		flagICC  = 0x20
+		flagEXIF = 0x08
+		flagXMP  = 0x04
+	)
+
+	tiff, latitude := gpsTIFF()
+	vp8x := []byte{flagICC | flagEXIF | flagXMP, 0, 0, 0, 15, 0, 0, 7, 0, 0}
+
+	// chunks are found by walking them, whatever the pixels hold
+	iccp := webpChunk("ICCP", []byte("profile"))
+	pixels := webpChunk("VP8L", []byte("pixels, not VP8X"))
+	data := testWebP(
+		webpChunk("VP8X", vp8x),
+		iccp,
+		pixels,
+		webpChunk("EXIF", append(append([]byte{}, exifHeader...), tiff...)),
+		webpChunk("XMP ", []byte("<x:xmpmeta/>")))
+
+	stripped, err := StripMetadata(data, FormatWebP, MetadataStripAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := testWebP(webpChunk("VP8X", append([]byte{flagICC}, vp8x[1:]...)), iccp, pixels)
+	if !bytes.Equal(stripped, want) {
+		t.Errorf("strip_all = % x, want % x", stripped, want)
+	}
+
+	// stripping GPS keeps the rest of the EXIF data
+	stripped, err = StripMetadata(data, FormatWebP, MetadataStripGPS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exif := bytes.Index(stripped, []byte("EXIF"))
+	if exif < 0 || stripped[20] != flagICC|flagEXIF || bytes.Contains(stripped, []byte("xmpmeta")) {
+		t.Fatalf("strip_gps = % x, want the EXIF chunk kept and XMP dropped", stripped)
+	}
+
+	start := exif + 8 + len(exifHeader) + latitude
+	if lat := stripped[start : start+24]; !bytes.Equal(lat, make([]byte, 24)) {
+		t.Errorf("latitude = % x, want it zeroed", lat)
+	}
+
+	if size := binary.LittleEndian.Uint32(stripped[4:8]); int(size) != len(stripped)-8 {
+		t.Errorf("RIFF size = %d, want %d", size, len(stripped)-8)
+	}
+}
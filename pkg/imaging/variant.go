@@ -0,0 +1,132 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+	"chujungeng/camera-roll/pkg/storage"
+)
+
+// ErrNoVariant is returned when an asset has no copy in the requested format
+var ErrNoVariant = errors.New("no variant in the requested format")
+
+// Converter finds copies of the public assets in other formats,
+// converting them on the fly into a DiskCache if the store has none
+type Converter struct {
+	store cameraroll.AssetStore
+	cache *storage.DiskCache
+
+	// assets wider than this are never converted
+	maxWidth int
+}
+
+// NewConverter is the constructor method for Converter.
+// Only assets up to maxWidth pixels wide are converted on the fly,
+// which covers thumbnails and renditions but leaves large originals alone.
+func NewConverter(store cameraroll.AssetStore, cache *storage.DiskCache, maxWidth int) *Converter {
+	converter := Converter{
+		store:    store,
+		cache:    cache,
+		maxWidth: maxWidth,
+	}
+
+	return &converter
+}
+
+// variantKey is the key of a copy of an asset in another format, next to it in the store
+func variantKey(key string, format string) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + "." + Extension(format)
+}
+
+// Variant opens a copy of the asset under key in the given format,
+// returning it along with when it was last modified.
+// A copy saved next to the asset in the store is preferred,
+// otherwise JPEG and PNG assets up to maxWidth are converted if the format can be encoded.
+// ErrNoVariant is returned if there's no way to get the asset in that format.
+func (c Converter) Variant(ctx context.Context, key string, format string) (io.ReadSeekCloser, time.Time, error) {
+	// the asset itself has to exist, a cached copy mustn't outlive it
+	info, err := c.store.Stat(ctx, key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	vKey := variantKey(key, format)
+	if vKey == key {
+		return nil, time.Time{}, ErrNoVariant
+	}
+
+	if vInfo, err := c.store.Stat(ctx, vKey); err == nil {
+		f, err := c.store.Get(ctx, vKey)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		return f, vInfo.ModTime, nil
+	}
+
+	source, ok := FormatFromExtension(strings.TrimPrefix(path.Ext(key), "."))
+	if !ok || source == format || !CanEncode(format) {
+		return nil, time.Time{}, ErrNoVariant
+	}
+
+	cacheKey := path.Join("variants", vKey)
+	if f, ok := c.cache.Get(cacheKey); ok {
+		return f, info.ModTime, nil
+	}
+
+	data, err := c.convert(ctx, key, source, format)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if err := c.cache.Put(cacheKey, data); err != nil {
+		log.Println(err)
+	}
+
+	return nopCloser{bytes.NewReader(data)}, info.ModTime, nil
+}
+
+// convert decodes the asset under key from the source format upright and in sRGB,
+// then encodes it in the given format
+func (c Converter) convert(ctx context.Context, key string, source string, format string) ([]byte, error) {
+	f, err := c.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// the profile of assets that couldn't be converted to sRGB would be lost
+	if profile := ReadColorProfile(f, source); profile != nil && profile.NeedsTag() {
+		return nil, ErrNoVariant
+	}
+
+	// decoding and encoding full-size originals on request would take too long
+	width, _, _, err := ReadDimensions(f)
+	if err != nil {
+		return nil, fmt.Errorf("Variant [%s]: %v", key, err)
+	}
+
+	if width > c.maxWidth {
+		return nil, ErrNoVariant
+	}
+
+	img, _, _, err := decodeUpright(f)
+	if err != nil {
+		return nil, fmt.Errorf("Variant [%s]: %v", key, err)
+	}
+
+	buf := bytes.Buffer{}
+	if err := Encode(&buf, img, format); err != nil {
+		return nil, fmt.Errorf("Variant [%s]: %v", key, err)
+	}
+
+	return buf.Bytes(), nil
+}
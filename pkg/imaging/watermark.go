@@ -0,0 +1,162 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+
+	"github.com/nfnt/resize"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomedium"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// where a watermark goes on an image
+const (
+	PositionTopLeft     = "top_left"
+	PositionTopRight    = "top_right"
+	PositionBottomLeft  = "bottom_left"
+	PositionBottomRight = "bottom_right"
+	PositionCenter      = "center"
+)
+
+// size that text marks are rendered at before being scaled to the image, in pixels
+const textMarkSize = 96
+
+// Watermark is a mark composited onto images, either a picture or a line of text
+type Watermark struct {
+	mark     image.Image
+	position string
+	opacity  float64
+	scale    float64
+}
+
+// NewImageWatermark is the constructor method for a Watermark showing the picture read from r,
+// usually a PNG with transparency.
+// The mark is drawn at position with an opacity from 0 to 1,
+// scaled to a width relative to the width of the image.
+func NewImageWatermark(r io.Reader, position string, opacity float64, scale float64) (*Watermark, error) {
+	mark, _, err := Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("NewImageWatermark: %v", err)
+	}
+
+	return newWatermark(mark, position, opacity, scale)
+}
+
+// NewTextWatermark is the constructor method for a Watermark showing a line of white text
+// with a soft shadow, readable on both light and dark images
+func NewTextWatermark(text string, position string, opacity float64, scale float64) (*Watermark, error) {
+	if len(text) == 0 {
+		return nil, fmt.Errorf("NewTextWatermark: empty text")
+	}
+
+	ttf, err := opentype.Parse(gomedium.TTF)
+	if err != nil {
+		return nil, fmt.Errorf("NewTextWatermark: %v", err)
+	}
+
+	face, err := opentype.NewFace(ttf, &opentype.FaceOptions{Size: textMarkSize, DPI: 72, Hinting: font.HintingNone})
+	if err != nil {
+		return nil, fmt.Errorf("NewTextWatermark: %v", err)
+	}
+	defer face.Close()
+
+	// leave room around the text for the shadow
+	shadow := textMarkSize / 24
+	metrics := face.Metrics()
+	width := font.MeasureString(face, text).Ceil() + 2*shadow
+	height := (metrics.Ascent + metrics.Descent).Ceil() + 2*shadow
+
+	mark := image.NewNRGBA(image.Rect(0, 0, width, height))
+	baseline := fixed.P(shadow, shadow+metrics.Ascent.Ceil())
+
+	drawer := font.Drawer{Dst: mark, Face: face}
+
+	drawer.Src = image.NewUniform(color.NRGBA{A: 128})
+	drawer.Dot = baseline.Add(fixed.P(shadow, shadow))
+	drawer.DrawString(text)
+
+	drawer.Src = image.White
+	drawer.Dot = baseline
+	drawer.DrawString(text)
+
+	return newWatermark(mark, position, opacity, scale)
+}
+
+// newWatermark checks the placement of a mark
+func newWatermark(mark image.Image, position string, opacity float64, scale float64) (*Watermark, error) {
+	switch position {
+	case PositionTopLeft, PositionTopRight, PositionBottomLeft, PositionBottomRight, PositionCenter:
+	default:
+		return nil, fmt.Errorf("unknown watermark position [%s]", position)
+	}
+
+	// a mark with an opacity of 0 is allowed, it's drawn invisibly
+	if opacity < 0 || opacity > 1 {
+		return nil, fmt.Errorf("watermark opacity [%g] must be within 0 and 1", opacity)
+	}
+
+	if scale <= 0 || scale > 1 {
+		return nil, fmt.Errorf("watermark scale [%g] must be within 0 and 1", scale)
+	}
+
+	wm := Watermark{
+		mark:     mark,
+		position: position,
+		opacity:  opacity,
+		scale:    scale,
+	}
+
+	return &wm, nil
+}
+
+// Apply composites the mark onto a copy of img
+func (wm *Watermark) Apply(img image.Image) image.Image {
+	b := img.Bounds()
+
+	// the mark never gets taller than the image
+	width := uint(float64(b.Dx()) * wm.scale)
+	markBounds := wm.mark.Bounds()
+	if maxWidth := uint(b.Dy() * markBounds.Dx() / markBounds.Dy()); width > maxWidth {
+		width = maxWidth
+	}
+
+	if width == 0 {
+		return img
+	}
+
+	mark := resize.Resize(width, 0, wm.mark, resize.Lanczos3)
+	size := mark.Bounds().Size()
+
+	// keep off the edges by a fraction of the shorter side
+	margin := b.Dx() / 50
+	if b.Dy() < b.Dx() {
+		margin = b.Dy() / 50
+	}
+
+	var at image.Point
+	switch wm.position {
+	case PositionTopLeft:
+		at = image.Pt(b.Min.X+margin, b.Min.Y+margin)
+	case PositionTopRight:
+		at = image.Pt(b.Max.X-margin-size.X, b.Min.Y+margin)
+	case PositionBottomLeft:
+		at = image.Pt(b.Min.X+margin, b.Max.Y-margin-size.Y)
+	case PositionBottomRight:
+		at = image.Pt(b.Max.X-margin-size.X, b.Max.Y-margin-size.Y)
+	default:
+		at = image.Pt(b.Min.X+(b.Dx()-size.X)/2, b.Min.Y+(b.Dy()-size.Y)/2)
+	}
+
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+
+	alpha := image.NewUniform(color.Alpha{A: uint8(wm.opacity*255 + 0.5)})
+	draw.DrawMask(dst, image.Rectangle{Min: at, Max: at.Add(size)}, mark, mark.Bounds().Min, alpha, image.Point{}, draw.Over)
+
+	return dst
+}
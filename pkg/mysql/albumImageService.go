@@ -34,6 +34,18 @@ func (service Service) RemoveImageFromAlbum(ctx context.Context, albumID int64,
 		return fmt.Errorf("RemoveImageFromAlbum albumID[%d] imageID[%d]: %v", albumID, imageID, err)
 	}
 
+	// the album falls back to the most recently added image if this was its cover
+	_, err = tx.ExecContext(ctx,
+		`UPDATE albums 
+		SET cover_id=NULL 
+		WHERE id=? AND cover_id=?`,
+		albumID,
+		imageID)
+
+	if err != nil {
+		return fmt.Errorf("RemoveImageFromAlbum albumID[%d] imageID[%d]: %v", albumID, imageID, err)
+	}
+
 	// commit the transaction
 	if err = tx.Commit(); err != nil {
 		return fmt.Errorf("RemoveImageFromAlbum albumID[%d] imageID[%d]: %v", albumID, imageID, err)
@@ -42,11 +54,25 @@ func (service Service) RemoveImageFromAlbum(ctx context.Context, albumID int64,
 	return nil
 }
 
-// GetImagesFromAlbum gets all the images from an album
+// GetImagesFromAlbum gets all the images from an album,
+// the ones meeting its rule if it's a smart album
 func (service Service) GetImagesFromAlbum(ctx context.Context, id int64) ([]*cameraroll.Image, error) {
 	// Image slice to hold the data from database query
 	images := []*cameraroll.Image{}
 
+	alb, err := findAlbum(ctx, service.db, id)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("GetImagesFromAlbum id[%d]: %v", id, err)
+	}
+
+	if alb != nil && alb.Rule != nil {
+		if images, err = service.getImagesOfSmartAlbum(ctx, alb, false); err != nil {
+			return nil, fmt.Errorf("GetImagesFromAlbum id[%d]: %v", id, err)
+		}
+
+		return images, nil
+	}
+
 	// find prepared statement
 	stmt := service.preparedStmts[keyQueryGetImagesFromAlbum]
 	if stmt == nil {
@@ -75,17 +101,7 @@ func (service Service) GetImagesFromAlbum(ctx context.Context, id int64) ([]*cam
 	// parse response
 	for rows.Next() {
 		img := cameraroll.Image{}
-		if err := rows.Scan(
-			&img.ID,
-			&img.Path,
-			&img.Width,
-			&img.Height,
-			&img.Thumbnail,
-			&img.ThumbnailWidth,
-			&img.ThumbnailHeight,
-			&img.Title,
-			&img.Description,
-			&img.CreatedAt); err != nil {
+		if err := scanImage(rows, &img); err != nil {
 			return nil, fmt.Errorf("GetImagesFromAlbum id[%d]: %v", id, err)
 		}
 
@@ -98,13 +114,38 @@ func (service Service) GetImagesFromAlbum(ctx context.Context, id int64) ([]*cam
 		return nil, fmt.Errorf("GetImagesFromAlbum id[%d]: %v", id, err)
 	}
 
+	// query database for image renditions, the ones carrying the album's watermark if it overrides the default one
+	if err := service.attachMarkedRenditions(ctx, watermarkOfAlbum(alb), images...); err != nil {
+		return nil, fmt.Errorf("GetImagesFromAlbum id[%d]: %v", id, err)
+	}
+
 	return images, nil
 }
 
-// GetCoverOfAlbum gets the album cover of an album
+// GetCoverOfAlbum gets the album cover of an album,
+// the pinned one if any, the most recently added image otherwise,
+// or the first of the images meeting its rule if it's a smart album
 func (service Service) GetCoverOfAlbum(ctx context.Context, id int64) (*cameraroll.Image, error) {
 	img := cameraroll.Image{}
 
+	alb, err := findAlbum(ctx, service.db, id)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("GetCoverOfAlbum[%d]: %v", id, err)
+	}
+
+	if alb != nil && alb.Rule != nil {
+		images, err := service.getImagesOfSmartAlbum(ctx, alb, true)
+		if err != nil {
+			return nil, fmt.Errorf("GetCoverOfAlbum[%d]: %v", id, err)
+		}
+
+		if len(images) == 0 {
+			return nil, fmt.Errorf("GetCoverOfAlbum[%d]: no such image", id)
+		}
+
+		return images[0], nil
+	}
+
 	// find prepared statement
 	stmt := service.preparedStmts[keyQueryGetCoverOfAlbum]
 	if stmt == nil {
@@ -124,17 +165,7 @@ func (service Service) GetCoverOfAlbum(ctx context.Context, id int64) (*cameraro
 	row := txStmt.QueryRowContext(ctx, id)
 
 	// parse response
-	if err := row.Scan(
-		&img.ID,
-		&img.Path,
-		&img.Width,
-		&img.Height,
-		&img.Thumbnail,
-		&img.ThumbnailWidth,
-		&img.ThumbnailHeight,
-		&img.Title,
-		&img.Description,
-		&img.CreatedAt); err != nil {
+	if err := scanImage(row, &img); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("GetCoverOfAlbum[%d]: no such image", id)
 		}
@@ -147,6 +178,11 @@ func (service Service) GetCoverOfAlbum(ctx context.Context, id int64) (*cameraro
 		return nil, fmt.Errorf("GetCoverOfAlbum[%d]: %v", id, err)
 	}
 
+	// query database for image renditions, the ones carrying the album's watermark if it overrides the default one
+	if err := service.attachMarkedRenditions(ctx, watermarkOfAlbum(alb), &img); err != nil {
+		return nil, fmt.Errorf("GetCoverOfAlbum[%d]: %v", id, err)
+	}
+
 	return &img, nil
 }
 
@@ -183,7 +219,7 @@ func (service Service) GetAlbumsOfImage(ctx context.Context, id int64) ([]*camer
 	// parse response
 	for rows.Next() {
 		alb := cameraroll.Album{}
-		if err := rows.Scan(&alb.ID, &alb.Title, &alb.Description, &alb.CreatedAt); err != nil {
+		if err := scanAlbum(rows, &alb); err != nil {
 			return nil, fmt.Errorf("GetAlbumsOfImage id[%d]: %v", id, err)
 		}
 
@@ -213,6 +249,16 @@ func (service Service) AddImageToAlbum(ctx context.Context, albumID int64, image
 	}
 	defer tx.Rollback()
 
+	// the images of smart albums are picked by their rule
+	smart, err := findSmartAlbum(ctx, tx, albumID)
+	if err != nil {
+		return fmt.Errorf("AddImageToAlbum imageID[%d] albumID[%d]: %v", imageID, albumID, err)
+	}
+
+	if smart != nil {
+		return fmt.Errorf("AddImageToAlbum imageID[%d] albumID[%d]: %v", imageID, albumID, errSmartAlbum)
+	}
+
 	// execute the query
 	result, err := tx.ExecContext(ctx,
 		`INSERT INTO image_albums(album_id, image_id) 
@@ -237,3 +283,156 @@ func (service Service) AddImageToAlbum(ctx context.Context, albumID int64, image
 
 	return nil
 }
+
+// GetWatermarksOfImage finds the watermarks that the albums of an image override the default one with,
+// each of which gets its own set of renditions
+func (service Service) GetWatermarksOfImage(ctx context.Context, id int64) ([]string, error) {
+	// execute the query
+	rows, err := service.db.QueryContext(ctx,
+		`SELECT DISTINCT albums.watermark 
+		FROM image_albums JOIN albums 
+		ON image_albums.album_id=albums.id 
+		WHERE image_albums.image_id=? AND albums.watermark<>'' 
+		ORDER BY albums.watermark`,
+		id)
+
+	// check if the query failed
+	if err != nil {
+		return nil, fmt.Errorf("GetWatermarksOfImage [%d]: %v", id, err)
+	}
+
+	defer rows.Close()
+
+	// parse response
+	watermarks := []string{}
+	for rows.Next() {
+		watermark := ""
+		if err := rows.Scan(&watermark); err != nil {
+			return nil, fmt.Errorf("GetWatermarksOfImage [%d]: %v", id, err)
+		}
+
+		watermarks = append(watermarks, watermark)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetWatermarksOfImage [%d]: %v", id, err)
+	}
+
+	return watermarks, nil
+}
+
+// watermarkOfAlbum returns the watermark an album overrides the default one with, empty if there's none
+func watermarkOfAlbum(alb *cameraroll.Album) string {
+	if alb == nil {
+		return ""
+	}
+
+	return alb.Watermark
+}
+
+// ReorderImagesOfAlbum puts the images of an album in the order of imageIDs and sorts the album manually.
+// Images left out of imageIDs follow in their current order.
+func (service Service) ReorderImagesOfAlbum(ctx context.Context, albumID int64, imageIDs []int64) error {
+	// start a transaction
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("ReorderImagesOfAlbum [%d]: %v", albumID, err)
+	}
+	defer tx.Rollback()
+
+	// the images of smart albums are picked by their rule
+	smart, err := findSmartAlbum(ctx, tx, albumID)
+	if err != nil {
+		return fmt.Errorf("ReorderImagesOfAlbum [%d]: %v", albumID, err)
+	}
+
+	if smart != nil {
+		return fmt.Errorf("ReorderImagesOfAlbum [%d]: %v", albumID, errSmartAlbum)
+	}
+
+	// find the images of the album in their current order
+	rows, err := tx.QueryContext(ctx,
+		`SELECT image_id 
+		FROM image_albums 
+		WHERE album_id=? 
+		ORDER BY position, id DESC 
+		FOR UPDATE`,
+		albumID)
+
+	if err != nil {
+		return fmt.Errorf("ReorderImagesOfAlbum [%d]: %v", albumID, err)
+	}
+
+	current := []int64{}
+	for rows.Next() {
+		var imageID int64
+		if err := rows.Scan(&imageID); err != nil {
+			rows.Close()
+			return fmt.Errorf("ReorderImagesOfAlbum [%d]: %v", albumID, err)
+		}
+
+		current = append(current, imageID)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("ReorderImagesOfAlbum [%d]: %v", albumID, err)
+	}
+
+	// the listed images go first
+	inAlbum := make(map[int64]bool, len(current))
+	for _, imageID := range current {
+		inAlbum[imageID] = true
+	}
+
+	order := make([]int64, 0, len(current))
+	listed := make(map[int64]bool, len(imageIDs))
+	for _, imageID := range imageIDs {
+		if !inAlbum[imageID] {
+			return fmt.Errorf("ReorderImagesOfAlbum [%d]: image [%d] is not in the album", albumID, imageID)
+		}
+
+		if listed[imageID] {
+			return fmt.Errorf("ReorderImagesOfAlbum [%d]: image [%d] is listed twice", albumID, imageID)
+		}
+
+		listed[imageID] = true
+		order = append(order, imageID)
+	}
+
+	for _, imageID := range current {
+		if !listed[imageID] {
+			order = append(order, imageID)
+		}
+	}
+
+	// execute the query
+	for i, imageID := range order {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE image_albums 
+			SET position=? 
+			WHERE album_id=? AND image_id=?`,
+			i+1,
+			albumID,
+			imageID); err != nil {
+			return fmt.Errorf("ReorderImagesOfAlbum [%d]: %v", albumID, err)
+		}
+	}
+
+	// the order only shows when sorting manually
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE albums 
+		SET sort_mode=? 
+		WHERE id=?`,
+		cameraroll.SortManual,
+		albumID); err != nil {
+		return fmt.Errorf("ReorderImagesOfAlbum [%d]: %v", albumID, err)
+	}
+
+	// commit the transaction
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("ReorderImagesOfAlbum [%d]: %v", albumID, err)
+	}
+
+	return nil
+}
@@ -3,13 +3,70 @@ package mysql
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 
 	"chujungeng/camera-roll/pkg/cameraroll"
 )
 
-// DeleteAlbumByID removes an album from database
-func (service Service) DeleteAlbumByID(ctx context.Context, id int64) error {
+// scanAlbum reads a row of albumColumns into alb
+func scanAlbum(row rowScanner, alb *cameraroll.Album) error {
+	var coverID, parentID sql.NullInt64
+	var rule []byte
+
+	if err := row.Scan(&alb.ID, &alb.Title, &alb.Description, &alb.CreatedAt, &alb.Watermark, &alb.SortMode, &coverID, &parentID, &rule); err != nil {
+		return err
+	}
+
+	alb.CoverID = coverID.Int64
+	alb.ParentID = parentID.Int64
+
+	alb.Rule = nil
+	if rule != nil {
+		alb.Rule = &cameraroll.SmartRule{}
+		if err := json.Unmarshal(rule, alb.Rule); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ruleValue is how the rule of a smart album is stored, NULL for albums curated by hand
+func ruleValue(rule *cameraroll.SmartRule) (interface{}, error) {
+	if rule == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+// findAlbum queries the database for an album without its cover
+func findAlbum(ctx context.Context, q queryRower, id int64) (*cameraroll.Album, error) {
+	alb := cameraroll.Album{}
+
+	row := q.QueryRowContext(ctx,
+		`SELECT `+albumColumns+` 
+		FROM albums 
+		WHERE id=?`,
+		id)
+
+	if err := scanAlbum(row, &alb); err != nil {
+		return nil, err
+	}
+
+	return &alb, nil
+}
+
+// DeleteAlbumByID removes an album from database.
+// Its children are deleted along with it if cascade is set,
+// otherwise they move up to the album's parent.
+func (service Service) DeleteAlbumByID(ctx context.Context, id int64, cascade bool) error {
 	// start a transaction
 	tx, err := service.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -17,6 +74,30 @@ func (service Service) DeleteAlbumByID(ctx context.Context, id int64) error {
 	}
 	defer tx.Rollback()
 
+	// children are deleted by the foreign key unless they move up first
+	if !cascade {
+		var parentID sql.NullInt64
+		row := tx.QueryRowContext(ctx,
+			`SELECT parent_id 
+			FROM albums 
+			WHERE id=? 
+			FOR UPDATE`,
+			id)
+
+		if err := row.Scan(&parentID); err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("DeleteAlbumByID [%d]: %v", id, err)
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE albums 
+			SET parent_id=? 
+			WHERE parent_id=?`,
+			parentID,
+			id); err != nil {
+			return fmt.Errorf("DeleteAlbumByID [%d]: %v", id, err)
+		}
+	}
+
 	// execute the query
 	result, err := tx.ExecContext(ctx,
 		`DELETE FROM albums 
@@ -41,12 +122,20 @@ func (service Service) DeleteAlbumByID(ctx context.Context, id int64) error {
 	return nil
 }
 
-// UpdateAlbumByID updates an album's title and description
+// UpdateAlbumByID updates an album's title, description, watermark, sort mode, parent and rule,
+// refusing to nest an album inside itself or any of its descendants
 func (service Service) UpdateAlbumByID(ctx context.Context, id int64, newAlb *cameraroll.Album) error {
 	if newAlb == nil {
 		return fmt.Errorf("UpdateAlbumByID [%d]: null pointer error", id)
 	}
 
+	parentID := sql.NullInt64{Int64: newAlb.ParentID, Valid: newAlb.ParentID > 0}
+
+	rule, err := ruleValue(newAlb.Rule)
+	if err != nil {
+		return fmt.Errorf("UpdateAlbumByID [%d]: %v", id, err)
+	}
+
 	// start a transaction
 	tx, err := service.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -54,13 +143,41 @@ func (service Service) UpdateAlbumByID(ctx context.Context, id int64, newAlb *ca
 	}
 	defer tx.Rollback()
 
+	// the album mustn't be among the ancestors of its new parent
+	if parentID.Valid {
+		var cycles int
+		row := tx.QueryRowContext(ctx,
+			`WITH RECURSIVE ancestors (id, parent_id) AS (
+				SELECT id, parent_id FROM albums WHERE id=? 
+				UNION ALL 
+				SELECT albums.id, albums.parent_id 
+				FROM albums JOIN ancestors 
+				ON albums.id=ancestors.parent_id
+			)
+			SELECT COUNT(*) FROM ancestors WHERE id=?`,
+			parentID,
+			id)
+
+		if err := row.Scan(&cycles); err != nil {
+			return fmt.Errorf("UpdateAlbumByID [%d]: %v", id, err)
+		}
+
+		if cycles > 0 {
+			return fmt.Errorf("UpdateAlbumByID [%d]: album [%d] is nested in this album", id, newAlb.ParentID)
+		}
+	}
+
 	// execute the query
 	result, err := tx.ExecContext(ctx,
 		`UPDATE albums 
-		SET title=?, description=? 
+		SET title=?, description=?, watermark=?, sort_mode=?, parent_id=?, smart_rule=? 
 		WHERE id=?`,
 		newAlb.Title,
 		newAlb.Description,
+		newAlb.Watermark,
+		newAlb.SortMode,
+		parentID,
+		rule,
 		id)
 
 	// check if the query failed
@@ -81,6 +198,72 @@ func (service Service) UpdateAlbumByID(ctx context.Context, id int64, newAlb *ca
 	return nil
 }
 
+// UpdateCoverOfAlbum pins one of the images of an album as its cover,
+// or unpins the cover if imageID is 0
+func (service Service) UpdateCoverOfAlbum(ctx context.Context, id int64, imageID int64) error {
+	coverID := sql.NullInt64{Int64: imageID, Valid: imageID > 0}
+
+	// start a transaction
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("UpdateCoverOfAlbum [%d]: %v", id, err)
+	}
+	defer tx.Rollback()
+
+	// only images in the album can be its cover
+	if coverID.Valid {
+		alb, err := findAlbum(ctx, tx, id)
+		if err != nil {
+			return fmt.Errorf("UpdateCoverOfAlbum [%d]: %v", id, err)
+		}
+
+		query := `SELECT COUNT(*) 
+			FROM image_albums 
+			WHERE album_id=? AND image_id=?`
+		args := []interface{}{id, imageID}
+
+		// the images of a smart album are the ones meeting its rule
+		if alb.Rule != nil {
+			conditions, ruleArgs := ruleConditions(alb.Rule)
+			query = `SELECT COUNT(*) 
+				FROM images 
+				WHERE images.id=? AND ` + conditions
+			args = append([]interface{}{imageID}, ruleArgs...)
+		}
+
+		var count int
+		row := tx.QueryRowContext(ctx, query, args...)
+
+		if err := row.Scan(&count); err != nil {
+			return fmt.Errorf("UpdateCoverOfAlbum [%d]: %v", id, err)
+		}
+
+		if count == 0 {
+			return fmt.Errorf("UpdateCoverOfAlbum [%d]: image [%d] is not in the album", id, imageID)
+		}
+	}
+
+	// execute the query
+	_, err = tx.ExecContext(ctx,
+		`UPDATE albums 
+		SET cover_id=? 
+		WHERE id=?`,
+		coverID,
+		id)
+
+	// check if the query failed
+	if err != nil {
+		return fmt.Errorf("UpdateCoverOfAlbum [%d]: %v", id, err)
+	}
+
+	// commit the transaction
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("UpdateCoverOfAlbum [%d]: %v", id, err)
+	}
+
+	return nil
+}
+
 // GetAlbumByID queries the database for the album specified by its ID
 func (service Service) GetAlbumByID(ctx context.Context, id int64) (*cameraroll.Album, error) {
 	alb := cameraroll.Album{}
@@ -104,7 +287,7 @@ func (service Service) GetAlbumByID(ctx context.Context, id int64) (*cameraroll.
 	row := txStmt.QueryRowContext(ctx, id)
 
 	// parse response
-	if err := row.Scan(&alb.ID, &alb.Title, &alb.Description, &alb.CreatedAt); err != nil {
+	if err := scanAlbum(row, &alb); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("GetAlbumByID[%d]: no such album", id)
 		}
@@ -156,7 +339,7 @@ func (service Service) GetAlbums(ctx context.Context, start uint64, count uint64
 	// parse response
 	for rows.Next() {
 		alb := cameraroll.Album{}
-		if err := rows.Scan(&alb.ID, &alb.Title, &alb.Description, &alb.CreatedAt); err != nil {
+		if err := scanAlbum(rows, &alb); err != nil {
 			return nil, fmt.Errorf("GetAlbums start[%d] count[%d]: %v", start, count, err)
 		}
 
@@ -184,6 +367,11 @@ func (service Service) AddAlbum(ctx context.Context, album *cameraroll.Album) er
 		return fmt.Errorf("AddAlbum : null pointer error")
 	}
 
+	rule, err := ruleValue(album.Rule)
+	if err != nil {
+		return fmt.Errorf("AddAlbum [%s]: %v", album.Title, err)
+	}
+
 	// start a transaction
 	tx, err := service.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -195,10 +383,14 @@ func (service Service) AddAlbum(ctx context.Context, album *cameraroll.Album) er
 	var result sql.Result
 
 	result, err = tx.ExecContext(ctx,
-		`INSERT INTO albums (title, description)
-		VALUES (?, ?)`,
+		`INSERT INTO albums (title, description, watermark, sort_mode, parent_id, smart_rule)
+		VALUES (?, ?, ?, ?, ?, ?)`,
 		album.Title,
-		album.Description)
+		album.Description,
+		album.Watermark,
+		album.SortMode,
+		sql.NullInt64{Int64: album.ParentID, Valid: album.ParentID > 0},
+		rule)
 
 	// check if the query failed
 	if err != nil {
@@ -219,3 +411,80 @@ func (service Service) AddAlbum(ctx context.Context, album *cameraroll.Album) er
 
 	return nil
 }
+
+// GetChildrenOfAlbum queries the database for the albums nested directly in an album
+func (service Service) GetChildrenOfAlbum(ctx context.Context, id int64) ([]*cameraroll.Album, error) {
+	// execute the query
+	rows, err := service.db.QueryContext(ctx,
+		`SELECT `+albumColumns+` 
+		FROM albums 
+		WHERE parent_id=? 
+		ORDER BY created_at DESC`,
+		id)
+
+	// check if the query failed
+	if err != nil {
+		return nil, fmt.Errorf("GetChildrenOfAlbum [%d]: %v", id, err)
+	}
+
+	albums, err := scanAlbums(rows)
+	if err != nil {
+		return nil, fmt.Errorf("GetChildrenOfAlbum [%d]: %v", id, err)
+	}
+
+	// query database for album covers
+	for _, alb := range albums {
+		alb.Cover, _ = service.GetCoverOfAlbum(ctx, alb.ID)
+	}
+
+	return albums, nil
+}
+
+// GetPathOfAlbum queries the database for the albums an album is nested in,
+// from the top level down to the album itself
+func (service Service) GetPathOfAlbum(ctx context.Context, id int64) ([]*cameraroll.Album, error) {
+	// execute the query
+	rows, err := service.db.QueryContext(ctx,
+		`WITH RECURSIVE path (id, depth) AS (
+			SELECT id, 0 FROM albums WHERE id=? 
+			UNION ALL 
+			SELECT albums.parent_id, path.depth+1 
+			FROM albums JOIN path 
+			ON albums.id=path.id 
+			WHERE albums.parent_id IS NOT NULL
+		)
+		SELECT `+albumColumns+` 
+		FROM path JOIN albums 
+		ON albums.id=path.id 
+		ORDER BY path.depth DESC`,
+		id)
+
+	// check if the query failed
+	if err != nil {
+		return nil, fmt.Errorf("GetPathOfAlbum [%d]: %v", id, err)
+	}
+
+	albums, err := scanAlbums(rows)
+	if err != nil {
+		return nil, fmt.Errorf("GetPathOfAlbum [%d]: %v", id, err)
+	}
+
+	return albums, nil
+}
+
+// scanAlbums reads every row of albumColumns, closing rows
+func scanAlbums(rows *sql.Rows) ([]*cameraroll.Album, error) {
+	defer rows.Close()
+
+	albums := []*cameraroll.Album{}
+	for rows.Next() {
+		alb := cameraroll.Album{}
+		if err := scanAlbum(rows, &alb); err != nil {
+			return nil, err
+		}
+
+		albums = append(albums, &alb)
+	}
+
+	return albums, rows.Err()
+}
@@ -41,9 +41,10 @@ func (service Service) RemoveTagFromAlbum(ctx context.Context, albumID int64, ta
 	return nil
 }
 
-// GetAlbumsWithTag queries the database for certain amount of albums under a tag specified by tagID
+// GetAlbumsWithTag queries the database for certain amount of albums under a tag specified by tagID,
+// and under the tags nested in it at any depth if includeDescendants is set.
 // returns a slice of albums on success
-func (service Service) GetAlbumsWithTag(ctx context.Context, tagID int64, start uint64, count uint64) ([]*cameraroll.Album, error) {
+func (service Service) GetAlbumsWithTag(ctx context.Context, tagID int64, includeDescendants bool, start uint64, count uint64) ([]*cameraroll.Album, error) {
 	// Album slice to hold the data from database query
 	albums := []*cameraroll.Album{}
 
@@ -63,7 +64,7 @@ func (service Service) GetAlbumsWithTag(ctx context.Context, tagID int64, start
 	txStmt := tx.StmtContext(ctx, stmt)
 
 	// execute the query
-	rows, err := txStmt.QueryContext(ctx, tagID, start, count)
+	rows, err := txStmt.QueryContext(ctx, tagID, includeDescendants, start, count)
 
 	// check if the query failed
 	if err != nil {
@@ -75,7 +76,7 @@ func (service Service) GetAlbumsWithTag(ctx context.Context, tagID int64, start
 	// parse response
 	for rows.Next() {
 		alb := cameraroll.Album{}
-		if err := rows.Scan(&alb.ID, &alb.Title, &alb.Description, &alb.CreatedAt); err != nil {
+		if err := scanAlbum(rows, &alb); err != nil {
 			return nil, fmt.Errorf("GetAlbumsWithTag[%d] start[%d] count[%d]: %v", tagID, start, count, err)
 		}
 
@@ -129,7 +130,7 @@ func (service Service) GetTagsOfAlbum(ctx context.Context, albumID int64) ([]*ca
 	// parse response
 	for rows.Next() {
 		tag := cameraroll.Tag{}
-		if err := rows.Scan(&tag.ID, &tag.Name); err != nil {
+		if err := scanTag(rows, &tag); err != nil {
 			return nil, fmt.Errorf("GetTagsOfAlbum[%d]: %v", albumID, err)
 		}
 
@@ -0,0 +1,131 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+)
+
+// GetEditsOfImage queries the database for the edits of an image,
+// returning nil if it has none
+func (service Service) GetEditsOfImage(ctx context.Context, imageID int64) (*cameraroll.Edits, error) {
+	edits := cameraroll.Edits{}
+	var cropX, cropY, cropWidth, cropHeight sql.NullFloat64
+
+	// execute the query
+	row := service.db.QueryRowContext(ctx,
+		`SELECT image_id, rotation, flip_horizontal, flip_vertical, straighten, crop_x, crop_y, crop_width, crop_height, updated_at 
+		FROM image_edits 
+		WHERE image_id=?`,
+		imageID)
+
+	// parse response
+	err := row.Scan(
+		&edits.ImageID,
+		&edits.Rotation,
+		&edits.FlipHorizontal,
+		&edits.FlipVertical,
+		&edits.Straighten,
+		&cropX,
+		&cropY,
+		&cropWidth,
+		&cropHeight,
+		&edits.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("GetEditsOfImage [%d]: %v", imageID, err)
+	}
+
+	if cropX.Valid && cropY.Valid && cropWidth.Valid && cropHeight.Valid {
+		edits.Crop = &cameraroll.Crop{
+			X:      cropX.Float64,
+			Y:      cropY.Float64,
+			Width:  cropWidth.Float64,
+			Height: cropHeight.Float64,
+		}
+	}
+
+	return &edits, nil
+}
+
+// UpdateEditsOfImage saves the edits of an image, replacing the previous ones
+func (service Service) UpdateEditsOfImage(ctx context.Context, imageID int64, edits *cameraroll.Edits) error {
+	if edits == nil {
+		return fmt.Errorf("UpdateEditsOfImage [%d]: null pointer error", imageID)
+	}
+
+	var cropX, cropY, cropWidth, cropHeight sql.NullFloat64
+	if edits.Crop != nil {
+		cropX = sql.NullFloat64{Float64: edits.Crop.X, Valid: true}
+		cropY = sql.NullFloat64{Float64: edits.Crop.Y, Valid: true}
+		cropWidth = sql.NullFloat64{Float64: edits.Crop.Width, Valid: true}
+		cropHeight = sql.NullFloat64{Float64: edits.Crop.Height, Valid: true}
+	}
+
+	// start a transaction
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("UpdateEditsOfImage [%d]: %v", imageID, err)
+	}
+	defer tx.Rollback()
+
+	// execute the query
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO image_edits (image_id, rotation, flip_horizontal, flip_vertical, straighten, crop_x, crop_y, crop_width, crop_height) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) 
+		ON DUPLICATE KEY UPDATE 
+			rotation=VALUES(rotation), flip_horizontal=VALUES(flip_horizontal), flip_vertical=VALUES(flip_vertical), straighten=VALUES(straighten), 
+			crop_x=VALUES(crop_x), crop_y=VALUES(crop_y), crop_width=VALUES(crop_width), crop_height=VALUES(crop_height)`,
+		imageID,
+		edits.Rotation,
+		edits.FlipHorizontal,
+		edits.FlipVertical,
+		edits.Straighten,
+		cropX,
+		cropY,
+		cropWidth,
+		cropHeight)
+
+	// check if the query failed
+	if err != nil {
+		return fmt.Errorf("UpdateEditsOfImage [%d]: %v", imageID, err)
+	}
+
+	// commit the transaction
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("UpdateEditsOfImage [%d]: %v", imageID, err)
+	}
+
+	edits.ImageID = imageID
+
+	return nil
+}
+
+// DeleteEditsOfImage resets an image to its original
+func (service Service) DeleteEditsOfImage(ctx context.Context, imageID int64) error {
+	// start a transaction
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("DeleteEditsOfImage [%d]: %v", imageID, err)
+	}
+	defer tx.Rollback()
+
+	// execute the query
+	_, err = tx.ExecContext(ctx, `DELETE FROM image_edits WHERE image_id=?`, imageID)
+
+	// check if the query failed
+	if err != nil {
+		return fmt.Errorf("DeleteEditsOfImage [%d]: %v", imageID, err)
+	}
+
+	// commit the transaction
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("DeleteEditsOfImage [%d]: %v", imageID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package mysql
+
+import (
+	"context"
+	"testing"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+)
+
+func TestEditsOfImage(t *testing.T) {
+	service := testService(t)
+	ctx := context.Background()
+
+	img := cameraroll.Image{Path: "http://localhost:9648/public/a.jpg", ProcessingStatus: cameraroll.ProcessingPending}
+	if err := service.AddImage(ctx, &img); err != nil {
+		t.Fatal(err)
+	}
+
+	if edits, err := service.GetEditsOfImage(ctx, img.ID); edits != nil || err != nil {
+		t.Fatalf("got %+v, %v for an image without edits", edits, err)
+	}
+
+	edits := cameraroll.Edits{
+		Rotation:       90,
+		FlipHorizontal: true,
+		Straighten:     -2.5,
+		Crop:           &cameraroll.Crop{X: 0.1, Y: 0.2, Width: 0.5, Height: 0.25},
+	}
+	if err := service.UpdateEditsOfImage(ctx, img.ID, &edits); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := service.GetEditsOfImage(ctx, img.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if saved == nil || saved.ImageID != img.ID || saved.Rotation != 90 || !saved.FlipHorizontal || saved.FlipVertical ||
+		saved.Straighten != -2.5 || saved.Crop == nil || *saved.Crop != *edits.Crop || saved.UpdatedAt.IsZero() {
+		t.Fatalf("got %+v, want %+v", saved, edits)
+	}
+
+	// saving again replaces the edits, dropping the crop
+	edits = cameraroll.Edits{FlipVertical: true}
+	if err := service.UpdateEditsOfImage(ctx, img.ID, &edits); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err = service.GetEditsOfImage(ctx, img.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if saved == nil || saved.Rotation != 0 || saved.FlipHorizontal || !saved.FlipVertical || saved.Crop != nil {
+		t.Fatalf("got %+v, want %+v", saved, edits)
+	}
+
+	if err := service.DeleteEditsOfImage(ctx, img.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if edits, err := service.GetEditsOfImage(ctx, img.ID); edits != nil || err != nil {
+		t.Fatalf("got %+v, %v after deleting the edits", edits, err)
+	}
+}
@@ -8,6 +8,64 @@ import (
 	"chujungeng/camera-roll/pkg/cameraroll"
 )
 
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// queryRower is implemented by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// scanImage reads a row of imageColumns into img,
+// followed by any extra columns of the query
+func scanImage(row rowScanner, img *cameraroll.Image, extra ...interface{}) error {
+	var duplicateOf sql.NullInt64
+
+	dest := []interface{}{
+		&img.ID,
+		&img.Path,
+		&img.MimeType,
+		&img.Width,
+		&img.Height,
+		&img.Thumbnail,
+		&img.ThumbnailWidth,
+		&img.ThumbnailHeight,
+		&img.Title,
+		&img.Description,
+		&img.CreatedAt,
+		&img.CameraMake,
+		&img.CameraModel,
+		&img.Lens,
+		&img.FocalLength,
+		&img.Aperture,
+		&img.ShutterSpeed,
+		&img.ExposureTime,
+		&img.ISO,
+		&img.DateTaken,
+		&img.Latitude,
+		&img.Longitude,
+		&img.BlurHash,
+		&img.LQIP,
+		&img.Color,
+		&img.PerceptualHash,
+		&img.ProcessingStatus,
+		&img.FocalX,
+		&img.FocalY,
+		&img.ColorSpace,
+		&duplicateOf,
+	}
+
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+
+	img.DuplicateOf = duplicateOf.Int64
+
+	return nil
+}
+
 // DeleteImageByID removes an image from database
 func (service Service) DeleteImageByID(ctx context.Context, id int64) error {
 	// start a transaction
@@ -57,9 +115,10 @@ func (service Service) UpdateImageByID(ctx context.Context, id int64, newImg *ca
 	// execute the query
 	result, err := tx.ExecContext(ctx,
 		`UPDATE images 
-		SET path=?, width=?, height=?, thumbnail=?, width_thumb=?, height_thumb=?, title=?, description=? 
+		SET path=?, mime_type=?, width=?, height=?, thumbnail=?, width_thumb=?, height_thumb=?, title=?, description=?, focal_x=?, focal_y=? 
 		WHERE id=?`,
 		newImg.Path,
+		newImg.MimeType,
 		newImg.Width,
 		newImg.Height,
 		newImg.Thumbnail,
@@ -67,6 +126,8 @@ func (service Service) UpdateImageByID(ctx context.Context, id int64, newImg *ca
 		newImg.ThumbnailHeight,
 		newImg.Title,
 		newImg.Description,
+		newImg.FocalX,
+		newImg.FocalY,
 		id)
 
 	// check if the query failed
@@ -87,6 +148,138 @@ func (service Service) UpdateImageByID(ctx context.Context, id int64, newImg *ca
 	return nil
 }
 
+// UpdatePlaceholderOfImage updates an image's BlurHash, LQIP and average color
+func (service Service) UpdatePlaceholderOfImage(ctx context.Context, id int64, placeholder cameraroll.Placeholder) error {
+	// start a transaction
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("UpdatePlaceholderOfImage [%d]: %v", id, err)
+	}
+	defer tx.Rollback()
+
+	// execute the query
+	_, err = tx.ExecContext(ctx,
+		`UPDATE images 
+		SET blurhash=?, lqip=?, color=? 
+		WHERE id=?`,
+		placeholder.BlurHash,
+		placeholder.LQIP,
+		placeholder.Color,
+		id)
+
+	// check if the query failed
+	if err != nil {
+		return fmt.Errorf("UpdatePlaceholderOfImage [%d]: %v", id, err)
+	}
+
+	// commit the transaction
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("UpdatePlaceholderOfImage [%d]: %v", id, err)
+	}
+
+	return nil
+}
+
+// UpdateDerivativesOfImage updates everything derived from an image's original:
+// its dimensions, thumbnail, renditions, EXIF data, placeholder, perceptual hash
+// and processing status
+func (service Service) UpdateDerivativesOfImage(ctx context.Context, id int64, img *cameraroll.Image) error {
+	if img == nil {
+		return fmt.Errorf("UpdateDerivativesOfImage [%d]: null pointer error", id)
+	}
+
+	// start a transaction
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("UpdateDerivativesOfImage [%d]: %v", id, err)
+	}
+	defer tx.Rollback()
+
+	// execute the query
+	_, err = tx.ExecContext(ctx,
+		`UPDATE images 
+		SET mime_type=?, width=?, height=?, thumbnail=?, width_thumb=?, height_thumb=?,
+			camera_make=?, camera_model=?, lens=?, focal_length=?, aperture=?, shutter_speed=?, exposure_time=?, iso=?, date_taken=?, latitude=?, longitude=?,
+			blurhash=?, lqip=?, color=?, phash=?, processing_status=?, color_space=?, duplicate_of=? 
+		WHERE id=?`,
+		img.MimeType,
+		img.Width,
+		img.Height,
+		img.Thumbnail,
+		img.ThumbnailWidth,
+		img.ThumbnailHeight,
+		img.CameraMake,
+		img.CameraModel,
+		img.Lens,
+		img.FocalLength,
+		img.Aperture,
+		img.ShutterSpeed,
+		img.ExposureTime,
+		img.ISO,
+		img.DateTaken,
+		img.Latitude,
+		img.Longitude,
+		img.BlurHash,
+		img.LQIP,
+		img.Color,
+		img.PerceptualHash,
+		img.ProcessingStatus,
+		img.ColorSpace,
+		sql.NullInt64{Int64: img.DuplicateOf, Valid: img.DuplicateOf > 0},
+		id)
+
+	// check if the query failed
+	if err != nil {
+		return fmt.Errorf("UpdateDerivativesOfImage [%d]: %v", id, err)
+	}
+
+	// replace the image's renditions
+	if _, err := tx.ExecContext(ctx, `DELETE FROM image_renditions WHERE image_id=?`, id); err != nil {
+		return fmt.Errorf("UpdateDerivativesOfImage [%d]: %v", id, err)
+	}
+
+	if err := addRenditions(ctx, tx, id, img.Renditions); err != nil {
+		return fmt.Errorf("UpdateDerivativesOfImage [%d]: %v", id, err)
+	}
+
+	// commit the transaction
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("UpdateDerivativesOfImage [%d]: %v", id, err)
+	}
+
+	return nil
+}
+
+// UpdateProcessingStatusOfImage updates where an image is in the job queue
+func (service Service) UpdateProcessingStatusOfImage(ctx context.Context, id int64, status string) error {
+	// start a transaction
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("UpdateProcessingStatusOfImage [%d]: %v", id, err)
+	}
+	defer tx.Rollback()
+
+	// execute the query
+	_, err = tx.ExecContext(ctx,
+		`UPDATE images 
+		SET processing_status=? 
+		WHERE id=?`,
+		status,
+		id)
+
+	// check if the query failed
+	if err != nil {
+		return fmt.Errorf("UpdateProcessingStatusOfImage [%d]: %v", id, err)
+	}
+
+	// commit the transaction
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("UpdateProcessingStatusOfImage [%d]: %v", id, err)
+	}
+
+	return nil
+}
+
 // GetImageByID queries the database for the image specified by its ID
 func (service Service) GetImageByID(ctx context.Context, id int64) (*cameraroll.Image, error) {
 	img := cameraroll.Image{}
@@ -110,17 +303,7 @@ func (service Service) GetImageByID(ctx context.Context, id int64) (*cameraroll.
 	row := txStmt.QueryRowContext(ctx, id)
 
 	// parse response
-	if err := row.Scan(
-		&img.ID,
-		&img.Path,
-		&img.Width,
-		&img.Height,
-		&img.Thumbnail,
-		&img.ThumbnailWidth,
-		&img.ThumbnailHeight,
-		&img.Title,
-		&img.Description,
-		&img.CreatedAt); err != nil {
+	if err := scanImage(row, &img); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("GetImageByID[%d]: no such image", id)
 		}
@@ -133,6 +316,11 @@ func (service Service) GetImageByID(ctx context.Context, id int64) (*cameraroll.
 		return nil, fmt.Errorf("GetImageByID[%d]: %v", id, err)
 	}
 
+	// query database for image renditions
+	if err := service.attachRenditions(ctx, &img); err != nil {
+		return nil, fmt.Errorf("GetImageByID[%d]: %v", id, err)
+	}
+
 	return &img, nil
 }
 
@@ -169,17 +357,7 @@ func (service Service) GetImages(ctx context.Context, start uint64, count uint64
 	// parse response
 	for rows.Next() {
 		img := cameraroll.Image{}
-		if err := rows.Scan(
-			&img.ID,
-			&img.Path,
-			&img.Width,
-			&img.Height,
-			&img.Thumbnail,
-			&img.ThumbnailWidth,
-			&img.ThumbnailHeight,
-			&img.Title,
-			&img.Description,
-			&img.CreatedAt); err != nil {
+		if err := scanImage(rows, &img); err != nil {
 			return nil, fmt.Errorf("GetImages start[%d] count[%d]: %v", start, count, err)
 		}
 
@@ -192,9 +370,70 @@ func (service Service) GetImages(ctx context.Context, start uint64, count uint64
 		return nil, fmt.Errorf("GetImages start[%d] count[%d]: %v", start, count, err)
 	}
 
+	// query database for image renditions
+	if err := service.attachRenditions(ctx, images...); err != nil {
+		return nil, fmt.Errorf("GetImages start[%d] count[%d]: %v", start, count, err)
+	}
+
 	return images, nil
 }
 
+// GetDuplicatesOfImage queries the database for the images whose perceptual hashes
+// are within maxDistance bits of hash, excluding the image with id.
+// The closest ones come first.
+func (service Service) GetDuplicatesOfImage(ctx context.Context, id int64, hash uint64, maxDistance int) ([]*cameraroll.Duplicate, error) {
+	duplicates := []*cameraroll.Duplicate{}
+
+	// find prepared statement
+	stmt := service.preparedStmts[keyQueryGetSimilarImages]
+	if stmt == nil {
+		return nil, fmt.Errorf("GetDuplicatesOfImage [%d]: Cannot find prepared sql query", id)
+	}
+
+	// start a transaction
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("GetDuplicatesOfImage [%d]: %v", id, err)
+	}
+	defer tx.Rollback()
+
+	txStmt := tx.StmtContext(ctx, stmt)
+
+	// execute the query
+	rows, err := txStmt.QueryContext(ctx, hash, id, maxDistance)
+
+	// check if the query failed
+	if err != nil {
+		return nil, fmt.Errorf("GetDuplicatesOfImage [%d]: %v", id, err)
+	}
+
+	defer rows.Close()
+
+	// parse response
+	images := []*cameraroll.Image{}
+	for rows.Next() {
+		duplicate := cameraroll.Duplicate{Image: &cameraroll.Image{}}
+		if err := scanImage(rows, duplicate.Image, &duplicate.Distance); err != nil {
+			return nil, fmt.Errorf("GetDuplicatesOfImage [%d]: %v", id, err)
+		}
+
+		duplicates = append(duplicates, &duplicate)
+		images = append(images, duplicate.Image)
+	}
+
+	// commit the transaction
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("GetDuplicatesOfImage [%d]: %v", id, err)
+	}
+
+	// query database for image renditions
+	if err := service.attachRenditions(ctx, images...); err != nil {
+		return nil, fmt.Errorf("GetDuplicatesOfImage [%d]: %v", id, err)
+	}
+
+	return duplicates, nil
+}
+
 // AddImage adds 1 image to the database,
 // updating the image's ID upon success
 func (service Service) AddImage(ctx context.Context, image *cameraroll.Image) error {
@@ -211,16 +450,38 @@ func (service Service) AddImage(ctx context.Context, image *cameraroll.Image) er
 
 	// execute the query
 	result, err := tx.ExecContext(ctx,
-		`INSERT INTO images (path, width, height, thumbnail, width_thumb, height_thumb, title, description) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		`INSERT INTO images (path, mime_type, width, height, thumbnail, width_thumb, height_thumb, title, description,
+			camera_make, camera_model, lens, focal_length, aperture, shutter_speed, exposure_time, iso, date_taken, latitude, longitude,
+			blurhash, lqip, color, phash, processing_status, focal_x, focal_y, color_space) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		image.Path,
+		image.MimeType,
 		image.Width,
 		image.Height,
 		image.Thumbnail,
 		image.ThumbnailWidth,
 		image.ThumbnailHeight,
 		image.Title,
-		image.Description)
+		image.Description,
+		image.CameraMake,
+		image.CameraModel,
+		image.Lens,
+		image.FocalLength,
+		image.Aperture,
+		image.ShutterSpeed,
+		image.ExposureTime,
+		image.ISO,
+		image.DateTaken,
+		image.Latitude,
+		image.Longitude,
+		image.BlurHash,
+		image.LQIP,
+		image.Color,
+		image.PerceptualHash,
+		image.ProcessingStatus,
+		image.FocalX,
+		image.FocalY,
+		image.ColorSpace)
 
 	// check if the query failed
 	if err != nil {
@@ -232,6 +493,11 @@ func (service Service) AddImage(ctx context.Context, image *cameraroll.Image) er
 		return fmt.Errorf("AddImage [%s]: %v", image.Path, err)
 	}
 
+	// add the image's renditions
+	if err := addRenditions(ctx, tx, id, image.Renditions); err != nil {
+		return fmt.Errorf("AddImage [%s]: %v", image.Path, err)
+	}
+
 	// commit the transaction
 	if err = tx.Commit(); err != nil {
 		return fmt.Errorf("AddImage [%s]: %v", image.Path, err)
@@ -74,7 +74,7 @@ func (service Service) GetTagsOfImage(ctx context.Context, imageID int64) ([]*ca
 	// parse response
 	for rows.Next() {
 		tag := cameraroll.Tag{}
-		if err := rows.Scan(&tag.ID, &tag.Name); err != nil {
+		if err := scanTag(rows, &tag); err != nil {
 			return nil, fmt.Errorf("GetTagsOfImage[%d]: %v", imageID, err)
 		}
 
@@ -90,9 +90,10 @@ func (service Service) GetTagsOfImage(ctx context.Context, imageID int64) ([]*ca
 	return tags, nil
 }
 
-// GetImagesWithTag queries the database for certain amount of images under a tag specified by tagID
+// GetImagesWithTag queries the database for certain amount of images under a tag specified by tagID,
+// and under the tags nested in it at any depth if includeDescendants is set.
 // returns a slice of images on success
-func (service Service) GetImagesWithTag(ctx context.Context, tagID int64, start uint64, count uint64) ([]*cameraroll.Image, error) {
+func (service Service) GetImagesWithTag(ctx context.Context, tagID int64, includeDescendants bool, start uint64, count uint64) ([]*cameraroll.Image, error) {
 	// Image slice to hold the data from database query
 	images := []*cameraroll.Image{}
 
@@ -112,7 +113,7 @@ func (service Service) GetImagesWithTag(ctx context.Context, tagID int64, start
 	txStmt := tx.StmtContext(ctx, stmt)
 
 	// execute the query
-	rows, err := txStmt.QueryContext(ctx, tagID, start, count)
+	rows, err := txStmt.QueryContext(ctx, tagID, includeDescendants, start, count)
 
 	// check if the query failed
 	if err != nil {
@@ -124,17 +125,7 @@ func (service Service) GetImagesWithTag(ctx context.Context, tagID int64, start
 	// parse response
 	for rows.Next() {
 		img := cameraroll.Image{}
-		if err := rows.Scan(
-			&img.ID,
-			&img.Path,
-			&img.Width,
-			&img.Height,
-			&img.Thumbnail,
-			&img.ThumbnailWidth,
-			&img.ThumbnailHeight,
-			&img.Title,
-			&img.Description,
-			&img.CreatedAt); err != nil {
+		if err := scanImage(rows, &img); err != nil {
 			return nil, fmt.Errorf("GetImagesWithTag[%d] start[%d] count[%d]: %v", tagID, start, count, err)
 		}
 
@@ -147,6 +138,11 @@ func (service Service) GetImagesWithTag(ctx context.Context, tagID int64, start
 		return nil, fmt.Errorf("GetImagesWithTag[%d] start[%d] count[%d]: %v", tagID, start, count, err)
 	}
 
+	// query database for image renditions
+	if err := service.attachRenditions(ctx, images...); err != nil {
+		return nil, fmt.Errorf("GetImagesWithTag[%d] start[%d] count[%d]: %v", tagID, start, count, err)
+	}
+
 	return images, nil
 }
 
@@ -0,0 +1,329 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+)
+
+// the longest error message kept for a failed job
+const maxJobErrorLength = 1024
+
+// AddJob queues a job,
+// updating the job's ID upon success
+func (service Service) AddJob(ctx context.Context, job *cameraroll.Job) error {
+	if job == nil {
+		return fmt.Errorf("AddJob : null pointer error")
+	}
+
+	// start a transaction
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("AddJob [%s] imageID[%d]: %v", job.Kind, job.ImageID, err)
+	}
+	defer tx.Rollback()
+
+	// execute the query
+	result, err := tx.ExecContext(ctx,
+		`INSERT INTO jobs (kind, image_id, batch, status) 
+		VALUES (?, ?, ?, ?)`,
+		job.Kind,
+		job.ImageID,
+		job.Batch,
+		cameraroll.JobQueued)
+
+	// check if the query failed
+	if err != nil {
+		return fmt.Errorf("AddJob [%s] imageID[%d]: %v", job.Kind, job.ImageID, err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("AddJob [%s] imageID[%d]: %v", job.Kind, job.ImageID, err)
+	}
+
+	// commit the transaction
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("AddJob [%s] imageID[%d]: %v", job.Kind, job.ImageID, err)
+	}
+
+	job.ID = id
+	job.Status = cameraroll.JobQueued
+
+	return nil
+}
+
+// ClaimJob marks the oldest queued job as running on behalf of owner and returns it,
+// or nil if the queue is empty. Jobs locked by other workers are skipped,
+// so are jobs of an image that has an older job queued or one running.
+// The owner holds on to the job as long as it keeps sending heartbeats.
+func (service Service) ClaimJob(ctx context.Context, owner string) (*cameraroll.Job, error) {
+	job := cameraroll.Job{}
+
+	// start a transaction
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ClaimJob: %v", err)
+	}
+	defer tx.Rollback()
+
+	// execute the query
+	row := tx.QueryRowContext(ctx,
+		`SELECT id, kind, image_id, batch, status, attempts, error, created_at, updated_at 
+		FROM jobs 
+		WHERE status=? AND NOT EXISTS (
+			SELECT 1 
+			FROM jobs AS other 
+			WHERE other.image_id=jobs.image_id AND (other.status=? OR other.status=? AND other.id<jobs.id)
+		) 
+		ORDER BY id 
+		LIMIT 1 
+		FOR UPDATE SKIP LOCKED`,
+		cameraroll.JobQueued,
+		cameraroll.JobRunning,
+		cameraroll.JobQueued)
+
+	// parse response
+	if err := row.Scan(
+		&job.ID,
+		&job.Kind,
+		&job.ImageID,
+		&job.Batch,
+		&job.Status,
+		&job.Attempts,
+		&job.Error,
+		&job.CreatedAt,
+		&job.UpdatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("ClaimJob: %v", err)
+	}
+
+	// take the job
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE jobs 
+		SET status=?, attempts=attempts+1, owner=?, heartbeat_at=NOW() 
+		WHERE id=?`,
+		cameraroll.JobRunning,
+		owner,
+		job.ID); err != nil {
+		return nil, fmt.Errorf("ClaimJob [%d]: %v", job.ID, err)
+	}
+
+	// commit the transaction
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("ClaimJob [%d]: %v", job.ID, err)
+	}
+
+	job.Status = cameraroll.JobRunning
+	job.Attempts++
+	job.Owner = owner
+
+	return &job, nil
+}
+
+// UpdateJobStatus updates the status of a job, along with the error message of its last attempt.
+// Nothing changes unless the job is still held by owner, its lease may have expired
+// and the job been claimed by someone else in the meantime.
+func (service Service) UpdateJobStatus(ctx context.Context, id int64, owner string, status string, message string) error {
+	if len(message) > maxJobErrorLength {
+		message = message[:maxJobErrorLength]
+	}
+
+	// start a transaction
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("UpdateJobStatus [%d]: %v", id, err)
+	}
+	defer tx.Rollback()
+
+	// execute the query
+	_, err = tx.ExecContext(ctx,
+		`UPDATE jobs 
+		SET status=?, error=? 
+		WHERE id=? AND owner=? AND status=?`,
+		status,
+		message,
+		id,
+		owner,
+		cameraroll.JobRunning)
+
+	// check if the query failed
+	if err != nil {
+		return fmt.Errorf("UpdateJobStatus [%d]: %v", id, err)
+	}
+
+	// commit the transaction
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("UpdateJobStatus [%d]: %v", id, err)
+	}
+
+	return nil
+}
+
+// HeartbeatJobs renews the lease of every job owner is running
+func (service Service) HeartbeatJobs(ctx context.Context, owner string) error {
+	// start a transaction
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("HeartbeatJobs [%s]: %v", owner, err)
+	}
+	defer tx.Rollback()
+
+	// execute the query
+	_, err = tx.ExecContext(ctx,
+		`UPDATE jobs 
+		SET heartbeat_at=NOW() 
+		WHERE owner=? AND status=?`,
+		owner,
+		cameraroll.JobRunning)
+
+	// check if the query failed
+	if err != nil {
+		return fmt.Errorf("HeartbeatJobs [%s]: %v", owner, err)
+	}
+
+	// commit the transaction
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("HeartbeatJobs [%s]: %v", owner, err)
+	}
+
+	return nil
+}
+
+// RequeueExpiredJobs puts the running jobs whose owner hasn't sent a heartbeat
+// for longer than lease back into the queue, their owner is presumed dead.
+// Jobs that already took maxAttempts attempts fail instead,
+// they may be what brought their owners down.
+func (service Service) RequeueExpiredJobs(ctx context.Context, lease time.Duration, maxAttempts int) error {
+	// a lease is counted in whole seconds by the database
+	seconds := int64(lease / time.Second)
+
+	// start a transaction
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("RequeueExpiredJobs: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE images JOIN jobs 
+		ON images.id=jobs.image_id 
+		SET images.processing_status=? 
+		WHERE jobs.status=? AND jobs.attempts>=? 
+		AND (jobs.heartbeat_at IS NULL OR jobs.heartbeat_at<NOW()-INTERVAL ? SECOND)`,
+		cameraroll.ProcessingFailed,
+		cameraroll.JobRunning,
+		maxAttempts,
+		seconds); err != nil {
+		return fmt.Errorf("RequeueExpiredJobs: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE jobs 
+		SET status=?, error=? 
+		WHERE status=? AND attempts>=? 
+		AND (heartbeat_at IS NULL OR heartbeat_at<NOW()-INTERVAL ? SECOND)`,
+		cameraroll.JobFailed,
+		"interrupted on every attempt",
+		cameraroll.JobRunning,
+		maxAttempts,
+		seconds); err != nil {
+		return fmt.Errorf("RequeueExpiredJobs: %v", err)
+	}
+
+	// execute the query
+	_, err = tx.ExecContext(ctx,
+		`UPDATE jobs 
+		SET status=? 
+		WHERE status=? 
+		AND (heartbeat_at IS NULL OR heartbeat_at<NOW()-INTERVAL ? SECOND)`,
+		cameraroll.JobQueued,
+		cameraroll.JobRunning,
+		seconds)
+
+	// check if the query failed
+	if err != nil {
+		return fmt.Errorf("RequeueExpiredJobs: %v", err)
+	}
+
+	// commit the transaction
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("RequeueExpiredJobs: %v", err)
+	}
+
+	return nil
+}
+
+// GetJobProgress counts the jobs of a batch by status
+func (service Service) GetJobProgress(ctx context.Context, batch string) (*cameraroll.JobProgress, error) {
+	progress := cameraroll.JobProgress{}
+
+	// execute the query
+	rows, err := service.db.QueryContext(ctx,
+		`SELECT status, COUNT(*) 
+		FROM jobs 
+		WHERE batch=? 
+		GROUP BY status`,
+		batch)
+
+	// check if the query failed
+	if err != nil {
+		return nil, fmt.Errorf("GetJobProgress [%s]: %v", batch, err)
+	}
+
+	defer rows.Close()
+
+	// parse response
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("GetJobProgress [%s]: %v", batch, err)
+		}
+
+		switch status {
+		case cameraroll.JobQueued:
+			progress.Queued = count
+		case cameraroll.JobRunning:
+			progress.Running = count
+		case cameraroll.JobDone:
+			progress.Done = count
+		case cameraroll.JobFailed:
+			progress.Failed = count
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetJobProgress [%s]: %v", batch, err)
+	}
+
+	return &progress, nil
+}
+
+// DeleteJobsOfBatch removes all the jobs of a batch
+func (service Service) DeleteJobsOfBatch(ctx context.Context, batch string) error {
+	// start a transaction
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("DeleteJobsOfBatch [%s]: %v", batch, err)
+	}
+	defer tx.Rollback()
+
+	// execute the query
+	if _, err := tx.ExecContext(ctx, `DELETE FROM jobs WHERE batch=?`, batch); err != nil {
+		return fmt.Errorf("DeleteJobsOfBatch [%s]: %v", batch, err)
+	}
+
+	// commit the transaction
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("DeleteJobsOfBatch [%s]: %v", batch, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+package mysql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+)
+
+// owner of the jobs claimed in the tests
+const testOwner = "test"
+
+// expireLease backdates the last heartbeat of a job past any lease
+func expireLease(t *testing.T, service *Service, id int64) {
+	t.Helper()
+
+	if _, err := service.db.Exec(`UPDATE jobs SET heartbeat_at=NOW()-INTERVAL 1 HOUR WHERE id=?`, id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// jobStatus reads the status of a job
+func jobStatus(t *testing.T, service *Service, id int64) string {
+	t.Helper()
+
+	var status string
+	if err := service.db.QueryRow(`SELECT status FROM jobs WHERE id=?`, id).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+
+	return status
+}
+
+func TestJobQueue(t *testing.T) {
+	service := testService(t)
+	ctx := context.Background()
+
+	other := cameraroll.Image{Path: "http://localhost:9648/public/a.jpg", ProcessingStatus: cameraroll.ProcessingPending}
+	img := cameraroll.Image{Path: "http://localhost:9648/public/b.jpg", ProcessingStatus: cameraroll.ProcessingPending}
+	for _, img := range []*cameraroll.Image{&other, &img} {
+		if err := service.AddImage(ctx, img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first := cameraroll.Job{Kind: cameraroll.JobProcessImage, ImageID: other.ID}
+	second := cameraroll.Job{Kind: cameraroll.JobProcessImage, ImageID: img.ID}
+	for _, job := range []*cameraroll.Job{&first, &second} {
+		if err := service.AddJob(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// jobs are claimed oldest first
+	for _, want := range []int64{first.ID, second.ID} {
+		job, err := service.ClaimJob(ctx, testOwner)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if job == nil || job.ID != want || job.Status != cameraroll.JobRunning || job.Attempts != 1 {
+			t.Fatalf("claimed %+v, want job [%d] running on its first attempt", job, want)
+		}
+	}
+
+	if job, err := service.ClaimJob(ctx, testOwner); job != nil || err != nil {
+		t.Fatalf("claimed %+v, %v from an empty queue", job, err)
+	}
+
+	if err := service.UpdateJobStatus(ctx, first.ID, testOwner, cameraroll.JobDone, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second job is left alone as long as its owner keeps sending heartbeats
+	if err := service.HeartbeatJobs(ctx, testOwner); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := service.RequeueExpiredJobs(ctx, time.Minute, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if job, err := service.ClaimJob(ctx, "other"); job != nil || err != nil {
+		t.Fatalf("claimed %+v, %v, want the job kept by its owner", job, err)
+	}
+
+	// until the owner goes down
+	expireLease(t, service, second.ID)
+	if err := service.RequeueExpiredJobs(ctx, time.Minute, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := service.ClaimJob(ctx, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if job == nil || job.ID != second.ID || job.Attempts != 2 || job.Owner != "other" {
+		t.Fatalf("claimed %+v, want job [%d] on its second attempt", job, second.ID)
+	}
+
+	// the previous owner can't finish the job it lost
+	if err := service.UpdateJobStatus(ctx, second.ID, testOwner, cameraroll.JobDone, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if job, err := service.ClaimJob(ctx, testOwner); job != nil || err != nil {
+		t.Fatalf("claimed %+v, %v, want the finished job left alone", job, err)
+	}
+
+	if status := jobStatus(t, service, second.ID); status != cameraroll.JobRunning {
+		t.Fatalf("job [%d] is %s, want it still running", second.ID, status)
+	}
+
+	// the new owner went down too, which is as many attempts as the job gets
+	expireLease(t, service, second.ID)
+	if err := service.RequeueExpiredJobs(ctx, time.Minute, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if job, err := service.ClaimJob(ctx, testOwner); job != nil || err != nil {
+		t.Fatalf("claimed %+v, %v, want the job given up on", job, err)
+	}
+
+	if status := jobStatus(t, service, second.ID); status != cameraroll.JobFailed {
+		t.Errorf("job [%d] is %s, want %s", second.ID, status, cameraroll.JobFailed)
+	}
+
+	if img, err := service.GetImageByID(ctx, img.ID); err != nil || img.ProcessingStatus != cameraroll.ProcessingFailed {
+		t.Errorf("image = %+v, %v, want its processing failed", img, err)
+	}
+}
+
+func TestJobsOfTheSameImage(t *testing.T) {
+	service := testService(t)
+	ctx := context.Background()
+
+	img := cameraroll.Image{Path: "http://localhost:9648/public/a.jpg", ProcessingStatus: cameraroll.ProcessingPending}
+	if err := service.AddImage(ctx, &img); err != nil {
+		t.Fatal(err)
+	}
+
+	first := cameraroll.Job{Kind: cameraroll.JobProcessImage, ImageID: img.ID}
+	second := cameraroll.Job{Kind: cameraroll.JobProcessImage, ImageID: img.ID}
+	for _, job := range []*cameraroll.Job{&first, &second} {
+		if err := service.AddJob(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if job, err := service.ClaimJob(ctx, testOwner); err != nil || job == nil || job.ID != first.ID {
+		t.Fatalf("claimed %+v, %v, want job [%d]", job, err, first.ID)
+	}
+
+	// the image is only worked on by one job at a time
+	if job, err := service.ClaimJob(ctx, testOwner); job != nil || err != nil {
+		t.Fatalf("claimed %+v, %v while job [%d] is running", job, err, first.ID)
+	}
+
+	if err := service.UpdateJobStatus(ctx, first.ID, testOwner, cameraroll.JobDone, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if job, err := service.ClaimJob(ctx, testOwner); err != nil || job == nil || job.ID != second.ID {
+		t.Errorf("claimed %+v, %v, want job [%d] once the first is done", job, err, second.ID)
+	}
+}
+
+func TestJobProgress(t *testing.T) {
+	service := testService(t)
+	ctx := context.Background()
+
+	img := cameraroll.Image{Path: "http://localhost:9648/public/a.jpg", ProcessingStatus: cameraroll.ProcessingPending}
+	if err := service.AddImage(ctx, &img); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := service.AddJob(ctx, &cameraroll.Job{Kind: cameraroll.JobProcessImage, ImageID: img.ID, Batch: "regenerate-all"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a job of another batch
+	if err := service.AddJob(ctx, &cameraroll.Job{Kind: cameraroll.JobProcessImage, ImageID: img.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	done, err := service.ClaimJob(ctx, testOwner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := service.UpdateJobStatus(ctx, done.ID, testOwner, cameraroll.JobDone, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.ClaimJob(ctx, testOwner); err != nil {
+		t.Fatal(err)
+	}
+
+	progress, err := service.GetJobProgress(ctx, "regenerate-all")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := cameraroll.JobProgress{Queued: 1, Running: 1, Done: 1}
+	if *progress != want || progress.Total() != 3 || progress.Finished() {
+		t.Errorf("progress = %+v, want %+v", *progress, want)
+	}
+
+	if err := service.DeleteJobsOfBatch(ctx, "regenerate-all"); err != nil {
+		t.Fatal(err)
+	}
+
+	progress, err = service.GetJobProgress(ctx, "regenerate-all")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if progress.Total() != 0 || !progress.Finished() {
+		t.Errorf("progress after deleting the batch = %+v", *progress)
+	}
+
+	// the other job is still there
+	if job, err := service.ClaimJob(ctx, testOwner); err != nil || job == nil || len(job.Batch) != 0 {
+		t.Errorf("claimed %+v, %v, want the job without a batch", job, err)
+	}
+}
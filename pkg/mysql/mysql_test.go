@@ -0,0 +1,54 @@
+package mysql
+
+import (
+	"os"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/mysql"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+// testService connects to the database given by the DB_*_TEST environment variables,
+// skipping the test if there's none. Every image, and everything hanging off them,
+// is deleted first, so never point these at a database worth keeping.
+func testService(t *testing.T) *Service {
+	t.Helper()
+
+	address := os.Getenv("DB_ADDR_TEST")
+	if len(address) == 0 {
+		t.Skip("DB_ADDR_TEST isn't set, skipping the database tests")
+	}
+
+	db, err := Connect(os.Getenv("DB_USER_TEST"), os.Getenv("DB_PASS_TEST"), os.Getenv("DB_NAME_TEST"), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Migrate looks for the migrations next to the binary, the tests run from the source tree
+	driver, err := mysql.WithInstance(db, &mysql.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := migrate.NewWithDatabaseInstance("file://../../migration", "mysql", driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec(`DELETE FROM images`); err != nil {
+		t.Fatal(err)
+	}
+
+	service := NewService(db)
+	t.Cleanup(func() {
+		service.Cleanup()
+		db.Close()
+	})
+
+	return service
+}
@@ -0,0 +1,155 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+)
+
+// addRenditions inserts the renditions of an image within an ongoing transaction,
+// updating the renditions' IDs upon success
+func addRenditions(ctx context.Context, tx *sql.Tx, imageID int64, renditions []*cameraroll.Rendition) error {
+	for _, rendition := range renditions {
+		result, err := tx.ExecContext(ctx,
+			`INSERT INTO image_renditions (image_id, path, mime_type, width, height, size, watermark)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			imageID,
+			rendition.URL,
+			rendition.MimeType,
+			rendition.Width,
+			rendition.Height,
+			rendition.Size,
+			rendition.Watermark)
+
+		if err != nil {
+			return fmt.Errorf("addRenditions imageID[%d]: %v", imageID, err)
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("addRenditions imageID[%d]: %v", imageID, err)
+		}
+
+		rendition.ID = id
+		rendition.ImageID = imageID
+	}
+
+	return nil
+}
+
+// attachRenditions queries the database for the renditions of images with the default watermark,
+// filling in each image's Renditions
+func (service Service) attachRenditions(ctx context.Context, images ...*cameraroll.Image) error {
+	return service.attachMarkedRenditions(ctx, "", images...)
+}
+
+// attachMarkedRenditions queries the database for the renditions of images as shown in an album using watermark,
+// filling in each image's Renditions. Widths rendered with the album's watermark replace the default ones.
+func (service Service) attachMarkedRenditions(ctx context.Context, watermark string, images ...*cameraroll.Image) error {
+	if len(images) == 0 {
+		return nil
+	}
+
+	// index images by their IDs
+	imageMap := make(map[int64]*cameraroll.Image, len(images))
+	placeholders := make([]string, 0, len(images))
+	args := make([]interface{}, 0, len(images)+1)
+	for _, img := range images {
+		img.Renditions = []*cameraroll.Rendition{}
+		imageMap[img.ID] = img
+		placeholders = append(placeholders, "?")
+		args = append(args, img.ID)
+	}
+
+	// execute the query
+	rows, err := service.db.QueryContext(ctx,
+		`SELECT id, image_id, path, mime_type, width, height, size, watermark
+		FROM image_renditions
+		WHERE image_id IN (`+strings.Join(placeholders, ", ")+`) AND watermark IN ('', ?)
+		ORDER BY width, watermark=''`,
+		append(args, watermark)...)
+
+	// check if the query failed
+	if err != nil {
+		return fmt.Errorf("attachRenditions: %v", err)
+	}
+
+	defer rows.Close()
+
+	// parse response
+	for rows.Next() {
+		rendition := cameraroll.Rendition{}
+		if err := rows.Scan(
+			&rendition.ID,
+			&rendition.ImageID,
+			&rendition.URL,
+			&rendition.MimeType,
+			&rendition.Width,
+			&rendition.Height,
+			&rendition.Size,
+			&rendition.Watermark); err != nil {
+			return fmt.Errorf("attachRenditions: %v", err)
+		}
+
+		img := imageMap[rendition.ImageID]
+		if img == nil {
+			continue
+		}
+
+		// the marked rendition of a width comes first, the default one is left out
+		if last := len(img.Renditions) - 1; last >= 0 && img.Renditions[last].Width == rendition.Width && len(rendition.Watermark) == 0 {
+			continue
+		}
+
+		img.Renditions = append(img.Renditions, &rendition)
+	}
+
+	return rows.Err()
+}
+
+// GetRenditionsOfImage queries the database for all the renditions of an image,
+// including the ones carrying album watermarks
+func (service Service) GetRenditionsOfImage(ctx context.Context, id int64) ([]*cameraroll.Rendition, error) {
+	// execute the query
+	rows, err := service.db.QueryContext(ctx,
+		`SELECT id, image_id, path, mime_type, width, height, size, watermark
+		FROM image_renditions
+		WHERE image_id=?
+		ORDER BY watermark, width`,
+		id)
+
+	// check if the query failed
+	if err != nil {
+		return nil, fmt.Errorf("GetRenditionsOfImage [%d]: %v", id, err)
+	}
+
+	defer rows.Close()
+
+	// parse response
+	renditions := []*cameraroll.Rendition{}
+	for rows.Next() {
+		rendition := cameraroll.Rendition{}
+		if err := rows.Scan(
+			&rendition.ID,
+			&rendition.ImageID,
+			&rendition.URL,
+			&rendition.MimeType,
+			&rendition.Width,
+			&rendition.Height,
+			&rendition.Size,
+			&rendition.Watermark); err != nil {
+			return nil, fmt.Errorf("GetRenditionsOfImage [%d]: %v", id, err)
+		}
+
+		renditions = append(renditions, &rendition)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetRenditionsOfImage [%d]: %v", id, err)
+	}
+
+	return renditions, nil
+}
@@ -10,6 +10,17 @@ import (
 // timeouts
 const modelInitTimeout = 7 * time.Second
 
+// columns of the images table, in the order scanImage reads them
+const imageColumns = `images.id, images.path, images.mime_type, images.width, images.height, images.thumbnail, images.width_thumb, images.height_thumb, images.title, images.description, images.created_at,
+	images.camera_make, images.camera_model, images.lens, images.focal_length, images.aperture, images.shutter_speed, images.exposure_time, images.iso, images.date_taken, images.latitude, images.longitude,
+	images.blurhash, images.lqip, images.color, images.phash, images.processing_status, images.focal_x, images.focal_y, images.color_space, images.duplicate_of`
+
+// columns of albums in the order scanAlbum reads them
+const albumColumns = `albums.id, albums.title, albums.description, albums.created_at, albums.watermark, albums.sort_mode, albums.cover_id, albums.parent_id, albums.smart_rule`
+
+// columns of tags in the order scanTag reads them
+const tagColumns = `tags.id, tags.name, tags.parent_id`
+
 // keys for prepared sql statements
 const (
 	keyQueryGetImages          = "GetImages"
@@ -25,6 +36,7 @@ const (
 	keyQueryGetTagsOfAlbum     = "GetTagsOfAlbum"
 	keyQueryGetImagesWithTag   = "GetImagesWithTag"
 	keyQueryGetTagsOfImage     = "GetTagsOfImage"
+	keyQueryGetSimilarImages   = "GetSimilarImages"
 )
 
 type Service struct {
@@ -40,63 +52,89 @@ type Service struct {
 func (service *Service) createPreparedStmts() error {
 	// sql templates
 	queries := map[string]string{
-		keyQueryGetImages:    `SELECT * FROM images ORDER BY created_at DESC LIMIT ?, ?`,
-		keyQueryGetImageByID: `SELECT * FROM images WHERE id=?`,
-		keyQueryGetTags:      `SELECT * FROM tags ORDER BY id`,
-		keyQueryGetTagByID:   `SELECT * FROM tags WHERE id=?`,
-		keyQueryGetAlbums:    `SELECT * FROM albums ORDER BY created_at DESC LIMIT ?, ?`,
-		keyQueryGetAlbumByID: `SELECT * FROM albums WHERE id=?`,
-		keyQueryGetImagesFromAlbum: `SELECT images.id, images.path, images.width, images.height, images.thumbnail, images.width_thumb, images.height_thumb, images.title, images.description, images.created_at
+		keyQueryGetImages:    `SELECT ` + imageColumns + ` FROM images ORDER BY created_at DESC LIMIT ?, ?`,
+		keyQueryGetImageByID: `SELECT ` + imageColumns + ` FROM images WHERE id=?`,
+		keyQueryGetTags:      `SELECT ` + tagColumns + ` FROM tags ORDER BY id`,
+		keyQueryGetTagByID:   `SELECT ` + tagColumns + ` FROM tags WHERE id=?`,
+		keyQueryGetAlbums:    `SELECT ` + albumColumns + ` FROM albums ORDER BY created_at DESC LIMIT ?, ?`,
+		keyQueryGetAlbumByID: `SELECT ` + albumColumns + ` FROM albums WHERE id=?`,
+		keyQueryGetImagesFromAlbum: `SELECT ` + imageColumns + `
 									FROM albums JOIN image_albums 
 									ON albums.id=image_albums.album_id 
 									JOIN images 
 									ON image_albums.image_id=images.id 
 									WHERE albums.id=?
-									ORDER BY image_albums.id DESC`,
-		keyQueryGetCoverOfAlbum: `SELECT images.id, images.path, images.width, images.height, images.thumbnail, images.width_thumb, images.height_thumb, images.title, images.description, images.created_at
+									ORDER BY 
+										CASE WHEN albums.sort_mode='date_taken' THEN images.date_taken IS NULL END,
+										CASE WHEN albums.sort_mode='date_taken' THEN images.date_taken END,
+										CASE WHEN albums.sort_mode='uploaded' THEN images.created_at END DESC,
+										image_albums.position, 
+										image_albums.id DESC`,
+		keyQueryGetCoverOfAlbum: `SELECT ` + imageColumns + `
 									FROM albums JOIN image_albums 
 									ON albums.id=image_albums.album_id 
 									JOIN images 
 									ON image_albums.image_id=images.id 
 									WHERE albums.id=?
-									ORDER BY image_albums.id DESC LIMIT 1`,
-		keyQueryGetAlbumsOfImage: `SELECT albums.id, albums.title, albums.description, albums.created_at
+									ORDER BY images.id=albums.cover_id DESC, image_albums.id DESC LIMIT 1`,
+		keyQueryGetAlbumsOfImage: `SELECT ` + albumColumns + `
 									FROM images JOIN image_albums 
 									ON images.id=image_albums.image_id 
 									JOIN albums 
 									ON image_albums.album_id=albums.id 
 									WHERE images.id=?
 									ORDER BY image_albums.id DESC`,
-		keyQueryGetAlbumsWithTag: `SELECT albums.id, albums.title, albums.description, albums.created_at
-									FROM tags JOIN album_tags
-									ON tags.id=album_tags.tag_id
+		keyQueryGetAlbumsWithTag: `WITH RECURSIVE subtags (id) AS (
+										SELECT id FROM tags WHERE id=? 
+										UNION ALL 
+										SELECT tags.id 
+										FROM tags JOIN subtags 
+										ON tags.parent_id=subtags.id 
+										WHERE ?
+									)
+									SELECT ` + albumColumns + `
+									FROM subtags JOIN album_tags
+									ON subtags.id=album_tags.tag_id
 									JOIN albums
 									ON albums.id=album_tags.album_id
-									WHERE tags.id=?
-									ORDER BY album_tags.id DESC
+									GROUP BY albums.id
+									ORDER BY MAX(album_tags.id) DESC
 									LIMIT ?, ?`,
-		keyQueryGetTagsOfAlbum: `SELECT tags.id, tags.name
+		keyQueryGetTagsOfAlbum: `SELECT ` + tagColumns + `
 								FROM albums JOIN album_tags
 								ON albums.id=album_tags.album_id
 								JOIN tags
 								ON album_tags.tag_id=tags.id
 								WHERE albums.id=?
 								ORDER BY tags.id DESC`,
-		keyQueryGetImagesWithTag: `SELECT images.id, images.path, images.width, images.height, images.thumbnail, images.width_thumb, images.height_thumb, images.title, images.description, images.created_at
-									FROM tags JOIN image_tags
-									ON tags.id=image_tags.tag_id
+		keyQueryGetImagesWithTag: `WITH RECURSIVE subtags (id) AS (
+										SELECT id FROM tags WHERE id=? 
+										UNION ALL 
+										SELECT tags.id 
+										FROM tags JOIN subtags 
+										ON tags.parent_id=subtags.id 
+										WHERE ?
+									)
+									SELECT ` + imageColumns + `
+									FROM subtags JOIN image_tags
+									ON subtags.id=image_tags.tag_id
 									JOIN images
 									ON images.id=image_tags.image_id
-									WHERE tags.id=?
-									ORDER BY image_tags.id DESC
+									GROUP BY images.id
+									ORDER BY MAX(image_tags.id) DESC
 									LIMIT ?, ?`,
-		keyQueryGetTagsOfImage: `SELECT tags.id, tags.name
+		keyQueryGetTagsOfImage: `SELECT ` + tagColumns + `
 								FROM images JOIN image_tags
 								ON images.id=image_tags.image_id
 								JOIN tags
 								ON image_tags.tag_id=tags.id
 								WHERE images.id=?
 								ORDER BY tags.id DESC`,
+		keyQueryGetSimilarImages: `SELECT ` + imageColumns + `, BIT_COUNT(images.phash ^ ?) AS distance
+									FROM images
+									WHERE images.phash IS NOT NULL AND images.id<>?
+									HAVING distance<=?
+									ORDER BY distance, images.created_at DESC`,
 	}
 
 	var err error
@@ -0,0 +1,171 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+)
+
+// errSmartAlbum is returned when images are arranged by hand in a smart album
+var errSmartAlbum = errors.New("the images of a smart album are picked by its rule")
+
+// images under a tag, given its name or an alias, or under any tag nested in it
+const imagesUnderTagName = `images.id IN (
+	SELECT image_tags.image_id
+	FROM image_tags
+	WHERE image_tags.tag_id IN (
+		WITH RECURSIVE subtags (id) AS (
+			SELECT tags.id
+			FROM tags LEFT JOIN tag_aliases
+			ON tags.id=tag_aliases.tag_id AND tag_aliases.name=?
+			WHERE tags.name=? OR tag_aliases.id IS NOT NULL
+			UNION ALL
+			SELECT tags.id
+			FROM tags JOIN subtags
+			ON tags.parent_id=subtags.id
+		)
+		SELECT id FROM subtags
+	)
+)`
+
+// ruleConditions turns a rule into the WHERE clause of a query on images, along with its arguments
+func ruleConditions(rule *cameraroll.SmartRule) (string, []interface{}) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	for _, tag := range rule.Tags {
+		conditions = append(conditions, imagesUnderTagName)
+		args = append(args, tag, tag)
+	}
+
+	if rule.TakenAfter != nil {
+		conditions = append(conditions, `images.date_taken>=?`)
+		args = append(args, *rule.TakenAfter)
+	}
+
+	if rule.TakenBefore != nil {
+		conditions = append(conditions, `images.date_taken<?`)
+		args = append(args, *rule.TakenBefore)
+	}
+
+	if rule.MinFocalLength != nil {
+		conditions = append(conditions, `images.focal_length>=?`)
+		args = append(args, *rule.MinFocalLength)
+	}
+
+	if rule.MaxFocalLength != nil {
+		conditions = append(conditions, `images.focal_length<=?`)
+		args = append(args, *rule.MaxFocalLength)
+	}
+
+	if rule.MinAperture != nil {
+		conditions = append(conditions, `images.aperture>=?`)
+		args = append(args, *rule.MinAperture)
+	}
+
+	if rule.MaxAperture != nil {
+		conditions = append(conditions, `images.aperture<=?`)
+		args = append(args, *rule.MaxAperture)
+	}
+
+	if rule.MinISO != nil {
+		conditions = append(conditions, `images.iso>=?`)
+		args = append(args, *rule.MinISO)
+	}
+
+	if rule.MaxISO != nil {
+		conditions = append(conditions, `images.iso<=?`)
+		args = append(args, *rule.MaxISO)
+	}
+
+	if len(rule.CameraMake) > 0 {
+		conditions = append(conditions, `images.camera_make=?`)
+		args = append(args, rule.CameraMake)
+	}
+
+	if len(rule.CameraModel) > 0 {
+		conditions = append(conditions, `images.camera_model=?`)
+		args = append(args, rule.CameraModel)
+	}
+
+	if len(rule.Lens) > 0 {
+		conditions = append(conditions, `images.lens=?`)
+		args = append(args, rule.Lens)
+	}
+
+	// a rule without conditions picks every image
+	if len(conditions) == 0 {
+		return `TRUE`, args
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// getImagesOfSmartAlbum queries the database for the images meeting the rule of a smart album,
+// sorted by its sort mode, the most recently uploaded first unless sorted by date taken.
+// Only the cover is returned if cover is set, the pinned one if it still meets the rule.
+func (service Service) getImagesOfSmartAlbum(ctx context.Context, alb *cameraroll.Album, cover bool) ([]*cameraroll.Image, error) {
+	conditions, args := ruleConditions(alb.Rule)
+
+	order := `images.created_at DESC, images.id DESC`
+	if alb.SortMode == cameraroll.SortDateTaken {
+		order = `images.date_taken IS NULL, images.date_taken, images.id`
+	}
+
+	limit := ``
+	if cover {
+		order = `images.id=? DESC, ` + order
+		args = append(args, alb.CoverID)
+		limit = ` LIMIT 1`
+	}
+
+	// execute the query
+	rows, err := service.db.QueryContext(ctx,
+		`SELECT `+imageColumns+`
+		FROM images
+		WHERE `+conditions+`
+		ORDER BY `+order+limit,
+		args...)
+
+	// check if the query failed
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	// parse response
+	images := []*cameraroll.Image{}
+	for rows.Next() {
+		img := cameraroll.Image{}
+		if err := scanImage(rows, &img); err != nil {
+			return nil, err
+		}
+
+		images = append(images, &img)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// query database for image renditions
+	if err := service.attachRenditions(ctx, images...); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// findSmartAlbum queries the database for an album if it's a smart one, returning nil otherwise
+func findSmartAlbum(ctx context.Context, q queryRower, id int64) (*cameraroll.Album, error) {
+	alb, err := findAlbum(ctx, q, id)
+	if err == sql.ErrNoRows || (err == nil && alb.Rule == nil) {
+		return nil, nil
+	}
+
+	return alb, err
+}
@@ -0,0 +1,66 @@
+package mysql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+)
+
+func TestRuleConditions(t *testing.T) {
+	after := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	focal := 35.0
+	iso := 800
+
+	tests := map[string]struct {
+		rule       cameraroll.SmartRule
+		conditions []string
+		args       []interface{}
+	}{
+		"no rule": {
+			conditions: []string{`TRUE`},
+			args:       []interface{}{},
+		},
+		"tags": {
+			rule:       cameraroll.SmartRule{Tags: []string{"cats", "dogs"}},
+			conditions: []string{imagesUnderTagName, imagesUnderTagName},
+			args:       []interface{}{"cats", "cats", "dogs", "dogs"},
+		},
+		"exif": {
+			rule: cameraroll.SmartRule{
+				TakenAfter:     &after,
+				MinFocalLength: &focal,
+				MaxISO:         &iso,
+				CameraMake:     "FUJIFILM",
+				Lens:           "XF35mmF1.4 R",
+			},
+			conditions: []string{
+				`images.date_taken>=?`,
+				`images.focal_length>=?`,
+				`images.iso<=?`,
+				`images.camera_make=?`,
+				`images.lens=?`,
+			},
+			args: []interface{}{after, focal, iso, "FUJIFILM", "XF35mmF1.4 R"},
+		},
+	}
+
+	for name, test := range tests {
+		conditions, args := ruleConditions(&test.rule)
+
+		if want := strings.Join(test.conditions, " AND "); conditions != want {
+			t.Errorf("%s: conditions = %s, want %s", name, conditions, want)
+		}
+
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%s: args = %v, want %v", name, args, test.args)
+		}
+
+		// every placeholder gets an argument
+		if placeholders := strings.Count(conditions, "?"); placeholders != len(args) {
+			t.Errorf("%s: %d placeholders for %d args", name, placeholders, len(args))
+		}
+	}
+}
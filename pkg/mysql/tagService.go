@@ -4,11 +4,26 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 
 	"chujungeng/camera-roll/pkg/cameraroll"
 )
 
-// DeleteTagByID removes a tag from the database
+// scanTag reads a row of tagColumns into tag
+func scanTag(row rowScanner, tag *cameraroll.Tag) error {
+	var parentID sql.NullInt64
+
+	if err := row.Scan(&tag.ID, &tag.Name, &parentID); err != nil {
+		return err
+	}
+
+	tag.ParentID = parentID.Int64
+
+	return nil
+}
+
+// DeleteTagByID removes a tag from the database,
+// the tags nested in it move up to its parent
 func (service Service) DeleteTagByID(ctx context.Context, id int64) error {
 	// start a transaction
 	tx, err := service.db.BeginTx(ctx, nil)
@@ -17,6 +32,11 @@ func (service Service) DeleteTagByID(ctx context.Context, id int64) error {
 	}
 	defer tx.Rollback()
 
+	// the foreign key would leave the children at the top level
+	if err := reparentChildrenOfTag(ctx, tx, id); err != nil {
+		return fmt.Errorf("DeleteTagByID [%d]: %v", id, err)
+	}
+
 	// execute the query
 	result, err := tx.ExecContext(ctx,
 		`DELETE FROM tags 
@@ -41,12 +61,15 @@ func (service Service) DeleteTagByID(ctx context.Context, id int64) error {
 	return nil
 }
 
-// UpdateTagByID updates an tag's name
+// UpdateTagByID updates a tag's name and parent,
+// refusing to nest a tag inside itself or any of its descendants
 func (service Service) UpdateTagByID(ctx context.Context, id int64, newTag *cameraroll.Tag) error {
 	if newTag == nil {
 		return fmt.Errorf("UpdateTagByID [%d]: null pointer error", id)
 	}
 
+	parentID := sql.NullInt64{Int64: newTag.ParentID, Valid: newTag.ParentID > 0}
+
 	// start a transaction
 	tx, err := service.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -54,12 +77,29 @@ func (service Service) UpdateTagByID(ctx context.Context, id int64, newTag *came
 	}
 	defer tx.Rollback()
 
+	if err := checkTagName(ctx, tx, newTag.Name); err != nil {
+		return fmt.Errorf("UpdateTagByID [%d]: %v", id, err)
+	}
+
+	// the tag mustn't be among the ancestors of its new parent
+	if parentID.Valid {
+		_, nested, err := ancestorsOfTag(ctx, tx, newTag.ParentID, id)
+		if err != nil {
+			return fmt.Errorf("UpdateTagByID [%d]: %v", id, err)
+		}
+
+		if nested {
+			return fmt.Errorf("UpdateTagByID [%d]: tag [%d] is nested in this tag", id, newTag.ParentID)
+		}
+	}
+
 	// execute the query
 	result, err := tx.ExecContext(ctx,
 		`UPDATE tags 
-		SET name=? 
+		SET name=?, parent_id=? 
 		WHERE id=?`,
 		newTag.Name,
+		parentID,
 		id)
 
 	// check if the query failed
@@ -103,7 +143,7 @@ func (service Service) GetTagByID(ctx context.Context, id int64) (*cameraroll.Ta
 	row := txStmt.QueryRowContext(ctx, id)
 
 	// parse response
-	if err := row.Scan(&tag.ID, &tag.Name); err != nil {
+	if err := scanTag(row, &tag); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("GetTagByID[%d]: no such tag", id)
 		}
@@ -116,6 +156,42 @@ func (service Service) GetTagByID(ctx context.Context, id int64) (*cameraroll.Ta
 		return nil, fmt.Errorf("GetTagByID[%d]: %v", id, err)
 	}
 
+	// query database for tag aliases
+	if err := service.attachAliases(ctx, &tag); err != nil {
+		return nil, fmt.Errorf("GetTagByID[%d]: %v", id, err)
+	}
+
+	return &tag, nil
+}
+
+// GetTagByName returns the tag given its name or one of its aliases
+func (service Service) GetTagByName(ctx context.Context, name string) (*cameraroll.Tag, error) {
+	tag := cameraroll.Tag{}
+
+	// execute the query
+	row := service.db.QueryRowContext(ctx,
+		`SELECT `+tagColumns+` 
+		FROM tags LEFT JOIN tag_aliases 
+		ON tags.id=tag_aliases.tag_id AND tag_aliases.name=? 
+		WHERE tags.name=? OR tag_aliases.id IS NOT NULL 
+		LIMIT 1`,
+		name,
+		name)
+
+	// parse response
+	if err := scanTag(row, &tag); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("GetTagByName [%s]: no such tag", name)
+		}
+
+		return nil, fmt.Errorf("GetTagByName [%s]: %v", name, err)
+	}
+
+	// query database for tag aliases
+	if err := service.attachAliases(ctx, &tag); err != nil {
+		return nil, fmt.Errorf("GetTagByName [%s]: %v", name, err)
+	}
+
 	return &tag, nil
 }
 
@@ -152,7 +228,7 @@ func (service Service) GetTags(ctx context.Context) ([]*cameraroll.Tag, error) {
 	// parse response
 	for rows.Next() {
 		tag := cameraroll.Tag{}
-		if err := rows.Scan(&tag.ID, &tag.Name); err != nil {
+		if err := scanTag(rows, &tag); err != nil {
 			return nil, fmt.Errorf("GetTags: %v", err)
 		}
 
@@ -165,6 +241,11 @@ func (service Service) GetTags(ctx context.Context) ([]*cameraroll.Tag, error) {
 		return nil, fmt.Errorf("GetTags: %v", err)
 	}
 
+	// query database for tag aliases
+	if err := service.attachAliases(ctx, tags...); err != nil {
+		return nil, fmt.Errorf("GetTags: %v", err)
+	}
+
 	return tags, nil
 }
 
@@ -182,11 +263,16 @@ func (service Service) AddTag(ctx context.Context, tag *cameraroll.Tag) error {
 	}
 	defer tx.Rollback()
 
+	if err := checkTagName(ctx, tx, tag.Name); err != nil {
+		return fmt.Errorf("AddTag [%s]: %v", tag.Name, err)
+	}
+
 	// execute the query
 	result, err := tx.ExecContext(ctx,
-		`INSERT INTO tags (name) 
-		VALUES (?)`,
-		tag.Name)
+		`INSERT INTO tags (name, parent_id) 
+		VALUES (?, ?)`,
+		tag.Name,
+		sql.NullInt64{Int64: tag.ParentID, Valid: tag.ParentID > 0})
 
 	// check if the query failed
 	if err != nil {
@@ -207,3 +293,293 @@ func (service Service) AddTag(ctx context.Context, tag *cameraroll.Tag) error {
 
 	return nil
 }
+
+// AddAliasToTag adds another name that resolves to a tag
+func (service Service) AddAliasToTag(ctx context.Context, id int64, alias string) error {
+	// start a transaction
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("AddAliasToTag [%d] alias[%s]: %v", id, alias, err)
+	}
+	defer tx.Rollback()
+
+	// an alias can't shadow the name of a tag
+	var tags int
+	row := tx.QueryRowContext(ctx,
+		`SELECT COUNT(*) 
+		FROM tags 
+		WHERE name=?`,
+		alias)
+
+	if err := row.Scan(&tags); err != nil {
+		return fmt.Errorf("AddAliasToTag [%d] alias[%s]: %v", id, alias, err)
+	}
+
+	if tags > 0 {
+		return fmt.Errorf("AddAliasToTag [%d] alias[%s]: a tag already has this name", id, alias)
+	}
+
+	// execute the query
+	result, err := tx.ExecContext(ctx,
+		`INSERT INTO tag_aliases (name, tag_id) 
+		VALUES (?, ?)`,
+		alias,
+		id)
+
+	// check if the query failed
+	if err != nil {
+		return fmt.Errorf("AddAliasToTag [%d] alias[%s]: %v", id, alias, err)
+	}
+
+	_, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("AddAliasToTag [%d] alias[%s]: %v", id, alias, err)
+	}
+
+	// commit the transaction
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("AddAliasToTag [%d] alias[%s]: %v", id, alias, err)
+	}
+
+	return nil
+}
+
+// RemoveAliasFromTag removes one of the other names of a tag
+func (service Service) RemoveAliasFromTag(ctx context.Context, id int64, alias string) error {
+	// start a transaction
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("RemoveAliasFromTag [%d] alias[%s]: %v", id, alias, err)
+	}
+	defer tx.Rollback()
+
+	// execute the query
+	result, err := tx.ExecContext(ctx,
+		`DELETE FROM tag_aliases 
+		WHERE tag_id=? AND name=?`,
+		id,
+		alias)
+
+	// check if the query failed
+	if err != nil {
+		return fmt.Errorf("RemoveAliasFromTag [%d] alias[%s]: %v", id, alias, err)
+	}
+
+	_, err = result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("RemoveAliasFromTag [%d] alias[%s]: %v", id, alias, err)
+	}
+
+	// commit the transaction
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("RemoveAliasFromTag [%d] alias[%s]: %v", id, alias, err)
+	}
+
+	return nil
+}
+
+// MergeTags folds a duplicate tag into the tag specified by id.
+// The images, albums, aliases and children of the duplicate move over to the tag,
+// the duplicate is deleted and its name is kept as an alias of the tag.
+func (service Service) MergeTags(ctx context.Context, id int64, duplicateID int64) error {
+	if id == duplicateID {
+		return fmt.Errorf("MergeTags [%d] duplicate[%d]: cannot merge a tag into itself", id, duplicateID)
+	}
+
+	// start a transaction
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("MergeTags [%d] duplicate[%d]: %v", id, duplicateID, err)
+	}
+	defer tx.Rollback()
+
+	var name string
+	row := tx.QueryRowContext(ctx,
+		`SELECT name 
+		FROM tags 
+		WHERE id=? 
+		FOR UPDATE`,
+		duplicateID)
+
+	if err := row.Scan(&name); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("MergeTags [%d] duplicate[%d]: no such tag", id, duplicateID)
+		}
+
+		return fmt.Errorf("MergeTags [%d] duplicate[%d]: %v", id, duplicateID, err)
+	}
+
+	// the children of the duplicate would end up nested in themselves
+	found, nested, err := ancestorsOfTag(ctx, tx, id, duplicateID)
+	if err != nil {
+		return fmt.Errorf("MergeTags [%d] duplicate[%d]: %v", id, duplicateID, err)
+	}
+
+	if !found {
+		return fmt.Errorf("MergeTags [%d] duplicate[%d]: no such tag", id, duplicateID)
+	}
+
+	if nested {
+		return fmt.Errorf("MergeTags [%d] duplicate[%d]: tag is nested in the duplicate", id, duplicateID)
+	}
+
+	// rows already pointing at the tag are left behind and deleted with the duplicate
+	queries := []string{
+		`UPDATE IGNORE image_tags 
+		SET tag_id=? 
+		WHERE tag_id=?`,
+		`UPDATE IGNORE album_tags 
+		SET tag_id=? 
+		WHERE tag_id=?`,
+		`UPDATE tag_aliases 
+		SET tag_id=? 
+		WHERE tag_id=?`,
+		`UPDATE tags 
+		SET parent_id=? 
+		WHERE parent_id=?`,
+	}
+
+	for _, query := range queries {
+		if _, err := tx.ExecContext(ctx, query, id, duplicateID); err != nil {
+			return fmt.Errorf("MergeTags [%d] duplicate[%d]: %v", id, duplicateID, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM tags 
+		WHERE id=?`,
+		duplicateID); err != nil {
+		return fmt.Errorf("MergeTags [%d] duplicate[%d]: %v", id, duplicateID, err)
+	}
+
+	// the old name keeps resolving to the tag
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO tag_aliases (name, tag_id) 
+		VALUES (?, ?)`,
+		name,
+		id); err != nil {
+		return fmt.Errorf("MergeTags [%d] duplicate[%d]: %v", id, duplicateID, err)
+	}
+
+	// commit the transaction
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("MergeTags [%d] duplicate[%d]: %v", id, duplicateID, err)
+	}
+
+	return nil
+}
+
+// attachAliases queries the database for the aliases of tags,
+// filling in each tag's Aliases
+func (service Service) attachAliases(ctx context.Context, tags ...*cameraroll.Tag) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	// index tags by their IDs
+	tagMap := make(map[int64]*cameraroll.Tag, len(tags))
+	placeholders := make([]string, 0, len(tags))
+	args := make([]interface{}, 0, len(tags))
+	for _, tag := range tags {
+		tagMap[tag.ID] = tag
+		placeholders = append(placeholders, "?")
+		args = append(args, tag.ID)
+	}
+
+	// execute the query
+	rows, err := service.db.QueryContext(ctx,
+		`SELECT tag_id, name
+		FROM tag_aliases
+		WHERE tag_id IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY name`,
+		args...)
+
+	// check if the query failed
+	if err != nil {
+		return fmt.Errorf("attachAliases: %v", err)
+	}
+
+	defer rows.Close()
+
+	// parse response
+	for rows.Next() {
+		var tagID int64
+		var alias string
+		if err := rows.Scan(&tagID, &alias); err != nil {
+			return fmt.Errorf("attachAliases: %v", err)
+		}
+
+		if tag := tagMap[tagID]; tag != nil {
+			tag.Aliases = append(tag.Aliases, alias)
+		}
+	}
+
+	return rows.Err()
+}
+
+// checkTagName makes sure a tag name isn't taken by an alias
+func checkTagName(ctx context.Context, tx *sql.Tx, name string) error {
+	var aliases int
+	row := tx.QueryRowContext(ctx,
+		`SELECT COUNT(*) 
+		FROM tag_aliases 
+		WHERE name=?`,
+		name)
+
+	if err := row.Scan(&aliases); err != nil {
+		return err
+	}
+
+	if aliases > 0 {
+		return fmt.Errorf("[%s] is already an alias", name)
+	}
+
+	return nil
+}
+
+// ancestorsOfTag walks up from a tag to the top level,
+// reporting if the tag was found and if ancestorID is among the tags on the way, itself included
+func ancestorsOfTag(ctx context.Context, tx *sql.Tx, id int64, ancestorID int64) (found bool, nested bool, err error) {
+	var depth, matches int
+	row := tx.QueryRowContext(ctx,
+		`WITH RECURSIVE ancestors (id, parent_id) AS (
+			SELECT id, parent_id FROM tags WHERE id=? 
+			UNION ALL 
+			SELECT tags.id, tags.parent_id 
+			FROM tags JOIN ancestors 
+			ON tags.id=ancestors.parent_id
+		)
+		SELECT COUNT(*), COALESCE(SUM(id=?), 0) FROM ancestors`,
+		id,
+		ancestorID)
+
+	if err := row.Scan(&depth, &matches); err != nil {
+		return false, false, err
+	}
+
+	return depth > 0, matches > 0, nil
+}
+
+// reparentChildrenOfTag moves the tags nested in a tag up to its parent
+func reparentChildrenOfTag(ctx context.Context, tx *sql.Tx, id int64) error {
+	var parentID sql.NullInt64
+	row := tx.QueryRowContext(ctx,
+		`SELECT parent_id 
+		FROM tags 
+		WHERE id=? 
+		FOR UPDATE`,
+		id)
+
+	if err := row.Scan(&parentID); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx,
+		`UPDATE tags 
+		SET parent_id=? 
+		WHERE parent_id=?`,
+		parentID,
+		id)
+
+	return err
+}
@@ -3,6 +3,8 @@ package routes
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 
@@ -14,6 +16,7 @@ import (
 
 const (
 	ParamAlbumID = "albumID"
+	ParamCascade = "cascade"
 )
 
 // AlbumRouterPublic specifies all the public routes related to albums
@@ -26,8 +29,9 @@ func (handler Handler) AlbumRouterPublic() chi.Router {
 		r.Use(handler.AlbumCtx)      // Load the *Album on the request context
 		r.Get("/", handler.GetAlbum) // GET /albums/123
 
-		r.Get("/images", handler.GetImagesFromAlbum) // GET /albums/123/images
-		r.Get("/tags", handler.GetTagsOfAlbum)       // GET /albums/123/tags
+		r.Get("/images", handler.GetImagesFromAlbum)   // GET /albums/123/images
+		r.Get("/tags", handler.GetTagsOfAlbum)         // GET /albums/123/tags
+		r.Get("/children", handler.GetChildrenOfAlbum) // GET /albums/123/children
 	})
 
 	return r
@@ -48,9 +52,14 @@ func (handler Handler) AlbumRouterProtected() chi.Router {
 
 		r.Get("/images", handler.GetImagesFromAlbum)                // GET /admin/albums/123/images
 		r.Delete("/images/{imageID}", handler.RemoveImageFromAlbum) // DELETE /admin/albums/123/images/456
+		r.Put("/order", handler.ReorderImagesOfAlbum)               // PUT /admin/albums/123/order
+		r.Put("/cover", handler.UpdateCoverOfAlbum)                 // PUT /admin/albums/123/cover
+		r.Delete("/cover", handler.ResetCoverOfAlbum)               // DELETE /admin/albums/123/cover
 
 		r.Get("/tags", handler.GetTagsOfAlbum)                // GET /admin/albums/123/tags
 		r.Delete("/tags/{tagID}", handler.RemoveTagFromAlbum) // DELETE /admin/albums/123/tags/789
+
+		r.Get("/children", handler.GetChildrenOfAlbum) // GET /admin/albums/123/children
 	})
 
 	return r
@@ -68,17 +77,76 @@ func (req *AlbumRequest) Bind(r *http.Request) error {
 		return errors.New("missing required Album fields")
 	}
 
+	if len(req.SortMode) == 0 {
+		req.SortMode = cameraroll.SortManual
+	}
+
+	if !cameraroll.ValidSortMode(req.SortMode) {
+		return fmt.Errorf("unknown sort mode [%s]", req.SortMode)
+	}
+
+	if req.Rule != nil {
+		if err := req.Rule.Validate(); err != nil {
+			return err
+		}
+
+		// watermarks come from the albums images were added to
+		if len(req.Watermark) > 0 {
+			return errors.New("smart albums can't override the watermark")
+		}
+	}
+
+	return nil
+}
+
+// AlbumCoverRequest is the request body of pinning the cover of an album
+type AlbumCoverRequest struct {
+	ImageID int64 `json:"image_id"`
+}
+
+// Bind preprocesses the request for some basic error checking
+func (req *AlbumCoverRequest) Bind(r *http.Request) error {
+	if req.ImageID <= 0 {
+		return errors.New("missing image_id")
+	}
+
 	return nil
 }
 
+// AlbumOrderRequest is the request body of reordering the images of an album
+type AlbumOrderRequest struct {
+	ImageIDs []int64 `json:"image_ids"`
+}
+
+// Bind preprocesses the request for some basic error checking
+func (req *AlbumOrderRequest) Bind(r *http.Request) error {
+	if len(req.ImageIDs) == 0 {
+		return errors.New("missing image_ids")
+	}
+
+	return nil
+}
+
+// AlbumCrumb is a step of the breadcrumb path of an album
+type AlbumCrumb struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+}
+
 // AlbumResponse is the response body of albums' CRUD operations
 type AlbumResponse struct {
 	*cameraroll.Album
+	Path []*AlbumCrumb `json:"path,omitempty"` // albums from the top level down to this one
 }
 
 // Render preprocess the response before it's sent to the wire
 func (rsp *AlbumResponse) Render(w http.ResponseWriter, r *http.Request) error {
-	// do nothing
+	if rsp.Album != nil && rsp.Cover != nil {
+		album := *rsp.Album
+		album.Cover = hideLocation(r, album.Cover)
+		rsp.Album = &album
+	}
+
 	return nil
 }
 
@@ -89,6 +157,29 @@ func NewAlbumResponse(album *cameraroll.Album) *AlbumResponse {
 	return &resp
 }
 
+// newAlbumPath turns the albums an album is nested in into its breadcrumb path
+func newAlbumPath(albums []*cameraroll.Album) []*AlbumCrumb {
+	path := []*AlbumCrumb{}
+	for _, album := range albums {
+		path = append(path, &AlbumCrumb{ID: album.ID, Title: album.Title})
+	}
+
+	return path
+}
+
+// newAlbumResponseWithPath looks up the breadcrumb path of an album for its response
+func (handler Handler) newAlbumResponseWithPath(ctx context.Context, album *cameraroll.Album) (*AlbumResponse, error) {
+	albums, err := handler.Service.GetPathOfAlbum(ctx, album.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := NewAlbumResponse(album)
+	rsp.Path = newAlbumPath(albums)
+
+	return rsp, nil
+}
+
 // NewAlbumListResponse is the constructor method for a list of AlbumResponses
 func NewAlbumListResponse(albums []*cameraroll.Album) []render.Renderer {
 	list := []render.Renderer{}
@@ -199,9 +290,92 @@ func (handler Handler) RemoveImageFromAlbum(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// the image goes back to the default mark
+	if len(album.Watermark) > 0 {
+		handler.reprocessImageByID(r.Context(), imageID)
+	}
+
 	render.Status(r, http.StatusOK)
 }
 
+// UpdateCoverOfAlbum pins one of the images of the album in the context as its cover
+func (handler Handler) UpdateCoverOfAlbum(w http.ResponseWriter, r *http.Request) {
+	album := r.Context().Value(albumKey).(*cameraroll.Album)
+
+	coverReq := AlbumCoverRequest{}
+
+	// unmarshal the cover from request
+	if err := render.Bind(r, &coverReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	handler.updateCoverOfAlbum(w, r, album, coverReq.ImageID)
+}
+
+// ResetCoverOfAlbum unpins the cover of the album in the context,
+// falling back to its most recently added image
+func (handler Handler) ResetCoverOfAlbum(w http.ResponseWriter, r *http.Request) {
+	album := r.Context().Value(albumKey).(*cameraroll.Album)
+
+	handler.updateCoverOfAlbum(w, r, album, 0)
+}
+
+// updateCoverOfAlbum saves the cover of an album and renders the album with it
+func (handler Handler) updateCoverOfAlbum(w http.ResponseWriter, r *http.Request, album *cameraroll.Album, imageID int64) {
+	if err := handler.Service.UpdateCoverOfAlbum(r.Context(), album.ID, imageID); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	updated, err := handler.Service.GetAlbumByID(r.Context(), album.ID)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	rsp, err := handler.newAlbumResponseWithPath(r.Context(), updated)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	if err := render.Render(w, r, rsp); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// ReorderImagesOfAlbum puts the images of the album in the context in the order given,
+// switching the album to manual sorting. Images left out follow in their current order.
+func (handler Handler) ReorderImagesOfAlbum(w http.ResponseWriter, r *http.Request) {
+	album := r.Context().Value(albumKey).(*cameraroll.Album)
+
+	orderReq := AlbumOrderRequest{}
+
+	// unmarshal the order from request
+	if err := render.Bind(r, &orderReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	if err := handler.Service.ReorderImagesOfAlbum(r.Context(), album.ID, orderReq.ImageIDs); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	images, err := handler.Service.GetImagesFromAlbum(r.Context(), album.ID)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	if err := render.RenderList(w, r, NewImageListResponse(images)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
 // GetAlbumImages returns all the images from an album
 func (handler Handler) GetImagesFromAlbum(w http.ResponseWriter, r *http.Request) {
 	album := r.Context().Value(albumKey).(*cameraroll.Album)
@@ -218,15 +392,54 @@ func (handler Handler) GetImagesFromAlbum(w http.ResponseWriter, r *http.Request
 	}
 }
 
-// DeleteAlbum removes the album in the context
+// DeleteAlbum removes the album in the context.
+// Its children move up to its parent, unless ?cascade=true deletes them too.
 func (handler Handler) DeleteAlbum(w http.ResponseWriter, r *http.Request) {
 	album := r.Context().Value(albumKey).(*cameraroll.Album)
 
-	if err := handler.Service.DeleteAlbumByID(r.Context(), album.ID); err != nil {
+	cascade := false
+	if param := r.URL.Query().Get(ParamCascade); len(param) > 0 {
+		var err error
+		if cascade, err = strconv.ParseBool(param); err != nil {
+			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("couldn't read %s: %w", ParamCascade, err)))
+			return
+		}
+	}
+
+	deleted := []*cameraroll.Album{album}
+	if cascade {
+		descendants, err := handler.descendantsOfAlbum(r.Context(), album.ID)
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
+		deleted = append(deleted, descendants...)
+	}
+
+	// images of an album overriding the watermark go back to the default mark
+	var images []*cameraroll.Image
+	for _, alb := range deleted {
+		if len(alb.Watermark) == 0 {
+			continue
+		}
+
+		albumImages, err := handler.Service.GetImagesFromAlbum(r.Context(), alb.ID)
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
+		images = append(images, albumImages...)
+	}
+
+	if err := handler.Service.DeleteAlbumByID(r.Context(), album.ID, cascade); err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
 
+	handler.reprocessImages(r.Context(), images)
+
 	render.Status(r, http.StatusOK)
 }
 
@@ -234,7 +447,9 @@ func (handler Handler) DeleteAlbum(w http.ResponseWriter, r *http.Request) {
 func (handler Handler) UpdateAlbum(w http.ResponseWriter, r *http.Request) {
 	album := r.Context().Value(albumKey).(*cameraroll.Album)
 
-	albumReq := AlbumRequest{}
+	// fields left out of the request keep their current values
+	current := *album
+	albumReq := AlbumRequest{&current}
 
 	// unmarshal new album from request
 	if err := render.Bind(r, &albumReq); err != nil {
@@ -242,21 +457,103 @@ func (handler Handler) UpdateAlbum(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// add the new album to database
 	newAlbum := albumReq.Album
+	if !handler.processor.HasWatermark(newAlbum.Watermark) {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("unknown watermark [%s]", newAlbum.Watermark)))
+		return
+	}
+
+	// the mark is burnt into the renditions of the images added to the album,
+	// which are only listed while it's not a smart album
+	reprocess := newAlbum.Watermark != album.Watermark
+	var images []*cameraroll.Image
+	if reprocess && album.Rule == nil {
+		var err error
+		if images, err = handler.Service.GetImagesFromAlbum(r.Context(), album.ID); err != nil {
+			log.Println(err)
+		}
+	}
+
+	// add the new album to database
 	if err := handler.Service.UpdateAlbumByID(r.Context(), album.ID, newAlbum); err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
 
+	if reprocess && album.Rule != nil {
+		var err error
+		if images, err = handler.Service.GetImagesFromAlbum(r.Context(), album.ID); err != nil {
+			log.Println(err)
+		}
+	}
+
+	if reprocess {
+		handler.reprocessImages(r.Context(), images)
+	}
+
 	render.Status(r, http.StatusOK)
 }
 
-// GetAlbum returns the album in the context
+// descendantsOfAlbum finds the albums nested in an album at any depth
+func (handler Handler) descendantsOfAlbum(ctx context.Context, id int64) ([]*cameraroll.Album, error) {
+	descendants := []*cameraroll.Album{}
+
+	for queue := []int64{id}; len(queue) > 0; queue = queue[1:] {
+		children, err := handler.Service.GetChildrenOfAlbum(ctx, queue[0])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, child := range children {
+			descendants = append(descendants, child)
+			queue = append(queue, child.ID)
+		}
+	}
+
+	return descendants, nil
+}
+
+// GetChildrenOfAlbum returns the albums nested directly in the album in the context
+func (handler Handler) GetChildrenOfAlbum(w http.ResponseWriter, r *http.Request) {
+	album := r.Context().Value(albumKey).(*cameraroll.Album)
+
+	children, err := handler.Service.GetChildrenOfAlbum(r.Context(), album.ID)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	ancestors, err := handler.Service.GetPathOfAlbum(r.Context(), album.ID)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// the children share the path of their parent
+	list := []render.Renderer{}
+	for _, child := range children {
+		rsp := NewAlbumResponse(child)
+		rsp.Path = newAlbumPath(append(ancestors[:len(ancestors):len(ancestors)], child))
+		list = append(list, rsp)
+	}
+
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// GetAlbum returns the album in the context along with its breadcrumb path
 func (handler Handler) GetAlbum(w http.ResponseWriter, r *http.Request) {
 	album := r.Context().Value(albumKey).(*cameraroll.Album)
 
-	if err := render.Render(w, r, NewAlbumResponse(album)); err != nil {
+	rsp, err := handler.newAlbumResponseWithPath(r.Context(), album)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	if err := render.Render(w, r, rsp); err != nil {
 		render.Render(w, r, ErrRender(err))
 		return
 	}
@@ -298,8 +595,13 @@ func (handler Handler) AddAlbum(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// add the new album to database
 	album := albumReq.Album
+	if !handler.processor.HasWatermark(album.Watermark) {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("unknown watermark [%s]", album.Watermark)))
+		return
+	}
+
+	// add the new album to database
 	if err := handler.Service.AddAlbum(r.Context(), album); err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
@@ -309,3 +611,23 @@ func (handler Handler) AddAlbum(w http.ResponseWriter, r *http.Request) {
 	render.Status(r, http.StatusOK)
 	render.Render(w, r, NewAlbumResponse(album))
 }
+
+// reprocessImageByID queues an image to be processed again, logging failures
+func (handler Handler) reprocessImageByID(ctx context.Context, imageID int64) {
+	img, err := handler.Service.GetImageByID(ctx, imageID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	handler.reprocessImages(ctx, []*cameraroll.Image{img})
+}
+
+// reprocessImages queues images to be processed again after their watermark changed, logging failures
+func (handler Handler) reprocessImages(ctx context.Context, images []*cameraroll.Image) {
+	for _, img := range images {
+		if err := handler.queueProcessing(ctx, img); err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -66,6 +66,11 @@ func (handler Handler) AddImageToAlbum(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the image may take on the album's mark
+	if album, err := handler.Service.GetAlbumByID(r.Context(), albumImageReq.AlbumID); err == nil && len(album.Watermark) > 0 {
+		handler.reprocessImageByID(r.Context(), albumImageReq.ImageID)
+	}
+
 	// render response
 	render.Status(r, http.StatusOK)
 	render.Render(w, r, NewAlbumImageResponse(albumImageReq.AlbumImage))
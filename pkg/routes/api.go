@@ -16,6 +16,8 @@ const (
 	tagKey
 	imageKey
 	pageIDKey
+	uploadKey
+	hideLocationKey
 )
 
 // ApiRouterProtected contains secured routes that require admin access
@@ -29,6 +31,7 @@ func (handler Handler) ApiRouterProtected() chi.Router {
 	r.Mount("/tags", handler.TagRouterProtected())
 	r.Mount("/images", handler.ImageRouterProtected())
 	r.Mount("/imageTags", handler.ImageTagRouter())
+	r.Mount("/uploads", handler.UploadRouter())
 	r.Mount("/verify", handler.AdminRouter())
 
 	return r
@@ -42,6 +45,8 @@ func (handler Handler) ApiRouter() chi.Router {
 
 	// public routes
 	r.Group(func(r chi.Router) {
+		r.Use(handler.HideLocation)
+
 		r.Mount("/albums", handler.AlbumRouterPublic())
 		r.Mount("/tags", handler.TagRouterPublic())
 		r.Mount("/images", handler.ImageRouterPublic())
@@ -0,0 +1,290 @@
+package routes
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/render"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+)
+
+const (
+	ParamBatchAlbumID = "album_id"
+	ParamBatchTagIDs  = "tag_ids"
+)
+
+// MaxZipEntries is the most files an uploaded ZIP archive may hold
+const MaxZipEntries = 1000
+
+// BatchResult is the outcome of one of the files in a batch upload
+type BatchResult struct {
+	Filename string `json:"filename"`
+	ImageID  int64  `json:"image_id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Render is called by go-chi/render to render the result
+func (rd *BatchResult) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// NewBatchResultListResponse is the constructor method for a list of BatchResult
+func NewBatchResultListResponse(results []*BatchResult) []render.Renderer {
+	list := []render.Renderer{}
+	for _, result := range results {
+		list = append(list, result)
+	}
+
+	return list
+}
+
+// batchOptions is applied to every image of an upload
+type batchOptions struct {
+	title          string
+	description    string
+	allowDuplicate bool
+	album          *cameraroll.Album
+	tagIDs         []int64
+}
+
+// parseBatchOptions reads the album and tags the uploaded images go into, making sure they exist
+func (handler Handler) parseBatchOptions(r *http.Request, options *batchOptions) error {
+	if param := r.Form.Get(ParamBatchAlbumID); len(param) > 0 {
+		albumID, err := strconv.ParseInt(param, ParamNumberBase, ParamNumberBit)
+		if err != nil {
+			return fmt.Errorf("couldn't read %s: %w", ParamBatchAlbumID, err)
+		}
+
+		album, err := handler.Service.GetAlbumByID(r.Context(), albumID)
+		if err != nil {
+			return fmt.Errorf("album [%d] not found", albumID)
+		}
+
+		if album.Rule != nil {
+			return fmt.Errorf("album [%d] is a smart album, its images are picked by its rule", albumID)
+		}
+
+		options.album = album
+	}
+
+	// tag ids may be repeated or comma separated
+	for _, value := range r.Form[ParamBatchTagIDs] {
+		for _, param := range strings.Split(value, ",") {
+			param = strings.TrimSpace(param)
+			if len(param) == 0 {
+				continue
+			}
+
+			tagID, err := strconv.ParseInt(param, ParamNumberBase, ParamNumberBit)
+			if err != nil {
+				return fmt.Errorf("couldn't read %s: %w", ParamBatchTagIDs, err)
+			}
+
+			if _, err := handler.Service.GetTagByID(r.Context(), tagID); err != nil {
+				return fmt.Errorf("tag [%d] not found", tagID)
+			}
+
+			options.tagIDs = append(options.tagIDs, tagID)
+		}
+	}
+
+	return nil
+}
+
+// addImageFile ingests an image into the album and tags of the batch
+func (handler Handler) addImageFile(ctx context.Context, imageFile io.ReadSeeker, filename string, options *batchOptions) (*cameraroll.Image, error) {
+	img := cameraroll.Image{
+		Title:       options.title,
+		Description: options.description,
+	}
+
+	if err := handler.ingestImage(ctx, imageFile, filename, &img, options); err != nil {
+		return nil, err
+	}
+
+	return &img, nil
+}
+
+// addImageToBatch puts a new image in the album and tags of the batch
+func (handler Handler) addImageToBatch(ctx context.Context, img *cameraroll.Image, options *batchOptions) error {
+	if options.album != nil {
+		if err := handler.Service.AddImageToAlbum(ctx, options.album.ID, img.ID); err != nil {
+			return fmt.Errorf("couldn't add image to album [%d]: %w", options.album.ID, err)
+		}
+	}
+
+	for _, tagID := range options.tagIDs {
+		if err := handler.Service.AddTagToImage(ctx, img.ID, tagID); err != nil {
+			return fmt.Errorf("couldn't tag image with [%d]: %w", tagID, err)
+		}
+	}
+
+	return nil
+}
+
+// isZipFile sniffs whether an uploaded file is a ZIP archive
+func isZipFile(file multipart.File) bool {
+	buff := make([]byte, 512)
+	n, _ := file.Read(buff)
+
+	// rewind the file
+	file.Seek(0, io.SeekStart)
+
+	return http.DetectContentType(buff[:n]) == "application/zip"
+}
+
+// addBatch ingests every uploaded file, extracting the images from ZIP archives.
+// A file that fails is reported and the rest carry on.
+func (handler Handler) addBatch(ctx context.Context, files []*multipart.FileHeader, options *batchOptions) []*BatchResult {
+	results := []*BatchResult{}
+
+	// the archives of a request together extract to no more than a batch
+	extractable := handler.limits.MaxBatchSize
+
+	for _, fileHeader := range files {
+		file, err := fileHeader.Open()
+		if err != nil {
+			results = append(results, newBatchResult(fileHeader.Filename, nil, err))
+			continue
+		}
+
+		if isZipFile(file) {
+			results = append(results, handler.addZip(ctx, file, fileHeader, &extractable, options)...)
+		} else {
+			img, err := handler.addImageFile(ctx, file, fileHeader.Filename, options)
+			results = append(results, newBatchResult(fileHeader.Filename, img, err))
+		}
+
+		file.Close()
+	}
+
+	return results
+}
+
+// addZip ingests every image in a ZIP archive,
+// extracting no more than the bytes left in extractable
+func (handler Handler) addZip(ctx context.Context, file multipart.File, fileHeader *multipart.FileHeader, extractable *int64, options *batchOptions) []*BatchResult {
+	archive, err := zip.NewReader(file, fileHeader.Size)
+	if err != nil {
+		return []*BatchResult{newBatchResult(fileHeader.Filename, nil, err)}
+	}
+
+	if len(archive.File) > MaxZipEntries {
+		err := fmt.Errorf("%w: archives are limited to %d files", errImageTooLarge, MaxZipEntries)
+		return []*BatchResult{newBatchResult(fileHeader.Filename, nil, err)}
+	}
+
+	results := []*BatchResult{}
+	for _, entry := range archive.File {
+		name := path.Base(entry.Name)
+
+		// skip folders and the files macOS sneaks into archives
+		if entry.FileInfo().IsDir() || strings.HasPrefix(name, ".") || strings.HasPrefix(entry.Name, "__MACOSX/") {
+			continue
+		}
+
+		img, err := handler.addZipEntry(ctx, entry, extractable, options)
+		results = append(results, newBatchResult(entry.Name, img, err))
+	}
+
+	return results
+}
+
+// addZipEntry extracts an image from a ZIP archive to a temporary file, then ingests it.
+// The extracted bytes are taken out of extractable.
+func (handler Handler) addZipEntry(ctx context.Context, entry *zip.File, extractable *int64, options *batchOptions) (*cameraroll.Image, error) {
+	// the sizes are checked again once extracted, archives may lie about them
+	size := int64(entry.UncompressedSize64)
+	if err := handler.limits.checkFileSize(size); err != nil {
+		return nil, err
+	}
+
+	if err := checkExtractable(size, *extractable, handler.limits.MaxBatchSize); err != nil {
+		return nil, err
+	}
+
+	src, err := entry.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	// ingesting an image needs to seek through it
+	tmp, err := os.CreateTemp("", "cameraroll-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		tmp.Close()
+		if err := os.Remove(tmp.Name()); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	limit := handler.limits.MaxFileSize
+	if *extractable < limit {
+		limit = *extractable
+	}
+
+	extracted, err := io.Copy(tmp, io.LimitReader(src, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkExtractable(extracted, *extractable, handler.limits.MaxBatchSize); err != nil {
+		return nil, err
+	}
+	*extractable -= extracted
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return handler.addImageFile(ctx, tmp, path.Base(entry.Name), options)
+}
+
+// checkExtractable rejects extracting size bytes from an archive
+// when there are only extractable bytes left of a batch
+func checkExtractable(size int64, extractable int64, maxBatchSize int64) error {
+	if size > extractable {
+		return fmt.Errorf("%w: archives are limited to %d bytes once extracted", errImageTooLarge, maxBatchSize)
+	}
+
+	return nil
+}
+
+// newBatchResult reports how adding a file went
+func newBatchResult(filename string, img *cameraroll.Image, err error) *BatchResult {
+	result := BatchResult{Filename: filename}
+
+	if img != nil {
+		result.ImageID = img.ID
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	return &result
+}
+
+// succeeded checks if any file of a batch made it in
+func succeeded(results []*BatchResult) bool {
+	for _, result := range results {
+		if result.ImageID > 0 {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,327 @@
+package routes
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testFile is a file sent in an upload form
+type testFile struct {
+	name string
+	data []byte
+}
+
+// postImages sends files and form fields to AddImage
+func postImages(t *testing.T, handler *Handler, files []testFile, fields map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body := bytes.Buffer{}
+	form := multipart.NewWriter(&body)
+
+	for key, value := range fields {
+		if err := form.WriteField(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, file := range files {
+		part, err := form.CreateFormFile(ParamImageFile, file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := part.Write(file.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := form.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+
+	w := httptest.NewRecorder()
+	handler.AddImage(w, r)
+
+	return w
+}
+
+// testZip archives files into a ZIP
+func testZip(t *testing.T, files []testFile) []byte {
+	t.Helper()
+
+	buf := bytes.Buffer{}
+	archive := zip.NewWriter(&buf)
+
+	for _, file := range files {
+		entry, err := archive.Create(file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := entry.Write(file.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// readBatchResults decodes the outcome of a batch upload
+func readBatchResults(t *testing.T, w *httptest.ResponseRecorder) []BatchResult {
+	t.Helper()
+
+	results := []BatchResult{}
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+
+	return results
+}
+
+func TestAddSingleImage(t *testing.T) {
+	service := newFakeService()
+	handler := newTestHandler(t, service)
+
+	w := postImages(t, handler, []testFile{{"a.png", testPNG(t, 20, 10)}}, map[string]string{ParamImageTitle: "A"})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST = %d %s", w.Code, w.Body)
+	}
+
+	// a single image is answered with the image itself
+	img := ImageResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &img); err != nil {
+		t.Fatal(err)
+	}
+
+	if img.ID != 1 || img.Title != "A" || img.Width != 20 || img.Height != 10 {
+		t.Errorf("image = %s", w.Body)
+	}
+
+	if len(service.jobs) != 1 || service.jobs[0].ImageID != 1 {
+		t.Errorf("jobs = %v, want one for the image", service.jobs)
+	}
+}
+
+func TestAddSeveralImages(t *testing.T) {
+	service := newFakeService()
+	service.albums[3] = true
+	service.tags[5] = true
+	service.tags[6] = true
+	handler := newTestHandler(t, service)
+
+	files := []testFile{
+		{"a.png", testPNG(t, 20, 10)},
+		{"notes.txt", []byte("not an image")},
+		{"b.png", testPNG(t, 10, 20)},
+	}
+	fields := map[string]string{
+		ParamBatchAlbumID: "3",
+		ParamBatchTagIDs:  "5, 6",
+	}
+
+	w := postImages(t, handler, files, fields)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST = %d %s", w.Code, w.Body)
+	}
+
+	// the bad file doesn't stop the rest
+	results := readBatchResults(t, w)
+	if len(results) != 3 {
+		t.Fatalf("results = %+v, want one per file", results)
+	}
+
+	if results[0].Filename != "a.png" || results[0].ImageID == 0 || len(results[0].Error) > 0 {
+		t.Errorf("results[0] = %+v, want added", results[0])
+	}
+
+	if results[1].Filename != "notes.txt" || results[1].ImageID != 0 || len(results[1].Error) == 0 {
+		t.Errorf("results[1] = %+v, want an error", results[1])
+	}
+
+	if results[2].Filename != "b.png" || results[2].ImageID == 0 || len(results[2].Error) > 0 {
+		t.Errorf("results[2] = %+v, want added", results[2])
+	}
+
+	// they're in the album and tagged before they are processed
+	for _, id := range []int64{results[0].ImageID, results[2].ImageID} {
+		if albums := service.queuedAlbums[id]; len(albums) != 1 || albums[0] != 3 {
+			t.Errorf("image [%d] was queued in albums %v, want [3]", id, albums)
+		}
+
+		if tags := service.queuedTags[id]; len(tags) != 2 || tags[0] != 5 || tags[1] != 6 {
+			t.Errorf("image [%d] was queued with tags %v, want [5 6]", id, tags)
+		}
+	}
+
+	if len(service.jobs) != 2 {
+		t.Errorf("%d jobs, want 2", len(service.jobs))
+	}
+}
+
+func TestAddImagesOverTheLimits(t *testing.T) {
+	service := newFakeService()
+	handler := newTestHandler(t, service)
+	handler.limits = UploadLimits{MaxFileSize: 1 << 20, MaxBatchSize: 4 << 20, MaxPixels: 100 * 100}
+
+	// a small file can still decode to too many pixels
+	w := postImages(t, handler, []testFile{{"wide.png", testPNG(t, 200, 60)}}, nil)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("POST of 200x60 pixels = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	handler.limits.MaxFileSize = 64
+	w = postImages(t, handler, []testFile{{"a.png", testPNG(t, 20, 10)}}, nil)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("POST of a large file = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	// images in an archive are held to the same limits
+	handler.limits.MaxFileSize = 1 << 20
+	archive := testZip(t, []testFile{{"wide.png", testPNG(t, 200, 60)}})
+
+	w = postImages(t, handler, []testFile{{"images.zip", archive}}, nil)
+	if results := readBatchResults(t, w); w.Code != http.StatusBadRequest || len(results) != 1 || len(results[0].Error) == 0 {
+		t.Errorf("POST of a ZIP = %d %+v, want the file failed", w.Code, results)
+	}
+
+	// the whole request is cut off past the size of a batch
+	handler.limits.MaxBatchSize = 0
+	large := make([]byte, MaxFormOverhead+1)
+
+	w = postImages(t, handler, []testFile{{"a.png", testPNG(t, 20, 10)}, {"large.png", large}}, nil)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("POST of a large batch = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	if len(service.images) != 0 {
+		t.Errorf("%d images were added", len(service.images))
+	}
+}
+
+func TestAddZipOverTheLimits(t *testing.T) {
+	service := newFakeService()
+	handler := newTestHandler(t, service)
+
+	files := []testFile{}
+	for i := 0; i <= MaxZipEntries; i++ {
+		files = append(files, testFile{fmt.Sprintf("%d.txt", i), nil})
+	}
+
+	w := postImages(t, handler, []testFile{{"many.zip", testZip(t, files)}}, nil)
+	if results := readBatchResults(t, w); w.Code != http.StatusBadRequest || len(results) != 1 || len(results[0].Error) == 0 {
+		t.Errorf("POST of %d files = %d %+v, want the archive failed", len(files), w.Code, results)
+	}
+
+	// what's extracted counts against the size of the batch
+	data := testPNG(t, 20, 10)
+	handler.limits.MaxBatchSize = int64(2*len(data) + len(data)/2)
+	archive := testZip(t, []testFile{{"a.png", data}, {"b.png", data}, {"c.png", data}})
+
+	w = postImages(t, handler, []testFile{{"images.zip", archive}}, nil)
+	results := readBatchResults(t, w)
+	if w.Code != http.StatusAccepted || len(results) != 3 {
+		t.Fatalf("POST = %d %+v", w.Code, results)
+	}
+
+	if results[0].ImageID == 0 || results[1].ImageID == 0 || results[2].ImageID != 0 || len(results[2].Error) == 0 {
+		t.Errorf("results = %+v, want the last image over the limit", results)
+	}
+}
+
+func TestAddImagesToMissingAlbum(t *testing.T) {
+	service := newFakeService()
+	handler := newTestHandler(t, service)
+
+	w := postImages(t, handler, []testFile{{"a.png", testPNG(t, 20, 10)}}, map[string]string{ParamBatchAlbumID: "3"})
+	if w.Code != http.StatusBadRequest || len(service.images) != 0 {
+		t.Errorf("POST = %d, %d images, want nothing added", w.Code, len(service.images))
+	}
+
+	w = postImages(t, handler, []testFile{{"a.png", testPNG(t, 20, 10)}}, map[string]string{ParamBatchTagIDs: "x"})
+	if w.Code != http.StatusBadRequest || len(service.images) != 0 {
+		t.Errorf("POST = %d, %d images, want nothing added", w.Code, len(service.images))
+	}
+}
+
+func TestAddImageToMissingTag(t *testing.T) {
+	service := newFakeService()
+	handler := newTestHandler(t, service)
+
+	// the tag is gone by the time the image is added
+	options := batchOptions{allowDuplicate: true, tagIDs: []int64{5}}
+	if _, err := handler.addImageFile(context.Background(), bytes.NewReader(testPNG(t, 20, 10)), "a.png", &options); err == nil {
+		t.Error("added an image with a missing tag")
+	}
+
+	if len(service.images) != 0 || len(service.jobs) != 0 {
+		t.Errorf("%d images and %d jobs, want none", len(service.images), len(service.jobs))
+	}
+}
+
+func TestAddZipOfImages(t *testing.T) {
+	service := newFakeService()
+	handler := newTestHandler(t, service)
+
+	archive := testZip(t, []testFile{
+		{"holiday/a.png", testPNG(t, 20, 10)},
+		{"holiday/.DS_Store", []byte("junk")},
+		{"__MACOSX/holiday/._a.png", []byte("junk")},
+		{"holiday/b.png", testPNG(t, 10, 20)},
+		{"holiday/broken.png", []byte("not an image")},
+	})
+
+	w := postImages(t, handler, []testFile{{"holiday.zip", archive}}, nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST = %d %s", w.Code, w.Body)
+	}
+
+	// hidden files are skipped, the rest reported by their path in the archive
+	results := readBatchResults(t, w)
+	if len(results) != 3 {
+		t.Fatalf("results = %+v, want 3", results)
+	}
+
+	for i, want := range []string{"holiday/a.png", "holiday/b.png", "holiday/broken.png"} {
+		if results[i].Filename != want {
+			t.Errorf("results[%d] is %s, want %s", i, results[i].Filename, want)
+		}
+	}
+
+	if results[0].ImageID == 0 || results[1].ImageID == 0 || len(results[2].Error) == 0 {
+		t.Errorf("results = %+v, want both images added and the broken one failed", results)
+	}
+
+	if len(service.images) != 2 || len(service.jobs) != 2 {
+		t.Errorf("%d images and %d jobs, want 2 of each", len(service.images), len(service.jobs))
+	}
+}
+
+func TestAddZipWithoutImages(t *testing.T) {
+	service := newFakeService()
+	handler := newTestHandler(t, service)
+
+	archive := testZip(t, []testFile{{"readme.txt", []byte("nothing to see")}})
+
+	w := postImages(t, handler, []testFile{{"empty.zip", archive}}, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("POST = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	if results := readBatchResults(t, w); len(results) != 1 || len(results[0].Error) == 0 {
+		t.Errorf("results = %+v, want the file failed", results)
+	}
+}
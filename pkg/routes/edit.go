@@ -0,0 +1,141 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+)
+
+// largest straightening angle in degrees, either way
+const maxStraighten = 45
+
+// EditRequest is the request payload for the Edits data model
+type EditRequest struct {
+	*cameraroll.Edits
+}
+
+// Bind preprocesses the request for some basic error checking
+func (req *EditRequest) Bind(r *http.Request) error {
+	// Return an error to avoid a nil pointer dereference.
+	if req.Edits == nil {
+		return errors.New("missing required Edits fields")
+	}
+
+	switch req.Rotation {
+	case 0, 90, 180, 270:
+	default:
+		return fmt.Errorf("rotation [%d] must be 0, 90, 180 or 270", req.Rotation)
+	}
+
+	if req.Straighten < -maxStraighten || req.Straighten > maxStraighten {
+		return fmt.Errorf("straighten [%g] must be between %d and %d", req.Straighten, -maxStraighten, maxStraighten)
+	}
+
+	if crop := req.Crop; crop != nil {
+		if crop.X < 0 || crop.Y < 0 || crop.Width <= 0 || crop.Height <= 0 || crop.X+crop.Width > 1 || crop.Y+crop.Height > 1 {
+			return errors.New("crop must be a rectangle within 0 and 1")
+		}
+	}
+
+	return nil
+}
+
+// EditResponse is the response payload for the Edits data model
+type EditResponse struct {
+	*cameraroll.Edits
+}
+
+// Render preprocess the response before it's sent to the wire
+func (rsp *EditResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	// do nothing
+	return nil
+}
+
+// NewEditResponse is the constructor method for the EditResponse type
+func NewEditResponse(edits *cameraroll.Edits) *EditResponse {
+	return &EditResponse{Edits: edits}
+}
+
+// GetImageEdits returns the edits of the image in the context,
+// all zero if it's shown as it was uploaded
+func (handler Handler) GetImageEdits(w http.ResponseWriter, r *http.Request) {
+	image := r.Context().Value(imageKey).(*cameraroll.Image)
+
+	edits, err := handler.Service.GetEditsOfImage(r.Context(), image.ID)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	if edits == nil {
+		edits = &cameraroll.Edits{ImageID: image.ID}
+	}
+
+	if err := render.Render(w, r, NewEditResponse(edits)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// UpdateImageEdits replaces the edits of the image in the context,
+// queueing its thumbnail and renditions to be rendered again
+func (handler Handler) UpdateImageEdits(w http.ResponseWriter, r *http.Request) {
+	image := r.Context().Value(imageKey).(*cameraroll.Image)
+
+	editReq := EditRequest{}
+
+	// unmarshal the edits from request
+	if err := render.Bind(r, &editReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	if err := handler.Service.UpdateEditsOfImage(r.Context(), image.ID, editReq.Edits); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// copies resized on the fly were cut from the old edits
+	handler.resizer.Purge(image.ID)
+
+	if err := handler.queueProcessing(r.Context(), image); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// read the edits back for the time they were saved at
+	edits, err := handler.Service.GetEditsOfImage(r.Context(), image.ID)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+
+	render.Status(r, http.StatusAccepted)
+	render.Render(w, r, NewEditResponse(edits))
+}
+
+// ResetImageEdits drops the edits of the image in the context,
+// queueing its thumbnail and renditions to be rendered from the original as it is
+func (handler Handler) ResetImageEdits(w http.ResponseWriter, r *http.Request) {
+	image := r.Context().Value(imageKey).(*cameraroll.Image)
+
+	if err := handler.Service.DeleteEditsOfImage(r.Context(), image.ID); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// copies resized on the fly were cut from the old edits
+	handler.resizer.Purge(image.ID)
+
+	if err := handler.queueProcessing(r.Context(), image); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	render.Status(r, http.StatusAccepted)
+	render.Render(w, r, NewImageResponse(image))
+}
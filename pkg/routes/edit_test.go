@@ -0,0 +1,56 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+	"chujungeng/camera-roll/pkg/imaging"
+	"chujungeng/camera-roll/pkg/storage"
+)
+
+func TestUpdateImageEdits(t *testing.T) {
+	service := newFakeService()
+	handler := newTestHandler(t, service)
+
+	cache, err := storage.NewDiskCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.resizer = imaging.NewResizer(handler.processor, cache, nil)
+
+	img := cameraroll.Image{ProcessingStatus: cameraroll.ProcessingReady}
+	if err := service.AddImage(context.Background(), &img); err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"rotation": 90, "crop": {"x": 0, "y": 0, "width": 0.5, "height": 0.5}}`)
+	r := httptest.NewRequest(http.MethodPut, "/", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r = r.WithContext(context.WithValue(r.Context(), imageKey, &img))
+
+	w := httptest.NewRecorder()
+	handler.UpdateImageEdits(w, r)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("PUT = %d %s", w.Code, w.Body)
+	}
+
+	// the response carries the edits as they were saved
+	edits := cameraroll.Edits{}
+	if err := json.Unmarshal(w.Body.Bytes(), &edits); err != nil {
+		t.Fatal(err)
+	}
+
+	if edits.ImageID != img.ID || edits.Rotation != 90 || edits.Crop == nil || edits.UpdatedAt.IsZero() {
+		t.Errorf("edits = %s, want them saved with their time", w.Body)
+	}
+
+	if len(service.jobs) != 1 || service.images[img.ID].ProcessingStatus != cameraroll.ProcessingPending {
+		t.Errorf("%d jobs, image is %s, want it queued", len(service.jobs), service.images[img.ID].ProcessingStatus)
+	}
+}
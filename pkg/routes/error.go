@@ -38,6 +38,42 @@ func ErrUnauthorized(err error) render.Renderer {
 	}
 }
 
+func ErrConflict(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusConflict,
+		StatusText:     "Conflict",
+		ErrorText:      err.Error(),
+	}
+}
+
+func ErrPreconditionFailed(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusPreconditionFailed,
+		StatusText:     "Precondition Failed",
+		ErrorText:      err.Error(),
+	}
+}
+
+func ErrRequestTooLarge(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusRequestEntityTooLarge,
+		StatusText:     "Request Entity Too Large",
+		ErrorText:      err.Error(),
+	}
+}
+
+func ErrUnsupportedMediaType(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusUnsupportedMediaType,
+		StatusText:     "Unsupported Media Type",
+		ErrorText:      err.Error(),
+	}
+}
+
 func ErrRender(err error) render.Renderer {
 	return &ErrResponse{
 		Err:            err,
@@ -1,22 +1,33 @@
 package routes
 
 import (
+	"context"
 	"errors"
+	"io"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 
-	"chujungeng/camera-roll/pkg/url"
+	"chujungeng/camera-roll/pkg/cameraroll"
+	"chujungeng/camera-roll/pkg/imaging"
+	"chujungeng/camera-roll/pkg/storage"
 )
 
 const (
 	staticFileFolder  = "public"
 	clientFileFolder  = "client"
 	deletedFileFolder = "deleted"
+	cacheFileFolder   = "cache"
+	privateFileFolder = "private"
+	uploadFileFolder  = "uploads"
 	staticFileURL     = "/assets/"
 )
 
@@ -29,7 +40,7 @@ func fileDirectoryPath(dirname string) string {
 
 	fileDirPath := filepath.Join(exPath, dirname)
 	if _, err := os.Stat(fileDirPath); errors.Is(err, os.ErrNotExist) {
-		err := os.Mkdir(fileDirPath, os.ModePerm)
+		err := os.MkdirAll(fileDirPath, os.ModePerm)
 		if err != nil {
 			panic(err)
 		}
@@ -50,43 +61,100 @@ func DeletedFileDir() string {
 	return fileDirectoryPath(deletedFileFolder)
 }
 
+func CacheFileDir() string {
+	return fileDirectoryPath(cacheFileFolder)
+}
+
+func PrivateFileDir() string {
+	return fileDirectoryPath(privateFileFolder)
+}
+
+func DeletedPrivateFileDir() string {
+	return fileDirectoryPath(filepath.Join(deletedFileFolder, privateFileFolder))
+}
+
+func UploadFileDir() string {
+	return fileDirectoryPath(uploadFileFolder)
+}
+
 func ClientFileDir() string {
 	return fileDirectoryPath(clientFileFolder)
 }
 
-func DeleteAssetFromFilesystem(assetURL string) {
-	const (
-		urlDelimiter = "/"
-	)
-
-	relPath := url.GetPathFromURL(assetURL)
-	if len(relPath) == 0 {
+// deleteAsset moves the asset at assetURL out of the AssetStore
+func (handler Handler) deleteAsset(ctx context.Context, assetURL string) {
+	key := storage.KeyFromURL(assetURL, staticFileURL)
+	if len(key) == 0 {
 		return
 	}
 
-	elements := strings.Split(relPath, urlDelimiter)
-	if len(elements) == 0 {
+	if err := handler.store.Delete(ctx, key); err != nil {
+		log.Println(err)
 		return
 	}
+}
+
+// AssetFileSystem serves the contents of an AssetStore as a http.FileSystem
+type AssetFileSystem struct {
+	Store cameraroll.AssetStore
+}
 
-	file := elements[len(elements)-1]
+func (m AssetFileSystem) Open(name string) (http.File, error) {
+	key := strings.TrimPrefix(path.Clean(name), "/")
+	if len(key) == 0 {
+		return nil, os.ErrNotExist
+	}
 
-	absPath := filepath.Join(StaticFileDir(), file)
-	newPath := filepath.Join(DeletedFileDir(), file)
+	info, err := m.Store.Stat(context.Background(), key)
+	if errors.Is(err, cameraroll.ErrAssetNotFound) {
+		return nil, os.ErrNotExist
+	} else if err != nil {
+		return nil, err
+	}
 
-	if err := os.Rename(absPath, newPath); err != nil {
-		log.Println(err)
-		return
+	f, err := m.Store.Get(context.Background(), key)
+	if errors.Is(err, cameraroll.ErrAssetNotFound) {
+		return nil, os.ErrNotExist
+	} else if err != nil {
+		return nil, err
 	}
+
+	return &assetFile{ReadSeekCloser: f, info: info}, nil
+}
+
+// assetFile adapts an asset from the AssetStore to the http.File interface
+type assetFile struct {
+	io.ReadSeekCloser
+	info *cameraroll.AssetInfo
+}
+
+func (f *assetFile) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, errors.New("not a directory")
+}
+
+func (f *assetFile) Stat() (fs.FileInfo, error) {
+	return assetFileInfo{f.info}, nil
 }
 
+// assetFileInfo adapts AssetInfo to the fs.FileInfo interface
+type assetFileInfo struct {
+	*cameraroll.AssetInfo
+}
+
+func (fi assetFileInfo) Name() string       { return path.Base(fi.Key) }
+func (fi assetFileInfo) Size() int64        { return fi.AssetInfo.Size }
+func (fi assetFileInfo) Mode() fs.FileMode  { return 0444 }
+func (fi assetFileInfo) ModTime() time.Time { return fi.AssetInfo.ModTime }
+func (fi assetFileInfo) IsDir() bool        { return false }
+func (fi assetFileInfo) Sys() interface{}   { return nil }
+
 // FsWithoutDirListing is Go's filesystem with directory listing turned off
 type FsWithoutDirListing struct {
-	http.Dir
+	http.FileSystem
 }
 
 func (m FsWithoutDirListing) Open(name string) (result http.File, err error) {
-	f, err := m.Dir.Open(name)
+	f, err := m.FileSystem.Open(name)
 	if err != nil {
 		return
 	}
@@ -97,15 +165,86 @@ func (m FsWithoutDirListing) Open(name string) (result http.File, err error) {
 	}
 	if fi.IsDir() {
 		// Return a response that would have been if directory would not exist:
-		return m.Dir.Open("does-not-exist")
+		return m.FileSystem.Open("does-not-exist")
 	}
 
 	return f, nil
 }
 
+// formats served instead of JPEG or PNG to the browsers that accept them, the preferred one first.
+// AVIF can't be encoded, it's only served if a copy was saved to the store.
+var negotiatedFormats = []string{imaging.FormatAVIF, imaging.FormatWebP}
+
+// NegotiateFormat serves an AVIF or WebP copy of a JPEG or PNG asset to browsers that accept it,
+// if there's one or it can be converted. The asset itself is the fallback.
+func (handler Handler) NegotiateFormat(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(r.URL.Path, staticFileURL)), "/")
+
+		if _, ok := imaging.FormatFromExtension(strings.TrimPrefix(path.Ext(key), ".")); !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// the response depends on the Accept header, caches have to know
+		w.Header().Add("Vary", "Accept")
+
+		accept := r.Header.Get("Accept")
+		for _, format := range negotiatedFormats {
+			if !accepts(accept, imaging.MimeType(format)) {
+				continue
+			}
+
+			variant, modTime, err := handler.converter.Variant(r.Context(), key, format)
+			if errors.Is(err, cameraroll.ErrAssetNotFound) {
+				break
+			} else if err != nil {
+				if !errors.Is(err, imaging.ErrNoVariant) {
+					log.Println(err)
+				}
+				continue
+			}
+			defer variant.Close()
+
+			w.Header().Set("Content-Type", imaging.MimeType(format))
+			http.ServeContent(w, r, key, modTime, variant)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// accepts checks if an Accept header lists a MIME type explicitly, without a quality of 0.
+// Wildcards like image/* and */* don't count since browsers send them along for formats they can't decode,
+// a client asking for any image gets the asset itself.
+func accepts(accept string, mimeType string) bool {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(mediaRange, ";")
+		if !strings.EqualFold(strings.TrimSpace(mediaType), mimeType) {
+			continue
+		}
+
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if name != "q" {
+				continue
+			}
+
+			if q, err := strconv.ParseFloat(value, 64); err == nil && q == 0 {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
 // FileServer conveniently sets up a http.FileServer handler to serve
 // static files from a http.FileSystem.
-func FileServer(r chi.Router, path string, root http.Dir) {
+func FileServer(r chi.Router, path string, root http.FileSystem) {
 	if strings.ContainsAny(path, "{}*") {
 		panic("FileServer does not permit any URL parameters.")
 	}
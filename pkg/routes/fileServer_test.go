@@ -0,0 +1,84 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"chujungeng/camera-roll/pkg/imaging"
+	"chujungeng/camera-roll/pkg/storage"
+)
+
+func TestAccepts(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"image/avif,image/webp,*/*;q=0.8", true},
+		{"text/html, IMAGE/WEBP;q=0.9", true},
+		{"image/webp;q=0", false},
+		{"image/*,*/*;q=0.8", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if got := accepts(test.accept, "image/webp"); got != test.want {
+			t.Errorf("accepts(%q, image/webp) = %t, want %t", test.accept, got, test.want)
+		}
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	handler := newTestHandler(t, newFakeService())
+
+	cache, err := storage.NewDiskCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.converter = imaging.NewConverter(handler.store, cache, 64)
+
+	ctx := context.Background()
+	avif := []byte("a stored AVIF copy")
+	for key, data := range map[string][]byte{"a.png": testPNG(t, 20, 10), "a.avif": avif, "b.png": testPNG(t, 20, 10)} {
+		if err := handler.store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), imaging.MimeType(imaging.FormatPNG)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	routes := handler.NegotiateFormat(http.FileServer(AssetFileSystem{handler.store}))
+
+	get := func(target string, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, r)
+
+		return w
+	}
+
+	// a stored AVIF copy is preferred
+	w := get("/a.png", "image/avif,image/webp,*/*")
+	if w.Header().Get("Content-Type") != "image/avif" || !bytes.Equal(w.Body.Bytes(), avif) || w.Header().Get("Vary") != "Accept" {
+		t.Errorf("GET a.png = %d %v, want the AVIF copy", w.Code, w.Header())
+	}
+
+	// there's none of the other asset, and AVIF isn't encoded on the fly
+	w = get("/b.png", "image/avif,image/webp,*/*")
+	if w.Header().Get("Content-Type") != "image/webp" {
+		t.Errorf("GET b.png = %d %v, want a WebP conversion", w.Code, w.Header())
+	}
+
+	w = get("/b.png", "image/avif,*/*")
+	if w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("GET b.png = %d %v, want the PNG itself", w.Code, w.Header())
+	}
+
+	// wildcards get the asset itself
+	w = get("/a.png", "image/*")
+	if data, _ := io.ReadAll(w.Body); w.Header().Get("Content-Type") != "image/png" || bytes.Equal(data, avif) {
+		t.Errorf("GET a.png = %d %v, want the PNG itself", w.Code, w.Header())
+	}
+}
@@ -10,12 +10,22 @@ import (
 	"golang.org/x/oauth2"
 
 	"chujungeng/camera-roll/pkg/cameraroll"
+	"chujungeng/camera-roll/pkg/imaging"
+	"chujungeng/camera-roll/pkg/worker"
 )
 
 // Handler handles all API requests to camera roll
 type Handler struct {
 	Service cameraroll.Service
 
+	store             cameraroll.AssetStore
+	processor         *imaging.Processor
+	resizer           *imaging.Resizer
+	converter         *imaging.Converter
+	workers           *worker.Pool
+	uploads           *uploadStore
+	limits            UploadLimits
+	duplicateDistance int
 	rootURL           string
 	corsOrigin        []string
 	jwtTokenAuth      *jwtauth.JWTAuth
@@ -24,9 +34,17 @@ type Handler struct {
 }
 
 // NewHandler is the contructor method for the Handler
-func NewHandler(service cameraroll.Service, rootURL string, corsOrigin []string, jwtSecret string, admin string, oauthGoogleConfig *oauth2.Config) *Handler {
+func NewHandler(service cameraroll.Service, store cameraroll.AssetStore, processor *imaging.Processor, resizer *imaging.Resizer, converter *imaging.Converter, workers *worker.Pool, limits UploadLimits, duplicateDistance int, rootURL string, corsOrigin []string, jwtSecret string, admin string, oauthGoogleConfig *oauth2.Config) *Handler {
 	handler := Handler{
 		Service:           service,
+		store:             store,
+		processor:         processor,
+		resizer:           resizer,
+		converter:         converter,
+		workers:           workers,
+		uploads:           newUploadStore(UploadFileDir()),
+		limits:            limits,
+		duplicateDistance: duplicateDistance,
 		rootURL:           rootURL,
 		corsOrigin:        corsOrigin,
 		jwtTokenAuth:      jwtauth.New("HS256", []byte(jwtSecret), nil),
@@ -48,18 +66,21 @@ func (handler Handler) Routes() http.Handler {
 	r.Use(cors.Handler(cors.Options{
 		// AllowedOrigins:   []string{"https://foo.com"}, // Use this to allow specific origin hosts
 		AllowedOrigins:   handler.corsOrigin,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "HEAD", "PATCH"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata"},
+		ExposedHeaders:   []string{"Link", "Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Offset", "Upload-Length", "Upload-Expires"},
 		AllowCredentials: false,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}))
 
 	RootServer(r)
 
+	// Serve resized copies of the originals along /assets
+	r.Get(ResizedImageURL(), handler.GetResizedImage)
+
 	// Create a route along /assets that will serve contents from
-	// the ./public/ folder.
-	FileServer(r, StaticAssetURL(), http.Dir(StaticFileDir()))
+	// the asset store, in the best image format the browser accepts.
+	FileServer(r.With(handler.NegotiateFormat), StaticAssetURL(), AssetFileSystem{handler.store})
 
 	r.Mount("/api/", handler.ApiRouter())
 	r.Mount("/auth/", handler.AuthRouter())
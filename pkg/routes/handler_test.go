@@ -0,0 +1,228 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"sync"
+	"testing"
+	"time"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+	"chujungeng/camera-roll/pkg/imaging"
+	"chujungeng/camera-roll/pkg/storage"
+	"chujungeng/camera-roll/pkg/url"
+	"chujungeng/camera-roll/pkg/worker"
+)
+
+// root URL of the server in the tests
+const testRootURL = "http://localhost:9648/"
+
+// fakeService keeps images and jobs in memory, standing in for the database.
+// Only the methods the tested handlers use are implemented.
+type fakeService struct {
+	cameraroll.Service
+
+	mu          sync.Mutex
+	images      map[int64]*cameraroll.Image
+	jobs        []*cameraroll.Job
+	albums      map[int64]bool
+	tags        map[int64]bool
+	imageAlbums map[int64][]int64
+	imageTags   map[int64][]int64
+	edits       map[int64]*cameraroll.Edits
+
+	// returned by AddImage, standing in for the database going away
+	addImageErr error
+
+	// the albums and tags of images by the time their jobs were queued
+	queuedAlbums map[int64][]int64
+	queuedTags   map[int64][]int64
+}
+
+func newFakeService() *fakeService {
+	service := fakeService{
+		images:      map[int64]*cameraroll.Image{},
+		albums:      map[int64]bool{},
+		tags:        map[int64]bool{},
+		imageAlbums: map[int64][]int64{},
+		imageTags:   map[int64][]int64{},
+		edits:       map[int64]*cameraroll.Edits{},
+
+		queuedAlbums: map[int64][]int64{},
+		queuedTags:   map[int64][]int64{},
+	}
+
+	return &service
+}
+
+func (service *fakeService) AddImage(ctx context.Context, img *cameraroll.Image) error {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	if service.addImageErr != nil {
+		return service.addImageErr
+	}
+
+	img.ID = int64(len(service.images) + 1)
+	added := *img
+	service.images[img.ID] = &added
+
+	return nil
+}
+
+func (service *fakeService) DeleteImageByID(ctx context.Context, id int64) error {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	delete(service.images, id)
+
+	return nil
+}
+
+func (service *fakeService) GetDuplicatesOfImage(ctx context.Context, id int64, hash uint64, maxDistance int) ([]*cameraroll.Duplicate, error) {
+	return nil, nil
+}
+
+func (service *fakeService) AddJob(ctx context.Context, job *cameraroll.Job) error {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	job.ID = int64(len(service.jobs) + 1)
+	job.Status = cameraroll.JobQueued
+	queued := *job
+	service.jobs = append(service.jobs, &queued)
+	service.queuedAlbums[job.ImageID] = append([]int64{}, service.imageAlbums[job.ImageID]...)
+	service.queuedTags[job.ImageID] = append([]int64{}, service.imageTags[job.ImageID]...)
+
+	return nil
+}
+
+func (service *fakeService) GetAlbumByID(ctx context.Context, id int64) (*cameraroll.Album, error) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	if !service.albums[id] {
+		return nil, fmt.Errorf("GetAlbumByID [%d]: not found", id)
+	}
+
+	return &cameraroll.Album{ID: id}, nil
+}
+
+func (service *fakeService) GetTagByID(ctx context.Context, id int64) (*cameraroll.Tag, error) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	if !service.tags[id] {
+		return nil, fmt.Errorf("GetTagByID [%d]: not found", id)
+	}
+
+	return &cameraroll.Tag{ID: id}, nil
+}
+
+func (service *fakeService) AddImageToAlbum(ctx context.Context, albumID int64, imageID int64) error {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	service.imageAlbums[imageID] = append(service.imageAlbums[imageID], albumID)
+
+	return nil
+}
+
+func (service *fakeService) AddTagToImage(ctx context.Context, imageID int64, tagID int64) error {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	if !service.tags[tagID] {
+		return fmt.Errorf("AddTagToImage [%d]: tag [%d] not found", imageID, tagID)
+	}
+
+	service.imageTags[imageID] = append(service.imageTags[imageID], tagID)
+
+	return nil
+}
+
+func (service *fakeService) UpdateProcessingStatusOfImage(ctx context.Context, id int64, status string) error {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	if img, exists := service.images[id]; exists {
+		img.ProcessingStatus = status
+	}
+
+	return nil
+}
+
+func (service *fakeService) GetEditsOfImage(ctx context.Context, imageID int64) (*cameraroll.Edits, error) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	edits, exists := service.edits[imageID]
+	if !exists {
+		return nil, nil
+	}
+
+	stored := *edits
+	return &stored, nil
+}
+
+func (service *fakeService) UpdateEditsOfImage(ctx context.Context, imageID int64, edits *cameraroll.Edits) error {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	stored := *edits
+	stored.ImageID = imageID
+	stored.UpdatedAt = time.Now()
+	service.edits[imageID] = &stored
+
+	edits.ImageID = imageID
+
+	return nil
+}
+
+// newTestHandler sets up a handler keeping its assets and uploads in temporary folders
+func newTestHandler(t *testing.T, service *fakeService) *Handler {
+	t.Helper()
+
+	store := storage.NewLocalStore(t.TempDir(), t.TempDir())
+	originals := storage.NewLocalStore(t.TempDir(), t.TempDir())
+	processor := imaging.NewProcessor(store, originals, url.Join(testRootURL, StaticAssetURL()), imaging.Options{
+		ThumbnailSize:   16,
+		RenditionWidths: []int{32},
+		MetadataPolicy:  imaging.MetadataStripAll,
+	})
+
+	// the workers aren't started, the jobs are only queued
+	handler := Handler{
+		Service:   service,
+		store:     store,
+		processor: processor,
+		workers:   worker.NewPool(service, processor, 1, 0),
+		uploads:   newUploadStore(t.TempDir()),
+		limits:    UploadLimits{MaxFileSize: 1 << 20, MaxBatchSize: 4 << 20, MaxPixels: 1 << 16},
+		rootURL:   testRootURL,
+	}
+
+	return &handler
+}
+
+// testPNG encodes a small PNG of the given size
+func testPNG(t *testing.T, width int, height int) []byte {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	img.Set(0, 0, color.NRGBA{R: 255, A: 255})
+
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
@@ -4,21 +4,20 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"image/jpeg"
 	"io"
-	"mime/multipart"
+	"log"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
 	"strconv"
 	"strings"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
 	"github.com/google/uuid"
-	"github.com/nfnt/resize"
 
 	"chujungeng/camera-roll/pkg/cameraroll"
+	"chujungeng/camera-roll/pkg/imaging"
+	"chujungeng/camera-roll/pkg/storage"
 	"chujungeng/camera-roll/pkg/url"
 )
 
@@ -27,12 +26,61 @@ const (
 	ParamImageTitle       = "title"
 	ParamImageDescription = "description"
 	ParamImageFile        = "image"
+	ParamAllowDuplicate   = "allow_duplicate"
 )
 
 const (
+	// size of uploaded forms kept in memory, the rest goes to temporary files
 	MaxImageSize = 2 << 20
+
+	// room for the fields and part headers of a multipart form on top of its files
+	MaxFormOverhead = 1 << 20
 )
 
+// UploadLimits protects the server from uploads too large to store or decode
+type UploadLimits struct {
+	MaxFileSize  int64 // in bytes
+	MaxBatchSize int64 // in bytes, all the files of a request together
+	MaxPixels    int64 // width times height
+}
+
+// errImageTooLarge is returned when an upload is over the UploadLimits
+var errImageTooLarge = errors.New("image is too large")
+
+// checkFileSize rejects files over the maximum size
+func (limits UploadLimits) checkFileSize(size int64) error {
+	if size > limits.MaxFileSize {
+		return fmt.Errorf("%w: files are limited to %d bytes", errImageTooLarge, limits.MaxFileSize)
+	}
+
+	return nil
+}
+
+// parseUploadForm parses a multipart form of at most maxSize bytes of files,
+// refusing to read any further than that from the request
+func parseUploadForm(w http.ResponseWriter, r *http.Request, maxSize int64) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+MaxFormOverhead)
+
+	err := r.ParseMultipartForm(MaxImageSize)
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return fmt.Errorf("%w: requests are limited to %d bytes", errImageTooLarge, tooLarge.Limit)
+	}
+
+	return err
+}
+
+// checkPixels rejects images with more pixels than the maximum,
+// decoding them would take up too much memory
+func (limits UploadLimits) checkPixels(width int, height int) error {
+	if int64(width)*int64(height) > limits.MaxPixels {
+		return fmt.Errorf("%w: %dx%d is over the limit of %d pixels", errImageTooLarge, width, height, limits.MaxPixels)
+	}
+
+	return nil
+}
+
 // ImageRouterPublic specifies all the public routes related to images
 func (handler Handler) ImageRouterPublic() chi.Router {
 	r := chi.NewRouter()
@@ -62,6 +110,15 @@ func (handler Handler) ImageRouterProtected() chi.Router {
 		r.Put("/", handler.UpdateImage)    // PUT /admin/images/123
 		r.Delete("/", handler.DeleteImage) // DELETE /admin/images/123
 
+		r.Get("/original", handler.GetOriginalImage)     // GET /admin/images/123/original
+		r.Get("/duplicates", handler.GetImageDuplicates) // GET /admin/images/123/duplicates
+		r.Post("/reprocess", handler.ReprocessImage)     // POST /admin/images/123/reprocess
+		r.Put("/file", handler.ReplaceImageFile)         // PUT /admin/images/123/file
+
+		r.Get("/edits", handler.GetImageEdits)      // GET /admin/images/123/edits
+		r.Put("/edits", handler.UpdateImageEdits)   // PUT /admin/images/123/edits
+		r.Delete("/edits", handler.ResetImageEdits) // DELETE /admin/images/123/edits
+
 		r.Delete("/tags/{tagID}", handler.RemoveTagFromImage) // DELETE /admin/images/123/tags/789
 
 		r.Get("/albums", handler.GetImageAlbums) // GET /admin/images/123/albums
@@ -83,6 +140,10 @@ func (req *ImageRequest) Bind(r *http.Request) error {
 		return errors.New("missing required Image fields")
 	}
 
+	if req.FocalX < 0 || req.FocalX > 1 || req.FocalY < 0 || req.FocalY > 1 {
+		return fmt.Errorf("focal point [%g, %g] must be within 0 and 1", req.FocalX, req.FocalY)
+	}
+
 	return nil
 }
 
@@ -93,10 +154,38 @@ type ImageResponse struct {
 
 // Render preprocess the response before it's sent to the wire
 func (rsp *ImageResponse) Render(w http.ResponseWriter, r *http.Request) error {
-	// do nothing
+	rsp.Image = hideLocation(r, rsp.Image)
+
 	return nil
 }
 
+// HideLocation middleware keeps the GPS coordinates of images out of the responses,
+// unless the metadata policy keeps them in the served files too
+func (handler Handler) HideLocation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handler.processor.PublishesLocation() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), hideLocationKey, true)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// hideLocation returns a copy of img without its GPS coordinates if the request asks for it
+func hideLocation(r *http.Request, img *cameraroll.Image) *cameraroll.Image {
+	if hide, _ := r.Context().Value(hideLocationKey).(bool); !hide || img == nil {
+		return img
+	}
+
+	hidden := *img
+	hidden.Latitude = nil
+	hidden.Longitude = nil
+
+	return &hidden
+}
+
 // NewImageResponse is the constructor method for the ImageResponse type
 func NewImageResponse(img *cameraroll.Image) *ImageResponse {
 	resp := ImageResponse{
@@ -117,6 +206,28 @@ func NewImageListResponse(images []*cameraroll.Image) []render.Renderer {
 	return list
 }
 
+// DuplicateResponse is the response body of an image's near-duplicates
+type DuplicateResponse struct {
+	*cameraroll.Duplicate
+}
+
+// Render preprocess the response before it's sent to the wire
+func (rsp *DuplicateResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	// do nothing
+	return nil
+}
+
+// NewDuplicateListResponse is the constructor method for a list of near-duplicates
+func NewDuplicateListResponse(duplicates []*cameraroll.Duplicate) []render.Renderer {
+	list := []render.Renderer{}
+
+	for _, duplicate := range duplicates {
+		list = append(list, &DuplicateResponse{Duplicate: duplicate})
+	}
+
+	return list
+}
+
 // ImageCtx middleware is used to load an Image object from
 // the URL parameters passed through as the request. In case
 // the Image could not be found, we stop here and return a 404.
@@ -212,13 +323,24 @@ func (handler Handler) RemoveTagFromImage(w http.ResponseWriter, r *http.Request
 func (handler Handler) DeleteImage(w http.ResponseWriter, r *http.Request) {
 	image := r.Context().Value(imageKey).(*cameraroll.Image)
 
+	// find every set of renditions before they're gone from database
+	renditions, err := handler.Service.GetRenditionsOfImage(r.Context(), image.ID)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
 	if err := handler.Service.DeleteImageByID(r.Context(), image.ID); err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
 
-	DeleteAssetFromFilesystem(image.Path)
-	DeleteAssetFromFilesystem(image.Thumbnail)
+	handler.processor.DeleteOriginal(r.Context(), storage.KeyFromURL(image.Path, staticFileURL))
+	handler.deleteAsset(r.Context(), image.Thumbnail)
+	for _, rendition := range renditions {
+		handler.deleteAsset(r.Context(), rendition.URL)
+	}
+	handler.resizer.Purge(image.ID)
 
 	render.Status(r, http.StatusOK)
 }
@@ -227,7 +349,9 @@ func (handler Handler) DeleteImage(w http.ResponseWriter, r *http.Request) {
 func (handler Handler) UpdateImage(w http.ResponseWriter, r *http.Request) {
 	image := r.Context().Value(imageKey).(*cameraroll.Image)
 
-	imageReq := ImageRequest{}
+	// fields left out of the request keep their current values
+	current := *image
+	imageReq := ImageRequest{&current}
 
 	// unmarshal new image from request
 	if err := render.Bind(r, &imageReq); err != nil {
@@ -242,6 +366,11 @@ func (handler Handler) UpdateImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// images cropped on the fly follow the focal point
+	if newImage.FocalX != image.FocalX || newImage.FocalY != image.FocalY {
+		handler.resizer.Purge(image.ID)
+	}
+
 	render.Status(r, http.StatusOK)
 }
 
@@ -256,6 +385,152 @@ func (handler Handler) GetImage(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// GetOriginalImage serves the untouched original of the image in the context,
+// metadata included
+func (handler Handler) GetOriginalImage(w http.ResponseWriter, r *http.Request) {
+	image := r.Context().Value(imageKey).(*cameraroll.Image)
+
+	original, info, err := handler.processor.OpenOriginal(r.Context(), storage.KeyFromURL(image.Path, staticFileURL))
+	if errors.Is(err, cameraroll.ErrAssetNotFound) {
+		render.Render(w, r, ErrNotFound())
+		return
+	} else if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	defer original.Close()
+
+	w.Header().Set("Content-Type", image.MimeType)
+	http.ServeContent(w, r, path.Base(info.Key), info.ModTime, original)
+}
+
+// GetImageDuplicates returns the near-duplicates of the image in the context
+func (handler Handler) GetImageDuplicates(w http.ResponseWriter, r *http.Request) {
+	image := r.Context().Value(imageKey).(*cameraroll.Image)
+
+	// images uploaded before perceptual hashes existed have no duplicates to find
+	duplicates := []*cameraroll.Duplicate{}
+	if image.PerceptualHash != nil {
+		var err error
+		duplicates, err = handler.Service.GetDuplicatesOfImage(r.Context(), image.ID, *image.PerceptualHash, handler.duplicateDistance)
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+	}
+
+	if err := render.RenderList(w, r, NewDuplicateListResponse(duplicates)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// ReprocessImage queues the image in the context to have its derivatives
+// and metadata re-derived from its original
+func (handler Handler) ReprocessImage(w http.ResponseWriter, r *http.Request) {
+	image := r.Context().Value(imageKey).(*cameraroll.Image)
+
+	if err := handler.queueProcessing(r.Context(), image); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	render.Status(r, http.StatusAccepted)
+	render.Render(w, r, NewImageResponse(image))
+}
+
+// ReplaceImageFile swaps in a new original for the image in the context,
+// keeping its id, title, description, tags and albums.
+// The derivatives and metadata are regenerated in the background.
+func (handler Handler) ReplaceImageFile(w http.ResponseWriter, r *http.Request) {
+	image := r.Context().Value(imageKey).(*cameraroll.Image)
+
+	// parse the form from request, holding a single file
+	if err := parseUploadForm(w, r, handler.limits.MaxFileSize); errors.Is(err, errImageTooLarge) {
+		render.Render(w, r, ErrRequestTooLarge(err))
+		return
+	} else if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// find the image file from form data
+	imageFile, fileHeader, err := r.FormFile(ParamImageFile)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(errors.New("image file was empty")))
+		return
+	}
+	defer imageFile.Close()
+
+	if err := handler.replaceImageFile(r.Context(), imageFile, fileHeader.Filename, image); errors.Is(err, errImageTooLarge) {
+		render.Render(w, r, ErrRequestTooLarge(err))
+		return
+	} else if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	render.Status(r, http.StatusAccepted)
+	render.Render(w, r, NewImageResponse(image))
+}
+
+// replaceImageFile saves a new original for an existing image and queues its processing,
+// the old files are deleted once they're no longer in use
+func (handler Handler) replaceImageFile(ctx context.Context, imageFile io.ReadSeeker, filename string, img *cameraroll.Image) error {
+	width, height, format, err := handler.checkImageFile(imageFile)
+	if err != nil {
+		return err
+	}
+
+	// rewind the imageFile
+	if _, err := imageFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// save the new image to the asset store
+	fileNameNew, err := handler.saveImageFile(ctx, imageFile, filename, format)
+	if err != nil {
+		return err
+	}
+
+	// the old thumbnail and renditions are served until the new ones are ready
+	oldPath := img.Path
+	img.Path = url.Join(handler.rootURL, StaticAssetURL(), fileNameNew)
+	img.MimeType = imaging.MimeType(format)
+	img.Width = width
+	img.Height = height
+
+	if err := handler.Service.UpdateImageByID(ctx, img.ID, img); err != nil {
+		handler.processor.DeleteOriginal(ctx, fileNameNew)
+		return err
+	}
+
+	// the old original goes to the deleted files, the derivatives follow once replaced
+	handler.processor.DeleteOriginal(ctx, storage.KeyFromURL(oldPath, staticFileURL))
+	handler.resizer.Purge(img.ID)
+
+	// queue the thumbnail, renditions and metadata
+	return handler.queueProcessing(ctx, img)
+}
+
+// queueProcessing queues an existing image to have its derivatives and metadata re-derived
+func (handler Handler) queueProcessing(ctx context.Context, img *cameraroll.Image) error {
+	// marked before queueing, a worker may pick up the job right away
+	if err := handler.Service.UpdateProcessingStatusOfImage(ctx, img.ID, cameraroll.ProcessingPending); err != nil {
+		return err
+	}
+	img.ProcessingStatus = cameraroll.ProcessingPending
+
+	job := cameraroll.Job{Kind: cameraroll.JobProcessImage, ImageID: img.ID}
+	if err := handler.Service.AddJob(ctx, &job); err != nil {
+		return err
+	}
+
+	handler.workers.Notify()
+
+	return nil
+}
+
 // GetImages returns a list of images with pagination available
 func (handler Handler) GetImages(w http.ResponseWriter, r *http.Request) {
 	offset := PaginationDefaultOffset
@@ -281,16 +556,21 @@ func (handler Handler) GetImages(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func verifyImageFile(imageFile multipart.File) error {
+// errInvalidImage is returned when an upload isn't an image that can be decoded
+var errInvalidImage = errors.New("invalid file type")
+
+func verifyImageFile(imageFile io.ReadSeeker) error {
 	// read 512 bytes from the file for image validation
 	buff := make([]byte, 512)
-	if _, err := imageFile.Read(buff); err != nil {
+	if _, err := imageFile.Read(buff); errors.Is(err, io.EOF) {
+		return errInvalidImage
+	} else if err != nil {
 		return err
 	}
 
 	// check if the uploaded file really is an image
 	if !strings.HasPrefix(http.DetectContentType(buff), "image") {
-		return errors.New("invalid file type")
+		return errInvalidImage
 	}
 
 	// rewind the imageFile
@@ -299,169 +579,225 @@ func verifyImageFile(imageFile multipart.File) error {
 	return nil
 }
 
-func saveImageFile(imageFile multipart.File, fileHeader *multipart.FileHeader) (string, error) {
-	// find the image's file extension
-	fileNameSlice := strings.Split(fileHeader.Filename, ".")
-	fileType := fileNameSlice[len(fileNameSlice)-1]
+// checkImageFile makes sure an uploaded file is an image within the UploadLimits,
+// returning its format and upright dimensions
+func (handler Handler) checkImageFile(imageFile io.ReadSeeker) (int, int, string, error) {
+	size, err := imageFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, 0, "", err
+	}
 
-	// get a uuid for the file's new name
-	fileNameNew := fmt.Sprintf("%s.%s", uuid.New().String(), fileType)
+	if err := handler.limits.checkFileSize(size); err != nil {
+		return 0, 0, "", err
+	}
 
-	// construct the file path
-	fileDest := filepath.Join(StaticFileDir(), fileNameNew)
+	if _, err := imageFile.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, "", err
+	}
 
-	// copy the file to static asset folder
-	f, err := os.Create(fileDest)
+	// check if the uploaded file is an image
+	if err := verifyImageFile(imageFile); err != nil {
+		return 0, 0, "", err
+	}
+
+	// find the format and dimensions from the header, before anything gets decoded
+	width, height, format, err := imaging.ReadDimensions(imageFile)
 	if err != nil {
-		return fileNameNew, err
+		return 0, 0, "", fmt.Errorf("%w: %v", errInvalidImage, err)
 	}
-	defer f.Close()
-	io.Copy(f, imageFile)
 
-	// rewind the imageFile
-	imageFile.Seek(0, 0)
+	if err := handler.limits.checkPixels(width, height); err != nil {
+		return 0, 0, "", err
+	}
 
-	return fileNameNew, nil
+	return width, height, format, nil
 }
 
-type ThumbnailStats struct {
-	Path            string
-	Width           int
-	Height          int
-	ThumbnailWidth  int
-	ThumbnailHeight int
-}
+func (handler Handler) saveImageFile(ctx context.Context, imageFile io.Reader, filename string, format string) (string, error) {
+	// find the image's file extension, falling back to its format when it has none
+	fileType := format
+	if i := strings.LastIndex(filename, "."); i >= 0 {
+		fileType = filename[i+1:]
+	}
 
-func createImageThumbnail(imageFile multipart.File, fileHeader *multipart.FileHeader) (ThumbnailStats, error) {
-	const (
-		ThumbnailMaxWidthPx  = 400
-		ThumbnailMaxHeightPx = 400
-	)
+	// get a uuid for the file's new name
+	fileNameNew := fmt.Sprintf("%s.%s", uuid.New().String(), fileType)
 
-	// find the image's file extension
-	fileNameSlice := strings.Split(fileHeader.Filename, ".")
-	fileType := fileNameSlice[len(fileNameSlice)-1]
+	// keep the original private, serving a copy without its metadata
+	if err := handler.processor.SaveOriginal(ctx, fileNameNew, imageFile, format); err != nil {
+		return fileNameNew, err
+	}
 
-	// get a uuid for the thumbnail's filename
-	fileNameNew := fmt.Sprintf("%s.%s", uuid.New().String(), fileType)
+	return fileNameNew, nil
+}
 
-	// construct the file path
-	fileDest := filepath.Join(StaticFileDir(), fileNameNew)
+// errDuplicateImage is returned when an upload looks like an existing image
+var errDuplicateImage = errors.New("image looks like an existing image")
 
-	// open the destination file
-	f, err := os.Create(fileDest)
-	if err != nil {
-		return ThumbnailStats{Path: fileNameNew}, err
-	}
-	defer f.Close()
+// isRejectedImage checks if ingesting an image failed because of the image itself,
+// rather than something that may work when tried again
+func isRejectedImage(err error) bool {
+	return errors.Is(err, errInvalidImage) || errors.Is(err, errImageTooLarge) || errors.Is(err, errDuplicateImage)
+}
 
-	// decode the original image
-	img, err := jpeg.Decode(imageFile)
+// ingestImage saves an uploaded original and queues the processing of its derivatives,
+// adding img to the database as a pending image in the album and with the tags of options.
+// Near-duplicates are only looked for upfront if they aren't allowed.
+func (handler Handler) ingestImage(ctx context.Context, imageFile io.ReadSeeker, filename string, img *cameraroll.Image, options *batchOptions) error {
+	width, height, format, err := handler.checkImageFile(imageFile)
 	if err != nil {
-		return ThumbnailStats{Path: fileNameNew}, err
+		return err
 	}
 
-	// create the thumbnail
-	thumb := resize.Thumbnail(ThumbnailMaxWidthPx, ThumbnailMaxHeightPx, img, resize.Lanczos3)
+	// rejecting near-duplicates means decoding the image right away
+	if !options.allowDuplicate {
+		hash, err := imaging.PerceptualHashOf(imageFile)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errInvalidImage, err)
+		}
 
-	// save the thumbnail to static folder
-	jpeg.Encode(f, thumb, nil)
+		duplicates, err := handler.Service.GetDuplicatesOfImage(ctx, 0, hash, handler.duplicateDistance)
+		if err != nil {
+			return err
+		}
 
-	// rewind the imageFile
-	imageFile.Seek(0, 0)
+		if len(duplicates) > 0 {
+			return fmt.Errorf("%w [%d]", errDuplicateImage, duplicates[0].ID)
+		}
 
-	// get all the stats
-	width := img.Bounds().Dx()
-	height := img.Bounds().Dy()
-	thumbWidth := thumb.Bounds().Dx()
-	thumbHeight := thumb.Bounds().Dy()
+		img.PerceptualHash = &hash
+	}
 
-	return ThumbnailStats{
-		Path:            fileNameNew,
-		Width:           width,
-		Height:          height,
-		ThumbnailWidth:  thumbWidth,
-		ThumbnailHeight: thumbHeight}, nil
-}
+	// rewind the imageFile
+	if _, err := imageFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
 
-// AddImage adds a new image to the database
-func (handler Handler) AddImage(w http.ResponseWriter, r *http.Request) {
-	imageReq := ImageRequest{&cameraroll.Image{}}
+	// save the image to the asset store
+	fileNameNew, err := handler.saveImageFile(ctx, imageFile, filename, format)
+	if err != nil {
+		return err
+	}
 
-	// // unmarshal new image from request
-	// if err := render.Bind(r, &imageReq); err != nil {
-	// 	render.Render(w, r, ErrInvalidRequest(err))
-	// 	return
-	// }
+	img.Path = url.Join(handler.rootURL, StaticAssetURL(), fileNameNew)
+	img.MimeType = imaging.MimeType(format)
+	img.Width = width
+	img.Height = height
+	img.ProcessingStatus = cameraroll.ProcessingPending
 
-	// parse the form from request
-	if err := r.ParseMultipartForm(MaxImageSize); err != nil {
-		render.Render(w, r, ErrInvalidRequest(err))
-		return
-	}
+	// images start out focused on their center
+	img.FocalX, img.FocalY = 0.5, 0.5
 
-	// find title from form data
-	title := r.Form.Get(ParamImageTitle)
-	if len(title) > 0 {
-		imageReq.Title = title
+	// add the new image to database
+	if err := handler.Service.AddImage(ctx, img); err != nil {
+		handler.processor.DeleteOriginal(ctx, fileNameNew)
+		return err
 	}
 
-	// find description from form data
-	desc := r.Form.Get(ParamImageDescription)
-	if len(desc) > 0 {
-		imageReq.Description = desc
+	// the album and tags go first, the album's watermark is applied by the processing
+	err = handler.addImageToBatch(ctx, img, options)
+	if err == nil {
+		// queue the thumbnail, renditions and metadata
+		job := cameraroll.Job{Kind: cameraroll.JobProcessImage, ImageID: img.ID}
+		err = handler.Service.AddJob(ctx, &job)
 	}
 
-	// find the image file from form data
-	imageFile, fileHeader, err := r.FormFile(ParamImageFile)
 	if err != nil {
-		render.Render(w, r, ErrInvalidRequest(errors.New("image file was empty")))
-		return
+		if err := handler.Service.DeleteImageByID(ctx, img.ID); err != nil {
+			log.Println(err)
+		}
+		handler.processor.DeleteOriginal(ctx, fileNameNew)
+		return err
 	}
-	defer imageFile.Close()
 
-	// check if the uploaded file is an image
-	if err := verifyImageFile(imageFile); err != nil {
-		render.Render(w, r, ErrInvalidRequest(err))
-		return
+	handler.workers.Notify()
+
+	return nil
+}
+
+// AddImage adds new images to the database,
+// responding before their derivatives are processed.
+// A single image is answered with the image, several images or a ZIP archive
+// with the outcome of every file, one bad file not stopping the rest.
+func (handler Handler) AddImage(w http.ResponseWriter, r *http.Request) {
+	options := batchOptions{}
+
+	// near-duplicates are only rejected when asked to
+	options.allowDuplicate = true
+	if param := r.URL.Query().Get(ParamAllowDuplicate); len(param) > 0 {
+		var err error
+		if options.allowDuplicate, err = strconv.ParseBool(param); err != nil {
+			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("couldn't read %s: %w", ParamAllowDuplicate, err)))
+			return
+		}
 	}
 
-	// save the image to static folder
-	fileNameNew, err := saveImageFile(imageFile, fileHeader)
-	if err != nil {
+	// parse the form from request, holding a batch of files
+	if err := parseUploadForm(w, r, handler.limits.MaxBatchSize); errors.Is(err, errImageTooLarge) {
+		render.Render(w, r, ErrRequestTooLarge(err))
+		return
+	} else if err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
 
-	// create a thumbnail
-	thumbnail, err := createImageThumbnail(imageFile, fileHeader)
-	if err != nil {
+	// find title and description from form data, shared by all the images
+	options.title = r.Form.Get(ParamImageTitle)
+	options.description = r.Form.Get(ParamImageDescription)
+
+	// find the album and tags for the images from form data
+	if err := handler.parseBatchOptions(r, &options); err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
 
-	// update the image's path
-	imagePath := url.Join(handler.rootURL, StaticAssetURL(), fileNameNew)
-	imageReq.Path = imagePath
+	// find the image files from form data
+	files := r.MultipartForm.File[ParamImageFile]
+	if len(files) == 0 {
+		render.Render(w, r, ErrInvalidRequest(errors.New("image file was empty")))
+		return
+	}
 
-	// update thumbnail path
-	thumbnailPath := url.Join(handler.rootURL, StaticAssetURL(), thumbnail.Path)
-	imageReq.Thumbnail = thumbnailPath
+	if len(files) == 1 {
+		imageFile, err := files[0].Open()
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		defer imageFile.Close()
 
-	// update image stats
-	imageReq.Width = thumbnail.Width
-	imageReq.Height = thumbnail.Height
-	imageReq.ThumbnailWidth = thumbnail.ThumbnailWidth
-	imageReq.ThumbnailHeight = thumbnail.ThumbnailHeight
+		if !isZipFile(imageFile) {
+			// save the image and queue its processing
+			image, err := handler.addImageFile(r.Context(), imageFile, files[0].Filename, &options)
+			if errors.Is(err, errDuplicateImage) {
+				render.Render(w, r, ErrConflict(err))
+				return
+			} else if errors.Is(err, errImageTooLarge) {
+				render.Render(w, r, ErrRequestTooLarge(err))
+				return
+			} else if err != nil {
+				render.Render(w, r, ErrInvalidRequest(err))
+				return
+			}
 
-	// add the new image to database
-	image := imageReq.Image
-	if err := handler.Service.AddImage(r.Context(), image); err != nil {
-		render.Render(w, r, ErrInvalidRequest(err))
-		return
+			// render response
+			render.Status(r, http.StatusAccepted)
+			render.Render(w, r, NewImageResponse(image))
+			return
+		}
 	}
 
+	results := handler.addBatch(r.Context(), files, &options)
+
 	// render response
-	render.Status(r, http.StatusOK)
-	render.Render(w, r, NewImageResponse(image))
+	if succeeded(results) {
+		render.Status(r, http.StatusAccepted)
+	} else {
+		render.Status(r, http.StatusBadRequest)
+	}
+
+	if err := render.RenderList(w, r, NewBatchResultListResponse(results)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
 }
@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/render"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+	"chujungeng/camera-roll/pkg/imaging"
+	"chujungeng/camera-roll/pkg/storage"
+)
+
+const (
+	ParamImageSize = "size"
+	ParamResizeFit = "fit"
+)
+
+// ways of fitting an image into the requested size
+const (
+	fitInside = "inside" // shrink to fit, keeping the aspect ratio of the original
+	fitCrop   = "crop"   // crop to the requested aspect ratio around the focal point
+)
+
+// ResizedImageURL is the route pattern of resized images, e.g. /assets/123/800x600.jpg
+func ResizedImageURL() string {
+	return StaticAssetURL() + "{imageID:[0-9]+}/{size:[0-9]+x[0-9]+}"
+}
+
+// GetResizedImage serves a copy of the original image resized on the fly
+func (handler Handler) GetResizedImage(w http.ResponseWriter, r *http.Request) {
+	var width, height int
+
+	// find the imageID from URL params
+	imageID, err := strconv.ParseInt(chi.URLParam(r, ParamImageID), ParamNumberBase, ParamNumberBit)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// find the size from URL params
+	if _, err := fmt.Sscanf(chi.URLParam(r, ParamImageSize), "%dx%d", &width, &height); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// refuse anything that isn't whitelisted
+	if !handler.resizer.Allowed(width, height) {
+		render.Render(w, r, ErrNotFound())
+		return
+	}
+
+	// find the output format from the file extension, defaulting to JPEG
+	format := imaging.FormatJPEG
+	if ext, _ := r.Context().Value(middleware.URLFormatCtxKey).(string); len(ext) > 0 {
+		var ok bool
+		if format, ok = imaging.FormatFromExtension(ext); !ok {
+			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("unsupported format [%s]", ext)))
+			return
+		}
+	}
+
+	image, err := handler.Service.GetImageByID(r.Context(), imageID)
+	if err != nil {
+		render.Render(w, r, ErrNotFound())
+		return
+	}
+
+	var focus *imaging.Focus
+	switch fit := r.URL.Query().Get(ParamResizeFit); fit {
+	case fitInside, "":
+	case fitCrop:
+		focus = &imaging.Focus{X: image.FocalX, Y: image.FocalY}
+	default:
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("unknown %s [%s]", ParamResizeFit, fit)))
+		return
+	}
+
+	// resized copies look like the renditions, edited and carrying the default mark
+	edits, err := handler.Service.GetEditsOfImage(r.Context(), image.ID)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+
+	resized, modTime, err := handler.resizer.Resize(r.Context(), image.ID, storage.KeyFromURL(image.Path, staticFileURL), width, height, format, focus, edits, "")
+	if errors.Is(err, cameraroll.ErrAssetNotFound) {
+		render.Render(w, r, ErrNotFound())
+		return
+	} else if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	defer resized.Close()
+
+	name := fmt.Sprintf("%dx%d.%s", width, height, imaging.Extension(format))
+	http.ServeContent(w, r, name, modTime, resized)
+}
@@ -3,6 +3,7 @@ package routes
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -13,14 +14,18 @@ import (
 )
 
 const (
-	ParamTagID = "tagID"
+	ParamTagID              = "tagID"
+	ParamTagName            = "tagName"
+	ParamTagAlias           = "alias"
+	ParamIncludeDescendants = "include_descendants"
 )
 
 // TagRouterPublic specifies all the public routes related to tags
 func (handler Handler) TagRouterPublic() chi.Router {
 	r := chi.NewRouter()
 
-	r.Get("/", handler.GetTags) // GET /tags
+	r.Get("/", handler.GetTags)                      // GET /tags
+	r.Get("/lookup/{tagName}", handler.GetTagByName) // GET /tags/lookup/mountains
 
 	r.Route("/{tagID}", func(r chi.Router) {
 		r.Use(handler.TagCtx)                                       // Load the *Tag on the request context
@@ -36,8 +41,9 @@ func (handler Handler) TagRouterPublic() chi.Router {
 func (handler Handler) TagRouterProtected() chi.Router {
 	r := chi.NewRouter()
 
-	r.Get("/", handler.GetTags) // GET /admin/tags
-	r.Post("/", handler.AddTag) // POST /admin/tags
+	r.Get("/", handler.GetTags)                      // GET /admin/tags
+	r.Post("/", handler.AddTag)                      // POST /admin/tags
+	r.Get("/lookup/{tagName}", handler.GetTagByName) // GET /admin/tags/lookup/mountains
 
 	r.Route("/{tagID}", func(r chi.Router) {
 		r.Use(handler.TagCtx)            // Load the *Tag on the request context
@@ -45,6 +51,10 @@ func (handler Handler) TagRouterProtected() chi.Router {
 		r.Put("/", handler.UpdateTag)    // PUT /admin/tags/123
 		r.Delete("/", handler.DeleteTag) // DELETE /admin/tags/123
 
+		r.Post("/merge", handler.MergeTag)                       // POST /admin/tags/123/merge
+		r.Post("/aliases", handler.AddAliasToTag)                // POST /admin/tags/123/aliases
+		r.Delete("/aliases/{alias}", handler.RemoveAliasFromTag) // DELETE /admin/tags/123/aliases/mtn
+
 		r.With(Pagination).Get("/albums", handler.GetAlbumsWithTag) // GET /admin/tags/123/albums
 		r.With(Pagination).Get("/images", handler.GetImagesWithTag) // GET /admin/tags/123/images
 	})
@@ -67,6 +77,34 @@ func (req *TagRequest) Bind(r *http.Request) error {
 	return nil
 }
 
+// TagAliasRequest is the request body of adding an alias to a tag
+type TagAliasRequest struct {
+	Alias string `json:"alias"`
+}
+
+// Bind preprocesses the request for some basic error checking
+func (req *TagAliasRequest) Bind(r *http.Request) error {
+	if len(req.Alias) == 0 {
+		return errors.New("missing alias")
+	}
+
+	return nil
+}
+
+// TagMergeRequest is the request body of merging a duplicate into a tag
+type TagMergeRequest struct {
+	DuplicateID int64 `json:"duplicate_id"`
+}
+
+// Bind preprocesses the request for some basic error checking
+func (req *TagMergeRequest) Bind(r *http.Request) error {
+	if req.DuplicateID <= 0 {
+		return errors.New("missing duplicate_id")
+	}
+
+	return nil
+}
+
 // TagResponse is the response body of tags' CRUD operations
 type TagResponse struct {
 	*cameraroll.Tag
@@ -128,7 +166,23 @@ func (handler Handler) TagCtx(next http.Handler) http.Handler {
 	})
 }
 
-// GetImagesWithTag returns all the images under specified tag
+// includeDescendants reads ?include_descendants=, which is off by default
+func includeDescendants(r *http.Request) (bool, error) {
+	param := r.URL.Query().Get(ParamIncludeDescendants)
+	if len(param) == 0 {
+		return false, nil
+	}
+
+	include, err := strconv.ParseBool(param)
+	if err != nil {
+		return false, fmt.Errorf("couldn't read %s: %w", ParamIncludeDescendants, err)
+	}
+
+	return include, nil
+}
+
+// GetImagesWithTag returns all the images under specified tag,
+// and under the tags nested in it if ?include_descendants=true
 func (handler Handler) GetImagesWithTag(w http.ResponseWriter, r *http.Request) {
 	offset := PaginationDefaultOffset
 	limit := PaginationDefaultLimit
@@ -141,7 +195,13 @@ func (handler Handler) GetImagesWithTag(w http.ResponseWriter, r *http.Request)
 
 	tag := r.Context().Value(tagKey).(*cameraroll.Tag)
 
-	images, err := handler.Service.GetImagesWithTag(r.Context(), tag.ID, offset, limit)
+	descendants, err := includeDescendants(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	images, err := handler.Service.GetImagesWithTag(r.Context(), tag.ID, descendants, offset, limit)
 	if err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
@@ -154,7 +214,8 @@ func (handler Handler) GetImagesWithTag(w http.ResponseWriter, r *http.Request)
 	}
 }
 
-// GetAlbumsWithTag returns all the albums under specified tag
+// GetAlbumsWithTag returns all the albums under specified tag,
+// and under the tags nested in it if ?include_descendants=true
 func (handler Handler) GetAlbumsWithTag(w http.ResponseWriter, r *http.Request) {
 	offset := PaginationDefaultOffset
 	limit := PaginationDefaultLimit
@@ -167,7 +228,13 @@ func (handler Handler) GetAlbumsWithTag(w http.ResponseWriter, r *http.Request)
 
 	tag := r.Context().Value(tagKey).(*cameraroll.Tag)
 
-	albums, err := handler.Service.GetAlbumsWithTag(r.Context(), tag.ID, offset, limit)
+	descendants, err := includeDescendants(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	albums, err := handler.Service.GetAlbumsWithTag(r.Context(), tag.ID, descendants, offset, limit)
 	if err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
@@ -196,7 +263,9 @@ func (handler Handler) DeleteTag(w http.ResponseWriter, r *http.Request) {
 func (handler Handler) UpdateTag(w http.ResponseWriter, r *http.Request) {
 	tag := r.Context().Value(tagKey).(*cameraroll.Tag)
 
-	tagReq := TagRequest{}
+	// fields left out of the request keep their current values
+	current := *tag
+	tagReq := TagRequest{&current}
 
 	// unmarshal new tag from request
 	if err := render.Bind(r, &tagReq); err != nil {
@@ -225,6 +294,87 @@ func (handler Handler) GetTag(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// GetTagByName returns the tag with a name or an alias
+func (handler Handler) GetTagByName(w http.ResponseWriter, r *http.Request) {
+	tag, err := handler.Service.GetTagByName(r.Context(), chi.URLParam(r, ParamTagName))
+	if err != nil {
+		render.Render(w, r, ErrNotFound())
+		return
+	}
+
+	if err := render.Render(w, r, NewTagResponse(tag)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// MergeTag folds a duplicate tag into the tag in the context,
+// which takes over its images, albums, aliases and children along with its name as an alias
+func (handler Handler) MergeTag(w http.ResponseWriter, r *http.Request) {
+	tag := r.Context().Value(tagKey).(*cameraroll.Tag)
+
+	mergeReq := TagMergeRequest{}
+
+	// unmarshal the duplicate from request
+	if err := render.Bind(r, &mergeReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	if err := handler.Service.MergeTags(r.Context(), tag.ID, mergeReq.DuplicateID); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	handler.renderTag(w, r, tag.ID)
+}
+
+// AddAliasToTag adds another name that resolves to the tag in the context
+func (handler Handler) AddAliasToTag(w http.ResponseWriter, r *http.Request) {
+	tag := r.Context().Value(tagKey).(*cameraroll.Tag)
+
+	aliasReq := TagAliasRequest{}
+
+	// unmarshal the alias from request
+	if err := render.Bind(r, &aliasReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	if err := handler.Service.AddAliasToTag(r.Context(), tag.ID, aliasReq.Alias); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	handler.renderTag(w, r, tag.ID)
+}
+
+// RemoveAliasFromTag removes one of the other names of the tag in the context
+func (handler Handler) RemoveAliasFromTag(w http.ResponseWriter, r *http.Request) {
+	tag := r.Context().Value(tagKey).(*cameraroll.Tag)
+
+	if err := handler.Service.RemoveAliasFromTag(r.Context(), tag.ID, chi.URLParam(r, ParamTagAlias)); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	handler.renderTag(w, r, tag.ID)
+}
+
+// renderTag responds with the tag as it is now in the database
+func (handler Handler) renderTag(w http.ResponseWriter, r *http.Request, id int64) {
+	tag, err := handler.Service.GetTagByID(r.Context(), id)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	if err := render.Render(w, r, NewTagResponse(tag)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
 // GetTags returns a list of tags with pagination available
 func (handler Handler) GetTags(w http.ResponseWriter, r *http.Request) {
 
@@ -0,0 +1,490 @@
+package routes
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+	"chujungeng/camera-roll/pkg/url"
+)
+
+const (
+	ParamUploadID = "uploadID"
+
+	// how long an unfinished upload is kept around after its last chunk
+	uploadExpiry = 24 * time.Hour
+)
+
+// tus protocol headers
+const (
+	tusVersion       = "1.0.0"
+	tusExtensions    = "creation,expiration,termination"
+	tusOffsetContent = "application/offset+octet-stream"
+
+	headerTusResumable  = "Tus-Resumable"
+	headerTusVersion    = "Tus-Version"
+	headerTusExtension  = "Tus-Extension"
+	headerTusMaxSize    = "Tus-Max-Size"
+	headerUploadLength  = "Upload-Length"
+	headerUploadOffset  = "Upload-Offset"
+	headerUploadMeta    = "Upload-Metadata"
+	headerUploadExpires = "Upload-Expires"
+)
+
+// keys of the Upload-Metadata header, besides title and description
+const (
+	uploadMetaFilename = "filename"
+)
+
+// Upload keeps track of a resumable upload, its data is the file of the same name in the upload folder
+type Upload struct {
+	ID       string            `json:"id"`
+	Length   int64             `json:"length"`
+	Offset   int64             `json:"offset"`
+	Metadata map[string]string `json:"metadata"`
+	Expires  time.Time         `json:"expires"`
+	ImageID  int64             `json:"image_id,omitempty"` // set once the upload is complete and ingested
+}
+
+// uploadStore keeps the resumable uploads on disk
+type uploadStore struct {
+	dir string
+
+	// a chunk can only be appended to an upload by one request at a time
+	locks sync.Map
+}
+
+func newUploadStore(dir string) *uploadStore {
+	return &uploadStore{dir: dir}
+}
+
+func (store *uploadStore) dataPath(id string) string {
+	return filepath.Join(store.dir, id)
+}
+
+func (store *uploadStore) infoPath(id string) string {
+	return filepath.Join(store.dir, id+".json")
+}
+
+// lock tries to lock an upload, returning false if it's already locked
+func (store *uploadStore) lock(id string) bool {
+	mutex, _ := store.locks.LoadOrStore(id, &sync.Mutex{})
+	return mutex.(*sync.Mutex).TryLock()
+}
+
+func (store *uploadStore) unlock(id string) {
+	if mutex, ok := store.locks.Load(id); ok {
+		mutex.(*sync.Mutex).Unlock()
+	}
+}
+
+// create starts an empty upload
+func (store *uploadStore) create(upload *Upload) error {
+	upload.ID = uuid.New().String()
+	upload.Expires = time.Now().Add(uploadExpiry).UTC()
+
+	file, err := os.Create(store.dataPath(upload.ID))
+	if err != nil {
+		return err
+	}
+	file.Close()
+
+	return store.save(upload)
+}
+
+// get loads an upload along with how much of it has been received
+func (store *uploadStore) get(id string) (*Upload, error) {
+	// upload ids end up in file paths
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, os.ErrNotExist
+	}
+
+	bytes, err := os.ReadFile(store.infoPath(id))
+	if err != nil {
+		return nil, err
+	}
+
+	upload := Upload{}
+	if err := json.Unmarshal(bytes, &upload); err != nil {
+		return nil, err
+	}
+
+	// the data file is gone once the upload has been ingested
+	if upload.ImageID > 0 {
+		upload.Offset = upload.Length
+		return &upload, nil
+	}
+
+	stat, err := os.Stat(store.dataPath(id))
+	if err != nil {
+		return nil, err
+	}
+	upload.Offset = stat.Size()
+
+	return &upload, nil
+}
+
+// save writes the info of an upload
+func (store *uploadStore) save(upload *Upload) error {
+	bytes, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(store.infoPath(upload.ID), bytes, 0644)
+}
+
+// write appends a chunk to an upload, never past its length
+func (store *uploadStore) write(upload *Upload, chunk io.Reader) error {
+	file, err := os.OpenFile(store.dataPath(upload.ID), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	n, copyErr := io.Copy(file, io.LimitReader(chunk, upload.Length-upload.Offset))
+	upload.Offset += n
+
+	// whatever made it to disk counts, the client resumes from there
+	upload.Expires = time.Now().Add(uploadExpiry).UTC()
+	if err := store.save(upload); err != nil {
+		return err
+	}
+
+	return copyErr
+}
+
+// open opens the data of an upload for reading
+func (store *uploadStore) open(upload *Upload) (*os.File, error) {
+	return os.Open(store.dataPath(upload.ID))
+}
+
+// finish drops the data of an ingested upload, keeping its info until it expires
+func (store *uploadStore) finish(upload *Upload) error {
+	if err := store.save(upload); err != nil {
+		return err
+	}
+
+	return os.Remove(store.dataPath(upload.ID))
+}
+
+// remove deletes an upload
+func (store *uploadStore) remove(id string) error {
+	if err := os.Remove(store.dataPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := os.Remove(store.infoPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	store.locks.Delete(id)
+
+	return nil
+}
+
+// removeExpired deletes the uploads that haven't received anything in a while
+func (store *uploadStore) removeExpired() {
+	infos, err := filepath.Glob(filepath.Join(store.dir, "*.json"))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for _, info := range infos {
+		id := strings.TrimSuffix(filepath.Base(info), ".json")
+
+		upload, err := store.get(id)
+		if err == nil && upload.Expires.After(time.Now()) {
+			continue
+		}
+
+		if err := store.remove(id); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// parseUploadMetadata reads the comma separated key and base64 encoded value pairs of Upload-Metadata
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read %s [%s]: %w", headerUploadMeta, key, err)
+		}
+
+		metadata[key] = string(value)
+	}
+
+	return metadata, nil
+}
+
+// UploadResponse is the response payload for the Upload data model
+type UploadResponse struct {
+	*Upload
+}
+
+// NewUploadResponse is the constructor method for UploadResponse type
+func NewUploadResponse(upload *Upload) *UploadResponse {
+	return &UploadResponse{Upload: upload}
+}
+
+// Render is called by go-chi/render to render the upload
+func (rd *UploadResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// UploadRouter specifies the tus 1.0 resumable upload routes
+func (handler Handler) UploadRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(TusResumable)
+
+	r.Options("/", handler.GetUploadOptions) // OPTIONS /admin/uploads
+	r.Post("/", handler.CreateUpload)        // POST /admin/uploads
+
+	r.Route("/{uploadID}", func(r chi.Router) {
+		r.Use(handler.UploadCtx)
+		r.Head("/", handler.GetUploadOffset) // HEAD /admin/uploads/abc
+		r.Get("/", handler.GetUpload)        // GET /admin/uploads/abc
+		r.Patch("/", handler.PatchUpload)    // PATCH /admin/uploads/abc
+		r.Delete("/", handler.DeleteUpload)  // DELETE /admin/uploads/abc
+	})
+
+	return r
+}
+
+// TusResumable middleware rejects requests made with other versions of the tus protocol
+func TusResumable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(headerTusResumable, tusVersion)
+
+		// version discovery and the upload summary work without knowing the version
+		if r.Method != http.MethodOptions && r.Method != http.MethodGet && r.Header.Get(headerTusResumable) != tusVersion {
+			w.Header().Set(headerTusVersion, tusVersion)
+			render.Render(w, r, ErrPreconditionFailed(fmt.Errorf("unsupported %s [%s]", headerTusResumable, r.Header.Get(headerTusResumable))))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// UploadCtx middleware is used to load an Upload from the URL parameters passed through as the request.
+// In case the Upload could not be found, we stop here and return a 404.
+func (handler Handler) UploadCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upload, err := handler.uploads.get(chi.URLParam(r, ParamUploadID))
+		if err != nil {
+			render.Render(w, r, ErrNotFound())
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), uploadKey, upload)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetUploadOptions describes the supported tus version and extensions
+func (handler Handler) GetUploadOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(headerTusVersion, tusVersion)
+	w.Header().Set(headerTusExtension, tusExtensions)
+	w.Header().Set(headerTusMaxSize, strconv.FormatInt(handler.limits.MaxFileSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateUpload starts a new resumable upload of Upload-Length bytes.
+// Upload-Metadata may carry the filename, title and description of the image,
+// as well as allow_duplicate.
+func (handler Handler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get(headerUploadLength), 10, 64)
+	if err != nil || length <= 0 {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("invalid %s [%s]", headerUploadLength, r.Header.Get(headerUploadLength))))
+		return
+	}
+
+	if err := handler.limits.checkFileSize(length); err != nil {
+		render.Render(w, r, ErrRequestTooLarge(err))
+		return
+	}
+
+	metadata, err := parseUploadMetadata(r.Header.Get(headerUploadMeta))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	// near-duplicates are only rejected when asked to
+	if param, ok := metadata[ParamAllowDuplicate]; ok {
+		if _, err := strconv.ParseBool(param); err != nil {
+			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("couldn't read %s: %w", ParamAllowDuplicate, err)))
+			return
+		}
+	}
+
+	// abandoned uploads are cleaned up as new ones come in
+	handler.uploads.removeExpired()
+
+	upload := Upload{Length: length, Metadata: metadata}
+	if err := handler.uploads.create(&upload); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	w.Header().Set("Location", url.Join(handler.rootURL, "/api/admin/uploads/", upload.ID))
+	w.Header().Set(headerUploadExpires, upload.Expires.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// GetUploadOffset tells how much of the upload in the context has been received
+func (handler Handler) GetUploadOffset(w http.ResponseWriter, r *http.Request) {
+	upload := r.Context().Value(uploadKey).(*Upload)
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set(headerUploadOffset, strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set(headerUploadLength, strconv.FormatInt(upload.Length, 10))
+	w.Header().Set(headerUploadExpires, upload.Expires.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetUpload returns the upload in the context, including the id of its image once it's complete
+func (handler Handler) GetUpload(w http.ResponseWriter, r *http.Request) {
+	upload := r.Context().Value(uploadKey).(*Upload)
+
+	if err := render.Render(w, r, NewUploadResponse(upload)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// PatchUpload appends a chunk to the upload in the context at Upload-Offset.
+// Once every byte has been received, the image is ingested like the ones sent to AddImage.
+func (handler Handler) PatchUpload(w http.ResponseWriter, r *http.Request) {
+	upload := r.Context().Value(uploadKey).(*Upload)
+
+	if r.Header.Get("Content-Type") != tusOffsetContent {
+		render.Render(w, r, ErrUnsupportedMediaType(fmt.Errorf("chunks must be sent as %s", tusOffsetContent)))
+		return
+	}
+
+	if !handler.uploads.lock(upload.ID) {
+		render.Render(w, r, ErrConflict(errors.New("upload is being written to by another request")))
+		return
+	}
+	defer handler.uploads.unlock(upload.ID)
+
+	// reload the upload now that nobody else is writing to it
+	upload, err := handler.uploads.get(upload.ID)
+	if err != nil {
+		render.Render(w, r, ErrNotFound())
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get(headerUploadOffset), 10, 64)
+	if err != nil || offset != upload.Offset {
+		render.Render(w, r, ErrConflict(fmt.Errorf("%s [%s] doesn't match the received %d bytes", headerUploadOffset, r.Header.Get(headerUploadOffset), upload.Offset)))
+		return
+	}
+
+	if upload.ImageID == 0 {
+		if err := handler.uploads.write(upload, r.Body); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+	}
+
+	// the last chunk hands the file over to the image ingestion
+	if upload.Offset == upload.Length && upload.ImageID == 0 {
+		if err := handler.ingestUpload(r.Context(), upload); errors.Is(err, errDuplicateImage) {
+			render.Render(w, r, ErrConflict(err))
+			return
+		} else if errors.Is(err, errImageTooLarge) {
+			render.Render(w, r, ErrRequestTooLarge(err))
+			return
+		} else if err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+	}
+
+	w.Header().Set(headerUploadOffset, strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set(headerUploadExpires, upload.Expires.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ingestUpload adds the image of a complete upload.
+// A file that's rejected is dropped, sending it again won't help.
+// Other failures keep the upload, so that sending the last chunk again retries the ingestion.
+func (handler Handler) ingestUpload(ctx context.Context, upload *Upload) error {
+	file, err := handler.uploads.open(upload)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	options := batchOptions{allowDuplicate: true}
+	if param, ok := upload.Metadata[ParamAllowDuplicate]; ok {
+		options.allowDuplicate, _ = strconv.ParseBool(param)
+	}
+
+	img := cameraroll.Image{
+		Title:       upload.Metadata[ParamImageTitle],
+		Description: upload.Metadata[ParamImageDescription],
+	}
+
+	if err := handler.ingestImage(ctx, file, upload.Metadata[uploadMetaFilename], &img, &options); err != nil {
+		if isRejectedImage(err) {
+			if err := handler.uploads.remove(upload.ID); err != nil {
+				log.Println(err)
+			}
+		}
+
+		return err
+	}
+
+	upload.ImageID = img.ID
+
+	return handler.uploads.finish(upload)
+}
+
+// DeleteUpload terminates the upload in the context
+func (handler Handler) DeleteUpload(w http.ResponseWriter, r *http.Request) {
+	upload := r.Context().Value(uploadKey).(*Upload)
+
+	if !handler.uploads.lock(upload.ID) {
+		render.Render(w, r, ErrConflict(errors.New("upload is being written to by another request")))
+		return
+	}
+	defer handler.uploads.unlock(upload.ID)
+
+	if err := handler.uploads.remove(upload.ID); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
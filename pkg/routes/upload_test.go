@@ -0,0 +1,225 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"testing"
+)
+
+// tusRequest sends a tus request to the upload routes
+func tusRequest(t *testing.T, routes http.Handler, method string, target string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(method, target, bytes.NewReader(body))
+	r.Header.Set(headerTusResumable, tusVersion)
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, r)
+
+	return w
+}
+
+// createTestUpload starts an upload of length bytes, returning its id
+func createTestUpload(t *testing.T, routes http.Handler, length int, metadata string) string {
+	t.Helper()
+
+	w := tusRequest(t, routes, http.MethodPost, "/", nil, map[string]string{
+		headerUploadLength: strconv.Itoa(length),
+		headerUploadMeta:   metadata,
+	})
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST = %d %s", w.Code, w.Body)
+	}
+
+	return path.Base(w.Header().Get("Location"))
+}
+
+// patchTestUpload sends a chunk of an upload at offset
+func patchTestUpload(t *testing.T, routes http.Handler, id string, offset int, chunk []byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	return tusRequest(t, routes, http.MethodPatch, "/"+id, chunk, map[string]string{
+		"Content-Type":     tusOffsetContent,
+		headerUploadOffset: strconv.Itoa(offset),
+	})
+}
+
+func TestUploadOptions(t *testing.T) {
+	routes := newTestHandler(t, newFakeService()).UploadRouter()
+
+	w := tusRequest(t, routes, http.MethodOptions, "/", nil, nil)
+	if w.Code != http.StatusNoContent || w.Header().Get(headerTusVersion) != tusVersion || len(w.Header().Get(headerTusExtension)) == 0 {
+		t.Errorf("OPTIONS = %d %v", w.Code, w.Header())
+	}
+
+	// other versions of the protocol are turned away
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set(headerTusResumable, "0.2.2")
+	r.Header.Set(headerUploadLength, "10")
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, r)
+
+	if w.Code != http.StatusPreconditionFailed || w.Header().Get(headerTusVersion) != tusVersion {
+		t.Errorf("POST with tus 0.2.2 = %d %v", w.Code, w.Header())
+	}
+}
+
+func TestUploadInChunks(t *testing.T) {
+	service := newFakeService()
+	routes := newTestHandler(t, service).UploadRouter()
+
+	data := testPNG(t, 40, 30)
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte("sunset.png")) +
+		",title " + base64.StdEncoding.EncodeToString([]byte("Sunset"))
+	id := createTestUpload(t, routes, len(data), metadata)
+
+	half := len(data) / 2
+	if w := patchTestUpload(t, routes, id, 0, data[:half]); w.Code != http.StatusNoContent || w.Header().Get(headerUploadOffset) != strconv.Itoa(half) {
+		t.Fatalf("first PATCH = %d %v %s", w.Code, w.Header(), w.Body)
+	}
+
+	// the client finds out where to resume from
+	w := tusRequest(t, routes, http.MethodHead, "/"+id, nil, nil)
+	if w.Code != http.StatusOK || w.Header().Get(headerUploadOffset) != strconv.Itoa(half) || w.Header().Get(headerUploadLength) != strconv.Itoa(len(data)) {
+		t.Fatalf("HEAD = %d %v", w.Code, w.Header())
+	}
+
+	// chunks have to pick up where the last one left off
+	if w := patchTestUpload(t, routes, id, 0, data[:half]); w.Code != http.StatusConflict {
+		t.Errorf("PATCH at the wrong offset = %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	// chunks must be sent as raw bytes
+	w = tusRequest(t, routes, http.MethodPatch, "/"+id, data[half:], map[string]string{headerUploadOffset: strconv.Itoa(half)})
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("PATCH without a content type = %d, want %d", w.Code, http.StatusUnsupportedMediaType)
+	}
+
+	if w := patchTestUpload(t, routes, id, half, data[half:]); w.Code != http.StatusNoContent || w.Header().Get(headerUploadOffset) != strconv.Itoa(len(data)) {
+		t.Fatalf("last PATCH = %d %v %s", w.Code, w.Header(), w.Body)
+	}
+
+	// the last chunk adds the image and queues its processing
+	if len(service.images) != 1 || len(service.jobs) != 1 {
+		t.Fatalf("%d images and %d jobs, want 1 of each", len(service.images), len(service.jobs))
+	}
+
+	img := service.images[1]
+	if img.Title != "Sunset" || img.Width != 40 || img.Height != 30 || img.MimeType != "image/png" {
+		t.Errorf("image = %+v", img)
+	}
+
+	w = tusRequest(t, routes, http.MethodGet, "/"+id, nil, nil)
+	upload := Upload{}
+	if err := json.Unmarshal(w.Body.Bytes(), &upload); err != nil {
+		t.Fatal(err)
+	}
+
+	if upload.ImageID != img.ID || upload.Offset != int64(len(data)) {
+		t.Errorf("GET = %s, want the id of the image", w.Body)
+	}
+
+	// sending the last chunk again doesn't add the image twice
+	if w := patchTestUpload(t, routes, id, len(data), nil); w.Code != http.StatusNoContent || len(service.images) != 1 {
+		t.Errorf("PATCH after the end = %d, %d images", w.Code, len(service.images))
+	}
+}
+
+func TestUploadOverTheLimits(t *testing.T) {
+	service := newFakeService()
+	handler := newTestHandler(t, service)
+	handler.limits.MaxPixels = 100 * 100
+	routes := handler.UploadRouter()
+
+	// the length is checked before anything is sent
+	w := tusRequest(t, routes, http.MethodPost, "/", nil, map[string]string{headerUploadLength: strconv.FormatInt(handler.limits.MaxFileSize+1, 10)})
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("POST = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	// the pixels once the upload is complete
+	data := testPNG(t, 200, 60)
+	id := createTestUpload(t, routes, len(data), "")
+
+	if w := patchTestUpload(t, routes, id, 0, data); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("PATCH = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	if len(service.images) != 0 {
+		t.Errorf("%d images were added", len(service.images))
+	}
+}
+
+func TestUploadTermination(t *testing.T) {
+	routes := newTestHandler(t, newFakeService()).UploadRouter()
+
+	id := createTestUpload(t, routes, 100, "")
+
+	if w := tusRequest(t, routes, http.MethodDelete, "/"+id, nil, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE = %d %s", w.Code, w.Body)
+	}
+
+	if w := tusRequest(t, routes, http.MethodHead, "/"+id, nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("HEAD after DELETE = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// upload ids end up in file paths
+	if w := tusRequest(t, routes, http.MethodHead, "/..", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("HEAD of an invalid id = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestUploadOfAFileThatIsNoImage(t *testing.T) {
+	service := newFakeService()
+	routes := newTestHandler(t, service).UploadRouter()
+
+	data := []byte("just some text, not a picture at all")
+	id := createTestUpload(t, routes, len(data), "")
+
+	if w := patchTestUpload(t, routes, id, 0, data); w.Code != http.StatusBadRequest {
+		t.Errorf("PATCH = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	if len(service.images) != 0 {
+		t.Errorf("%d images were added", len(service.images))
+	}
+
+	// sending it again won't help
+	if w := tusRequest(t, routes, http.MethodHead, "/"+id, nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("HEAD = %d, want the upload dropped", w.Code)
+	}
+}
+
+func TestUploadIsKeptWhenIngestionFails(t *testing.T) {
+	service := newFakeService()
+	service.addImageErr = errors.New("database is down")
+	routes := newTestHandler(t, service).UploadRouter()
+
+	data := testPNG(t, 40, 30)
+	id := createTestUpload(t, routes, len(data), "")
+
+	if w := patchTestUpload(t, routes, id, 0, data); w.Code == http.StatusNoContent {
+		t.Fatalf("PATCH = %d, want an error", w.Code)
+	}
+
+	// the image is fine, the upload stays complete for another try
+	w := tusRequest(t, routes, http.MethodHead, "/"+id, nil, nil)
+	if w.Code != http.StatusOK || w.Header().Get(headerUploadOffset) != strconv.Itoa(len(data)) {
+		t.Fatalf("HEAD = %d %v, want the upload kept", w.Code, w.Header())
+	}
+
+	service.addImageErr = nil
+	if w := patchTestUpload(t, routes, id, len(data), nil); w.Code != http.StatusNoContent || len(service.images) != 1 {
+		t.Errorf("PATCH again = %d %s, %d images, want the image added", w.Code, w.Body, len(service.images))
+	}
+}
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiskCache is a size bounded cache of files on the local filesystem,
+// evicting the least recently used files first
+type DiskCache struct {
+	// directory holding the cached files
+	dir string
+
+	// maximum total size of the cached files in bytes
+	maxSize int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // front is the most recently used
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key  string
+	size int64
+}
+
+// NewDiskCache is the constructor method for DiskCache.
+// Files cached by previous runs are picked up, oldest ones being evicted first.
+func NewDiskCache(dir string, maxSize int64) (*DiskCache, error) {
+	cache := DiskCache{
+		dir:     dir,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+
+	type cachedFile struct {
+		cacheEntry
+		modTime time.Time
+	}
+	files := []cachedFile{}
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		files = append(files, cachedFile{
+			cacheEntry: cacheEntry{key: filepath.ToSlash(rel), size: fi.Size()},
+			modTime:    fi.ModTime(),
+		})
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("NewDiskCache [%s]: %v", dir, err)
+	}
+
+	// the most recently modified file ends up in the front
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	for _, f := range files {
+		entry := f.cacheEntry
+		cache.entries[entry.key] = cache.lru.PushFront(&entry)
+		cache.size += entry.size
+	}
+
+	cache.evict()
+
+	return &cache, nil
+}
+
+// Get opens a cached file, marking it as recently used
+func (cache *DiskCache) Get(key string) (*os.File, bool) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, false
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	elem, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	f, err := os.Open(filepath.Join(cache.dir, filepath.FromSlash(key)))
+	if err != nil {
+		// the file is gone, forget about it
+		cache.remove(elem)
+		return nil, false
+	}
+
+	cache.lru.MoveToFront(elem)
+
+	return f, true
+}
+
+// Put writes data to the cache under key,
+// evicting the least recently used files if the cache grows too big
+func (cache *DiskCache) Put(key string, data []byte) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return fmt.Errorf("Put: %v", err)
+	}
+
+	dest := filepath.Join(cache.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return fmt.Errorf("Put [%s]: %v", key, err)
+	}
+
+	// write to a temporary file first so readers never see a partial file
+	f, err := os.CreateTemp(filepath.Dir(dest), ".cache-*")
+	if err != nil {
+		return fmt.Errorf("Put [%s]: %v", key, err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("Put [%s]: %v", key, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Put [%s]: %v", key, err)
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if err := os.Rename(f.Name(), dest); err != nil {
+		return fmt.Errorf("Put [%s]: %v", key, err)
+	}
+
+	if elem, ok := cache.entries[key]; ok {
+		cache.size -= elem.Value.(*cacheEntry).size
+		cache.lru.Remove(elem)
+	}
+
+	entry := cacheEntry{key: key, size: int64(len(data))}
+	cache.entries[key] = cache.lru.PushFront(&entry)
+	cache.size += entry.size
+
+	cache.evict()
+
+	return nil
+}
+
+// Purge drops all the cached files whose keys start with prefix
+func (cache *DiskCache) Purge(prefix string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	for key, elem := range cache.entries {
+		if strings.HasPrefix(key, prefix) {
+			cache.remove(elem)
+		}
+	}
+}
+
+// evict removes the least recently used files until the cache fits in maxSize.
+// The caller must hold the lock.
+func (cache *DiskCache) evict() {
+	for cache.size > cache.maxSize && cache.lru.Len() > 0 {
+		cache.remove(cache.lru.Back())
+	}
+}
+
+// remove deletes a cached file from disk and from the LRU list.
+// The caller must hold the lock.
+func (cache *DiskCache) remove(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+
+	err := os.Remove(filepath.Join(cache.dir, filepath.FromSlash(entry.key)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Println(err)
+	}
+
+	cache.lru.Remove(elem)
+	delete(cache.entries, entry.key)
+	cache.size -= entry.size
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+)
+
+// LocalStore keeps assets in a directory on the local filesystem
+type LocalStore struct {
+	// directory holding the assets
+	root string
+
+	// directory that deleted assets are moved to
+	trash string
+}
+
+// NewLocalStore is the constructor method for LocalStore
+func NewLocalStore(root string, trash string) *LocalStore {
+	store := LocalStore{
+		root:  root,
+		trash: trash,
+	}
+
+	return &store
+}
+
+// Put writes an asset to disk, replacing any existing asset with the same key
+func (store LocalStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return fmt.Errorf("Put: %v", err)
+	}
+
+	dest := filepath.Join(store.root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return fmt.Errorf("Put [%s]: %v", key, err)
+	}
+
+	// write to a temporary file first so readers never see a partial asset
+	f, err := os.CreateTemp(filepath.Dir(dest), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("Put [%s]: %v", key, err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("Put [%s]: %v", key, err)
+	}
+
+	// temporary files are only readable by their owner, assets are served to everyone
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return fmt.Errorf("Put [%s]: %v", key, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Put [%s]: %v", key, err)
+	}
+
+	if err := os.Rename(f.Name(), dest); err != nil {
+		return fmt.Errorf("Put [%s]: %v", key, err)
+	}
+
+	return nil
+}
+
+// Get opens an asset for reading
+func (store LocalStore) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("Get: %v", err)
+	}
+
+	f, err := os.Open(filepath.Join(store.root, filepath.FromSlash(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, cameraroll.ErrAssetNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("Get [%s]: %v", key, err)
+	}
+
+	return f, nil
+}
+
+// Delete moves an asset into the trash directory
+func (store LocalStore) Delete(ctx context.Context, key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return fmt.Errorf("Delete: %v", err)
+	}
+
+	oldPath := filepath.Join(store.root, filepath.FromSlash(key))
+	newPath := filepath.Join(store.trash, filepath.FromSlash(key))
+
+	if err := os.MkdirAll(filepath.Dir(newPath), os.ModePerm); err != nil {
+		return fmt.Errorf("Delete [%s]: %v", key, err)
+	}
+
+	if err := os.Rename(oldPath, newPath); errors.Is(err, os.ErrNotExist) {
+		return cameraroll.ErrAssetNotFound
+	} else if err != nil {
+		return fmt.Errorf("Delete [%s]: %v", key, err)
+	}
+
+	return nil
+}
+
+// Stat returns the size and modification time of an asset
+func (store LocalStore) Stat(ctx context.Context, key string) (*cameraroll.AssetInfo, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("Stat: %v", err)
+	}
+
+	fi, err := os.Stat(filepath.Join(store.root, filepath.FromSlash(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, cameraroll.ErrAssetNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("Stat [%s]: %v", key, err)
+	}
+
+	if fi.IsDir() {
+		return nil, cameraroll.ErrAssetNotFound
+	}
+
+	info := cameraroll.AssetInfo{
+		Key:         key,
+		Size:        fi.Size(),
+		ContentType: contentTypeOf(key),
+		ModTime:     fi.ModTime(),
+	}
+
+	return &info, nil
+}
+
+// List returns all the assets whose keys start with prefix
+func (store LocalStore) List(ctx context.Context, prefix string) ([]*cameraroll.AssetInfo, error) {
+	assets := []*cameraroll.AssetInfo{}
+
+	err := filepath.WalkDir(store.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// deleted assets are not listed, even if the trash lives inside the root
+		if d.IsDir() && p == store.trash {
+			return filepath.SkipDir
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(store.root, p)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		assets = append(assets, &cameraroll.AssetInfo{
+			Key:         key,
+			Size:        fi.Size(),
+			ContentType: contentTypeOf(key),
+			ModTime:     fi.ModTime(),
+		})
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("List [%s]: %v", prefix, err)
+	}
+
+	return assets, nil
+}
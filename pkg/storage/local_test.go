@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalStore(t *testing.T) {
+	root, trash := t.TempDir(), t.TempDir()
+
+	testAssetStore(t, NewLocalStore(root, trash))
+
+	// deleted assets are kept in the trash
+	if _, err := os.Stat(filepath.Join(trash, "images", "a.jpg")); err != nil {
+		t.Errorf("deleted asset isn't in the trash: %v", err)
+	}
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(filepath.Join(root, "images"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 0 {
+		t.Errorf("%d files left in the images folder", len(entries))
+	}
+}
+
+func TestLocalStorePermissions(t *testing.T) {
+	root := t.TempDir()
+	store := NewLocalStore(root, t.TempDir())
+
+	if err := store.Put(context.Background(), "a.jpg", strings.NewReader("jpeg"), 4, "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+
+	// assets are readable by whoever serves them
+	info, err := os.Stat(filepath.Join(root, "a.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if perm := info.Mode().Perm(); perm != 0644 {
+		t.Errorf("permissions = %v, want %v", perm, os.FileMode(0644))
+	}
+}
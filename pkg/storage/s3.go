@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+)
+
+// S3Store keeps assets in a bucket of an S3 compatible object storage
+type S3Store struct {
+	client *minio.Client
+
+	// bucket holding the assets
+	bucket string
+
+	// key prefix that deleted assets are copied to
+	trashPrefix string
+}
+
+// NewS3Store connects to an S3 compatible endpoint,
+// creating the bucket if it doesn't exist yet
+func NewS3Store(ctx context.Context, endpoint string, accessKey string, secretKey string, region string, bucket string, useSSL bool) (*S3Store, error) {
+	const (
+		trashPrefix = "deleted"
+	)
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: useSSL,
+		Region: region,
+		// path-style requests work with AWS as well as MinIO and other stand-ins
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("NewS3Store endpoint[%s]: %v", endpoint, err)
+	}
+
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("NewS3Store bucket[%s]: %v", bucket, err)
+	}
+
+	if !exists {
+		if err := client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{Region: region}); err != nil {
+			return nil, fmt.Errorf("NewS3Store bucket[%s]: %v", bucket, err)
+		}
+	}
+
+	store := S3Store{
+		client:      client,
+		bucket:      bucket,
+		trashPrefix: trashPrefix,
+	}
+
+	return &store, nil
+}
+
+// isNotFound checks if an S3 error means the object doesn't exist
+func isNotFound(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+
+	return code == "NoSuchKey" || code == "NotFound"
+}
+
+// Put uploads an asset to the bucket, replacing any existing object with the same key
+func (store S3Store) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return fmt.Errorf("Put: %v", err)
+	}
+
+	if len(contentType) == 0 {
+		contentType = contentTypeOf(key)
+	}
+
+	_, err = store.client.PutObject(ctx, store.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return fmt.Errorf("Put [%s]: %v", key, err)
+	}
+
+	return nil
+}
+
+// Get opens an object for reading
+func (store S3Store) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("Get: %v", err)
+	}
+
+	obj, err := store.client.GetObject(ctx, store.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Get [%s]: %v", key, err)
+	}
+
+	// GetObject is lazy, stat the object to find out if it actually exists
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+
+		if isNotFound(err) {
+			return nil, cameraroll.ErrAssetNotFound
+		}
+
+		return nil, fmt.Errorf("Get [%s]: %v", key, err)
+	}
+
+	return obj, nil
+}
+
+// Delete copies an object under the trash prefix and removes the original
+func (store S3Store) Delete(ctx context.Context, key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return fmt.Errorf("Delete: %v", err)
+	}
+
+	_, err = store.client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: store.bucket, Object: path.Join(store.trashPrefix, key)},
+		minio.CopySrcOptions{Bucket: store.bucket, Object: key})
+	if isNotFound(err) {
+		return cameraroll.ErrAssetNotFound
+	} else if err != nil {
+		return fmt.Errorf("Delete [%s]: %v", key, err)
+	}
+
+	if err := store.client.RemoveObject(ctx, store.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("Delete [%s]: %v", key, err)
+	}
+
+	return nil
+}
+
+// Stat returns the size and modification time of an object
+func (store S3Store) Stat(ctx context.Context, key string) (*cameraroll.AssetInfo, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("Stat: %v", err)
+	}
+
+	obj, err := store.client.StatObject(ctx, store.bucket, key, minio.StatObjectOptions{})
+	if isNotFound(err) {
+		return nil, cameraroll.ErrAssetNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("Stat [%s]: %v", key, err)
+	}
+
+	info := cameraroll.AssetInfo{
+		Key:         obj.Key,
+		Size:        obj.Size,
+		ContentType: obj.ContentType,
+		ModTime:     obj.LastModified,
+	}
+
+	return &info, nil
+}
+
+// List returns all the objects whose keys start with prefix,
+// leaving out the ones that were moved to the trash
+func (store S3Store) List(ctx context.Context, prefix string) ([]*cameraroll.AssetInfo, error) {
+	assets := []*cameraroll.AssetInfo{}
+
+	objects := store.client.ListObjects(ctx, store.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	})
+
+	for obj := range objects {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("List [%s]: %v", prefix, obj.Err)
+		}
+
+		if strings.HasPrefix(obj.Key, store.trashPrefix+"/") {
+			continue
+		}
+
+		assets = append(assets, &cameraroll.AssetInfo{
+			Key:         obj.Key,
+			Size:        obj.Size,
+			ContentType: obj.ContentType,
+			ModTime:     obj.LastModified,
+		})
+	}
+
+	return assets, nil
+}
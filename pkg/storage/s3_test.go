@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// s3Object is an object kept by fakeS3
+type s3Object struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+}
+
+// etag is the MD5 checksum S3 reports for an object uploaded in one piece
+func (obj *s3Object) etag() string {
+	sum := md5.Sum(obj.data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// fakeS3 stands in for MinIO, keeping buckets in memory
+// and speaking just enough of the S3 API for S3Store
+type fakeS3 struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]*s3Object
+}
+
+// s3Error is the body of an S3 error response
+type s3Error struct {
+	XMLName    xml.Name `xml:"Error"`
+	Code       string   `xml:"Code"`
+	Message    string   `xml:"Message"`
+	BucketName string   `xml:"BucketName"`
+	Key        string   `xml:"Key"`
+}
+
+// s3Contents is an object listed by ListObjectsV2
+type s3Contents struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+// s3ListResult is the body of a ListObjectsV2 response
+type s3ListResult struct {
+	XMLName     xml.Name     `xml:"ListBucketResult"`
+	Name        string       `xml:"Name"`
+	Prefix      string       `xml:"Prefix"`
+	KeyCount    int          `xml:"KeyCount"`
+	MaxKeys     int          `xml:"MaxKeys"`
+	IsTruncated bool         `xml:"IsTruncated"`
+	Contents    []s3Contents `xml:"Contents"`
+}
+
+// s3CopyResult is the body of a CopyObject response
+type s3CopyResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+}
+
+// writeXML sends an S3 response body
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(v)
+}
+
+// readChunked decodes a body sent with the streaming version of signature V4,
+// which frames the payload as "size;chunk-signature=...\r\ndata\r\n" ending with an empty chunk
+func readChunked(r io.Reader) ([]byte, error) {
+	reader := bufio.NewReader(r)
+	data := []byte{}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		hexSize, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(hexSize, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return nil, err
+		}
+
+		if size == 0 {
+			return data, nil
+		}
+
+		data = append(data, chunk[:size]...)
+	}
+}
+
+func (s3 *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s3.mu.Lock()
+	defer s3.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	objects, exists := s3.buckets[bucket]
+
+	switch {
+	case len(key) == 0 && r.Method == http.MethodPut:
+		if !exists {
+			s3.buckets[bucket] = map[string]*s3Object{}
+		}
+		return
+	case !exists:
+		writeXML(w, http.StatusNotFound, s3Error{Code: "NoSuchBucket", Message: "The specified bucket does not exist", BucketName: bucket})
+		return
+	case len(key) == 0 && r.Method == http.MethodHead:
+		return
+	case len(key) == 0 && r.Method == http.MethodGet:
+		s3.list(w, bucket, r.URL.Query().Get("prefix"))
+		return
+	}
+
+	obj := objects[key]
+
+	switch r.Method {
+	case http.MethodPut:
+		if source := r.Header.Get("X-Amz-Copy-Source"); len(source) > 0 {
+			s3.copy(w, bucket, key, source)
+			return
+		}
+
+		var data []byte
+		var err error
+		if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			data, err = readChunked(r.Body)
+		} else {
+			data, err = io.ReadAll(r.Body)
+		}
+
+		if err != nil {
+			writeXML(w, http.StatusBadRequest, s3Error{Code: "IncompleteBody", Message: err.Error(), BucketName: bucket, Key: key})
+			return
+		}
+
+		obj = &s3Object{data: data, contentType: r.Header.Get("Content-Type"), modTime: time.Now().UTC().Truncate(time.Second)}
+		objects[key] = obj
+		w.Header().Set("ETag", obj.etag())
+	case http.MethodGet, http.MethodHead:
+		if obj == nil {
+			writeXML(w, http.StatusNotFound, s3Error{Code: "NoSuchKey", Message: "The specified key does not exist.", BucketName: bucket, Key: key})
+			return
+		}
+
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("ETag", obj.etag())
+		http.ServeContent(w, r, key, obj.modTime, bytes.NewReader(obj.data))
+	case http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// list answers ListObjectsV2 with every object of a bucket whose key starts with prefix
+func (s3 *fakeS3) list(w http.ResponseWriter, bucket string, prefix string) {
+	result := s3ListResult{Name: bucket, Prefix: prefix, MaxKeys: 1000}
+
+	for key, obj := range s3.buckets[bucket] {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		result.Contents = append(result.Contents, s3Contents{
+			Key:          key,
+			LastModified: obj.modTime.Format("2006-01-02T15:04:05.000Z"),
+			ETag:         obj.etag(),
+			Size:         int64(len(obj.data)),
+			StorageClass: "STANDARD",
+		})
+	}
+
+	sort.Slice(result.Contents, func(i, j int) bool {
+		return result.Contents[i].Key < result.Contents[j].Key
+	})
+	result.KeyCount = len(result.Contents)
+
+	writeXML(w, http.StatusOK, result)
+}
+
+// copy answers CopyObject, source being the escaped bucket and key of the object to copy
+func (s3 *fakeS3) copy(w http.ResponseWriter, bucket string, key string, source string) {
+	source, err := url.PathUnescape(source)
+	if err != nil {
+		writeXML(w, http.StatusBadRequest, s3Error{Code: "InvalidArgument", Message: err.Error()})
+		return
+	}
+
+	srcBucket, srcKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	src := s3.buckets[srcBucket][srcKey]
+	if src == nil {
+		writeXML(w, http.StatusNotFound, s3Error{Code: "NoSuchKey", Message: "The specified key does not exist.", BucketName: srcBucket, Key: srcKey})
+		return
+	}
+
+	obj := *src
+	obj.modTime = time.Now().UTC().Truncate(time.Second)
+	s3.buckets[bucket][key] = &obj
+
+	writeXML(w, http.StatusOK, s3CopyResult{LastModified: obj.modTime.Format("2006-01-02T15:04:05.000Z"), ETag: obj.etag()})
+}
+
+func TestS3Store(t *testing.T) {
+	s3 := fakeS3{buckets: map[string]map[string]*s3Object{}}
+	server := httptest.NewServer(&s3)
+	defer server.Close()
+
+	endpoint := strings.TrimPrefix(server.URL, "http://")
+	store, err := NewS3Store(context.Background(), endpoint, "access", "secret", "us-east-1", "photos", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the bucket is created on the first connection
+	if _, exists := s3.buckets["photos"]; !exists {
+		t.Fatal("bucket wasn't created")
+	}
+
+	testAssetStore(t, store)
+
+	// deleted objects are kept under the trash prefix
+	if _, kept := s3.buckets["photos"]["deleted/images/a.jpg"]; !kept {
+		t.Errorf("deleted object isn't in the trash, bucket has %v", keysOf(s3.buckets["photos"]))
+	}
+
+	// connecting again finds the existing bucket
+	if _, err := NewS3Store(context.Background(), endpoint, "access", "secret", "us-east-1", "photos", false); err != nil {
+		t.Error(err)
+	}
+}
+
+// keysOf lists the keys of the objects in a bucket of fakeS3
+func keysOf(objects map[string]*s3Object) []string {
+	keys := []string{}
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
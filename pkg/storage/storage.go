@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+
+	"chujungeng/camera-roll/pkg/url"
+)
+
+// cleanKey normalizes an asset key and rejects keys escaping the root of the store
+func cleanKey(key string) (string, error) {
+	cleaned := strings.TrimPrefix(path.Clean("/"+key), "/")
+	if len(cleaned) == 0 || cleaned != strings.TrimPrefix(key, "/") {
+		return "", fmt.Errorf("invalid asset key [%s]", key)
+	}
+
+	return cleaned, nil
+}
+
+// contentTypeOf guesses the MIME type of an asset from its file extension
+func contentTypeOf(key string) string {
+	return mime.TypeByExtension(path.Ext(key))
+}
+
+// KeyFromURL finds the key of an asset in a store from its public URL,
+// given the path that the assets of the store are served under
+func KeyFromURL(assetURL string, basePath string) string {
+	relPath := url.GetPathFromURL(assetURL)
+	if len(basePath) == 0 || len(relPath) == 0 {
+		return ""
+	}
+
+	// strip everything up to and including the base path
+	idx := strings.LastIndex(relPath, basePath)
+	if idx < 0 {
+		return ""
+	}
+
+	return strings.TrimPrefix(relPath[idx+len(basePath):], "/")
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+)
+
+// readAsset reads a whole asset from a store
+func readAsset(t *testing.T, store cameraroll.AssetStore, key string) []byte {
+	t.Helper()
+
+	f, err := store.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get [%s]: %v", key, err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("Get [%s]: %v", key, err)
+	}
+
+	return data
+}
+
+// testAssetStore puts, reads, lists and deletes assets the way every AssetStore should
+func testAssetStore(t *testing.T, store cameraroll.AssetStore) {
+	ctx := context.Background()
+	data := []byte("not really a picture")
+
+	if err := store.Put(ctx, "images/a.jpg", bytes.NewReader(data), int64(len(data)), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readAsset(t, store, "images/a.jpg"); !bytes.Equal(got, data) {
+		t.Errorf("Get = %q, want %q", got, data)
+	}
+
+	// assets are served with http.ServeContent, which seeks around
+	f, err := store.Get(ctx, "images/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(4, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	if rest, err := io.ReadAll(f); err != nil || !bytes.Equal(rest, data[4:]) {
+		t.Errorf("read after seeking = %q, %v, want %q", rest, err, data[4:])
+	}
+	f.Close()
+
+	info, err := store.Stat(ctx, "images/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Key != "images/a.jpg" || info.Size != int64(len(data)) || info.ContentType != "image/jpeg" {
+		t.Errorf("Stat = %+v", info)
+	}
+
+	// keys are relative to the root of the store, with or without a leading slash
+	if _, err := store.Stat(ctx, "/images/a.jpg"); err != nil {
+		t.Errorf("Stat with a leading slash: %v", err)
+	}
+
+	other := []byte("another one")
+	if err := store.Put(ctx, "thumbnails/a.jpg", bytes.NewReader(other), int64(len(other)), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	assets, err := store.List(ctx, "images/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(assets) != 1 || assets[0].Key != "images/a.jpg" {
+		t.Errorf("List = %+v, want images/a.jpg only", assets)
+	}
+
+	// putting an asset again replaces it
+	if err := store.Put(ctx, "images/a.jpg", bytes.NewReader(other), int64(len(other)), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readAsset(t, store, "images/a.jpg"); !bytes.Equal(got, other) {
+		t.Errorf("Get after replacing = %q, want %q", got, other)
+	}
+
+	if err := store.Delete(ctx, "images/a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Get(ctx, "images/a.jpg"); !errors.Is(err, cameraroll.ErrAssetNotFound) {
+		t.Errorf("Get after deleting: err = %v, want %v", err, cameraroll.ErrAssetNotFound)
+	}
+
+	if _, err := store.Stat(ctx, "images/a.jpg"); !errors.Is(err, cameraroll.ErrAssetNotFound) {
+		t.Errorf("Stat after deleting: err = %v, want %v", err, cameraroll.ErrAssetNotFound)
+	}
+
+	if err := store.Delete(ctx, "images/a.jpg"); !errors.Is(err, cameraroll.ErrAssetNotFound) {
+		t.Errorf("Delete after deleting: err = %v, want %v", err, cameraroll.ErrAssetNotFound)
+	}
+
+	// deleted assets aren't listed
+	assets, err = store.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(assets) != 1 || assets[0].Key != "thumbnails/a.jpg" {
+		t.Errorf("List after deleting = %+v, want thumbnails/a.jpg only", assets)
+	}
+
+	// keys can't escape the store
+	if _, err := store.Get(ctx, "../secret"); err == nil || errors.Is(err, cameraroll.ErrAssetNotFound) {
+		t.Errorf("Get [../secret]: err = %v, want an invalid key", err)
+	}
+}
+
+func TestKeyFromURL(t *testing.T) {
+	tests := []struct {
+		assetURL string
+		basePath string
+		want     string
+	}{
+		{"http://localhost:9648/assets/a.jpg", "/assets/", "a.jpg"},
+		{"http://localhost:9648/assets/images/a.jpg", "/assets", "images/a.jpg"},
+		{"https://example.com/photos/assets/a.jpg?v=2", "/assets/", "a.jpg"},
+		{"http://localhost:9648/other/a.jpg", "/assets/", ""},
+		{"", "/assets/", ""},
+		{"http://localhost:9648/assets/a.jpg", "", ""},
+	}
+
+	for _, test := range tests {
+		if got := KeyFromURL(test.assetURL, test.basePath); got != test.want {
+			t.Errorf("KeyFromURL(%s, %s) = %s, want %s", test.assetURL, test.basePath, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+	"chujungeng/camera-roll/pkg/imaging"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// how often idle workers look for jobs queued by other processes
+	pollInterval = 5 * time.Second
+
+	// MaxAttempts is how many times a job is tried before giving up on it
+	MaxAttempts = 3
+
+	// LeaseDuration is how long a running job stays with a process that stopped sending heartbeats,
+	// after which it's put back into the queue for any process to take over
+	LeaseDuration = time.Minute
+
+	// how often the leases of the running jobs are renewed and expired ones looked for
+	heartbeatInterval = LeaseDuration / 4
+)
+
+// Pool runs the jobs queued in the database on a fixed number of goroutines
+type Pool struct {
+	service   cameraroll.Service
+	processor *imaging.Processor
+	size      int
+
+	// maximum Hamming distance between the perceptual hashes of near-duplicates
+	duplicateDistance int
+
+	// identifies the pool as the owner of the jobs it claims
+	owner string
+
+	// wakes up idle workers when a job is queued
+	wake chan struct{}
+
+	// closed to stop workers from claiming new jobs, only once
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// context of the running jobs, only cancelled if draining takes too long
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	wg sync.WaitGroup
+
+	// keeps the leases of the running jobs, until the workers are done with them
+	heartbeat sync.WaitGroup
+}
+
+// NewPool is the constructor method for Pool
+func NewPool(service cameraroll.Service, processor *imaging.Processor, size int, duplicateDistance int) *Pool {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	pool := Pool{
+		service:           service,
+		processor:         processor,
+		size:              size,
+		duplicateDistance: duplicateDistance,
+		owner:             newOwner(),
+		wake:              make(chan struct{}, size),
+		stop:              make(chan struct{}),
+		ctx:               ctx,
+		cancel:            cancel,
+	}
+
+	return &pool
+}
+
+// newOwner names a pool after its host and process,
+// plus a random part as both may be the same after a restart in a container
+func newOwner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return fmt.Sprintf("%.32s-%d-%.8s", hostname, os.Getpid(), uuid.New().String())
+}
+
+// Start starts the workers
+func (pool *Pool) Start() {
+	for i := 0; i < pool.size; i++ {
+		pool.wg.Add(1)
+		go pool.work()
+	}
+
+	pool.heartbeat.Add(1)
+	go pool.keepLeases()
+
+	log.Printf("Started %d workers as %s", pool.size, pool.owner)
+}
+
+// Notify wakes up an idle worker to pick up a newly queued job
+func (pool *Pool) Notify() {
+	select {
+	case pool.wake <- struct{}{}:
+	default:
+		// every worker has been woken up already
+	}
+}
+
+// Shutdown stops the workers from claiming new jobs and waits for the running ones to finish.
+// If ctx expires first, the running jobs are cancelled and go back into the queue.
+// It's safe to call more than once.
+func (pool *Pool) Shutdown(ctx context.Context) error {
+	pool.stopOnce.Do(func() {
+		close(pool.stop)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		pool.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		pool.cancel()
+		<-done
+		err = ctx.Err()
+	}
+
+	// the leases are kept until the last job is done
+	pool.cancel()
+	pool.heartbeat.Wait()
+
+	return err
+}
+
+// keepLeases renews the leases of the jobs the pool is running,
+// and puts the jobs of processes that stopped renewing theirs back into the queue
+func (pool *Pool) keepLeases() {
+	defer pool.heartbeat.Done()
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		// idle workers find the requeued jobs the next time they poll
+		if err := pool.service.RequeueExpiredJobs(pool.ctx, LeaseDuration, MaxAttempts); err != nil {
+			log.Println(err)
+		}
+
+		select {
+		case <-pool.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := pool.service.HeartbeatJobs(pool.ctx, pool.owner); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// work claims and runs jobs until the pool is stopped
+func (pool *Pool) work() {
+	defer pool.wg.Done()
+
+	for {
+		select {
+		case <-pool.stop:
+			return
+		default:
+		}
+
+		job, err := pool.service.ClaimJob(pool.ctx, pool.owner)
+		if err != nil {
+			log.Println(err)
+		}
+
+		// wait for something to do
+		if job == nil {
+			select {
+			case <-pool.stop:
+				return
+			case <-pool.wake:
+			case <-time.After(pollInterval):
+			}
+
+			continue
+		}
+
+		pool.run(job)
+	}
+}
+
+// run runs a job, recording how it went
+func (pool *Pool) run(job *cameraroll.Job) {
+	crashed, err := pool.handleSafely(job)
+
+	status, message := cameraroll.JobDone, ""
+	if err != nil {
+		log.Printf("job [%d] %s imageID[%d] attempt[%d]: %v", job.ID, job.Kind, job.ImageID, job.Attempts, err)
+		message = err.Error()
+
+		// jobs interrupted by a shutdown are tried again next time
+		status = cameraroll.JobQueued
+		if pool.ctx.Err() == nil && (crashed || job.Attempts >= MaxAttempts) {
+			status = cameraroll.JobFailed
+		}
+
+		imageStatus := cameraroll.ProcessingPending
+		if status == cameraroll.JobFailed {
+			imageStatus = cameraroll.ProcessingFailed
+		}
+
+		if err := pool.service.UpdateProcessingStatusOfImage(context.Background(), job.ImageID, imageStatus); err != nil {
+			log.Println(err)
+		}
+	}
+
+	// record the outcome even if the jobs were cancelled
+	if err := pool.service.UpdateJobStatus(context.Background(), job.ID, pool.owner, status, message); err != nil {
+		log.Println(err)
+	}
+
+	// let another worker retry the job right away
+	if status == cameraroll.JobQueued && pool.ctx.Err() == nil {
+		pool.Notify()
+	}
+}
+
+// handleSafely runs a job, reporting if it panicked.
+// A panic fails the job for good, as it would happen again on every attempt.
+func (pool *Pool) handleSafely(job *cameraroll.Job) (crashed bool, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("job [%d] %s imageID[%d] panicked: %v\n%s", job.ID, job.Kind, job.ImageID, v, debug.Stack())
+			crashed, err = true, fmt.Errorf("panic: %v", v)
+		}
+	}()
+
+	return false, pool.handle(pool.ctx, job)
+}
+
+// handle runs a job according to its kind
+func (pool *Pool) handle(ctx context.Context, job *cameraroll.Job) error {
+	switch job.Kind {
+	case cameraroll.JobProcessImage:
+		return pool.processImage(ctx, job.ImageID)
+	default:
+		return fmt.Errorf("unknown job kind [%s]", job.Kind)
+	}
+}
@@ -0,0 +1,538 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math/bits"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+	"chujungeng/camera-roll/pkg/imaging"
+	"chujungeng/camera-roll/pkg/storage"
+)
+
+// public URL of the assets in the tests
+const testBaseURL = "http://localhost:9648/public"
+
+// fakeService keeps jobs and images in memory, standing in for the database.
+// Only the methods the workers use are implemented.
+type fakeService struct {
+	cameraroll.Service
+
+	mu         sync.Mutex
+	jobs       []*cameraroll.Job
+	heartbeats map[int64]time.Time
+	images     map[int64]*cameraroll.Image
+	edits      map[int64]*cameraroll.Edits
+
+	// id of an image that brings down any job working on it
+	panicking int64
+}
+
+func newFakeService() *fakeService {
+	service := fakeService{
+		heartbeats: map[int64]time.Time{},
+		images:     map[int64]*cameraroll.Image{},
+		edits:      map[int64]*cameraroll.Edits{},
+	}
+
+	return &service
+}
+
+// queue adds a job for an image
+func (service *fakeService) queue(kind string, imageID int64) *cameraroll.Job {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	job := cameraroll.Job{
+		ID:      int64(len(service.jobs) + 1),
+		Kind:    kind,
+		ImageID: imageID,
+		Status:  cameraroll.JobQueued,
+	}
+	service.jobs = append(service.jobs, &job)
+
+	return &job
+}
+
+// job returns a copy of a job as it is now
+func (service *fakeService) job(id int64) cameraroll.Job {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	return *service.jobs[id-1]
+}
+
+// image returns a copy of an image as it is now
+func (service *fakeService) image(id int64) cameraroll.Image {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	return *service.images[id]
+}
+
+// finished checks if every job is done or failed
+func (service *fakeService) finished() bool {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	for _, job := range service.jobs {
+		if job.Status == cameraroll.JobQueued || job.Status == cameraroll.JobRunning {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (service *fakeService) ClaimJob(ctx context.Context, owner string) (*cameraroll.Job, error) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	// one job per image at a time, the oldest first
+	busy := map[int64]bool{}
+	for _, job := range service.jobs {
+		if job.Status == cameraroll.JobRunning {
+			busy[job.ImageID] = true
+		}
+	}
+
+	for _, job := range service.jobs {
+		if job.Status != cameraroll.JobQueued {
+			continue
+		}
+
+		if !busy[job.ImageID] {
+			job.Status = cameraroll.JobRunning
+			job.Attempts++
+			job.Owner = owner
+			service.heartbeats[job.ID] = time.Now()
+
+			claimed := *job
+			return &claimed, nil
+		}
+
+		busy[job.ImageID] = true
+	}
+
+	return nil, nil
+}
+
+func (service *fakeService) UpdateJobStatus(ctx context.Context, id int64, owner string, status string, message string) error {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	job := service.jobs[id-1]
+	if job.Owner != owner || job.Status != cameraroll.JobRunning {
+		return nil
+	}
+
+	job.Status = status
+	job.Error = message
+
+	return nil
+}
+
+func (service *fakeService) HeartbeatJobs(ctx context.Context, owner string) error {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	for _, job := range service.jobs {
+		if job.Owner == owner && job.Status == cameraroll.JobRunning {
+			service.heartbeats[job.ID] = time.Now()
+		}
+	}
+
+	return nil
+}
+
+func (service *fakeService) RequeueExpiredJobs(ctx context.Context, lease time.Duration, maxAttempts int) error {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	for _, job := range service.jobs {
+		if job.Status != cameraroll.JobRunning || time.Since(service.heartbeats[job.ID]) <= lease {
+			continue
+		}
+
+		job.Status = cameraroll.JobQueued
+		if job.Attempts >= maxAttempts {
+			job.Status = cameraroll.JobFailed
+			service.images[job.ImageID].ProcessingStatus = cameraroll.ProcessingFailed
+		}
+	}
+
+	return nil
+}
+
+func (service *fakeService) GetImageByID(ctx context.Context, id int64) (*cameraroll.Image, error) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	if id == service.panicking {
+		panic(fmt.Sprintf("image [%d] is cursed", id))
+	}
+
+	img, exists := service.images[id]
+	if !exists {
+		return nil, fmt.Errorf("GetImageByID [%d]: not found", id)
+	}
+
+	found := *img
+	return &found, nil
+}
+
+func (service *fakeService) UpdateProcessingStatusOfImage(ctx context.Context, id int64, status string) error {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	service.images[id].ProcessingStatus = status
+
+	return nil
+}
+
+func (service *fakeService) UpdateDerivativesOfImage(ctx context.Context, id int64, img *cameraroll.Image) error {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	updated := *img
+	service.images[id] = &updated
+
+	return nil
+}
+
+func (service *fakeService) GetEditsOfImage(ctx context.Context, imageID int64) (*cameraroll.Edits, error) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	return service.edits[imageID], nil
+}
+
+func (service *fakeService) GetWatermarksOfImage(ctx context.Context, id int64) ([]string, error) {
+	return nil, nil
+}
+
+func (service *fakeService) GetRenditionsOfImage(ctx context.Context, id int64) ([]*cameraroll.Rendition, error) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	return service.images[id].Renditions, nil
+}
+
+func (service *fakeService) GetDuplicatesOfImage(ctx context.Context, id int64, hash uint64, maxDistance int) ([]*cameraroll.Duplicate, error) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	duplicates := []*cameraroll.Duplicate{}
+	for _, img := range service.images {
+		if img.ID == id || img.PerceptualHash == nil {
+			continue
+		}
+
+		if distance := bits.OnesCount64(hash ^ *img.PerceptualHash); distance <= maxDistance {
+			found := *img
+			duplicates = append(duplicates, &cameraroll.Duplicate{Image: &found, Distance: distance})
+		}
+	}
+
+	sort.Slice(duplicates, func(i, j int) bool {
+		return duplicates[i].Distance < duplicates[j].Distance
+	})
+
+	return duplicates, nil
+}
+
+// newTestPool starts a pool of workers deriving images into temporary stores
+func newTestPool(t *testing.T, service *fakeService) (*Pool, *imaging.Processor) {
+	t.Helper()
+
+	processor := newTestProcessor(t)
+
+	return startTestPool(t, service, processor), processor
+}
+
+// newTestProcessor saves originals and derivatives to temporary folders
+func newTestProcessor(t *testing.T) *imaging.Processor {
+	t.Helper()
+
+	store := storage.NewLocalStore(t.TempDir(), t.TempDir())
+	originals := storage.NewLocalStore(t.TempDir(), t.TempDir())
+
+	return imaging.NewProcessor(store, originals, testBaseURL, imaging.Options{
+		ThumbnailSize:   16,
+		RenditionWidths: []int{32},
+		MetadataPolicy:  imaging.MetadataStripAll,
+	})
+}
+
+// startTestPool starts 2 workers, shutting them down at the end of the test
+func startTestPool(t *testing.T, service *fakeService, processor *imaging.Processor) *Pool {
+	t.Helper()
+
+	pool := NewPool(service, processor, 2, 0)
+	pool.Start()
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := pool.Shutdown(ctx); err != nil {
+			t.Error(err)
+		}
+	})
+
+	return pool
+}
+
+// addTestImage saves a PNG original of the given size for a new image
+func addTestImage(t *testing.T, service *fakeService, processor *imaging.Processor, id int64, width int, height int) {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	img.Set(0, 0, color.NRGBA{R: 255, A: 255})
+
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	key := fmt.Sprintf("image-%d.png", id)
+	if err := processor.SaveOriginal(context.Background(), key, &buf, imaging.FormatPNG); err != nil {
+		t.Fatal(err)
+	}
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	service.images[id] = &cameraroll.Image{
+		ID:               id,
+		Path:             testBaseURL + "/" + key,
+		ProcessingStatus: cameraroll.ProcessingPending,
+	}
+}
+
+// waitForJobs waits until every job of service is done or failed
+func waitForJobs(t *testing.T, service *fakeService) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !service.finished() {
+		if time.Now().After(deadline) {
+			t.Fatal("jobs didn't finish in time")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPoolProcessesImages(t *testing.T) {
+	service := newFakeService()
+	pool, processor := newTestPool(t, service)
+
+	addTestImage(t, service, processor, 1, 64, 48)
+	addTestImage(t, service, processor, 2, 20, 30)
+	service.queue(cameraroll.JobProcessImage, 1)
+	service.queue(cameraroll.JobProcessImage, 2)
+	pool.Notify()
+
+	waitForJobs(t, service)
+
+	for id, size := range map[int64]image.Point{1: {64, 48}, 2: {20, 30}} {
+		if job := service.job(id); job.Status != cameraroll.JobDone || job.Attempts != 1 {
+			t.Errorf("job [%d] = %+v, want done on the first attempt", id, job)
+		}
+
+		img := service.image(id)
+		if img.ProcessingStatus != cameraroll.ProcessingReady {
+			t.Errorf("image [%d] is %s, want %s", id, img.ProcessingStatus, cameraroll.ProcessingReady)
+		}
+
+		if img.Width != size.X || img.Height != size.Y || img.MimeType != "image/png" {
+			t.Errorf("image [%d] is a %dx%d %s, want a %dx%d image/png", id, img.Width, img.Height, img.MimeType, size.X, size.Y)
+		}
+
+		if len(img.Thumbnail) == 0 || img.PerceptualHash == nil {
+			t.Errorf("image [%d] has no thumbnail or perceptual hash", id)
+		}
+
+		// images narrower than the rendition widths get one at their own width
+		width := 32
+		if size.X < width {
+			width = size.X
+		}
+
+		if len(img.Renditions) != 1 || img.Renditions[0].Width != width {
+			t.Errorf("image [%d] has renditions %+v, want one %d wide", id, img.Renditions, width)
+		}
+	}
+}
+
+func TestPoolAppliesEdits(t *testing.T) {
+	service := newFakeService()
+	pool, processor := newTestPool(t, service)
+
+	addTestImage(t, service, processor, 1, 64, 48)
+	service.edits[1] = &cameraroll.Edits{ImageID: 1, Rotation: 90}
+	service.queue(cameraroll.JobProcessImage, 1)
+	pool.Notify()
+
+	waitForJobs(t, service)
+
+	// the derivatives are turned on their side, the original isn't
+	img := service.image(1)
+	if img.ProcessingStatus != cameraroll.ProcessingReady || img.Width != 64 || img.Height != 48 {
+		t.Fatalf("image = %+v, want a ready 64x48 image", img)
+	}
+
+	if img.ThumbnailWidth != 12 || img.ThumbnailHeight != 16 {
+		t.Errorf("thumbnail is %dx%d, want 12x16", img.ThumbnailWidth, img.ThumbnailHeight)
+	}
+
+	if len(img.Renditions) != 1 || img.Renditions[0].Width != 32 || img.Renditions[0].Height != 43 {
+		t.Errorf("renditions = %+v, want one of 32x43", img.Renditions)
+	}
+}
+
+func TestPoolGivesUpOnFailingJobs(t *testing.T) {
+	service := newFakeService()
+	pool, _ := newTestPool(t, service)
+
+	// an image whose original is gone
+	service.images[1] = &cameraroll.Image{ID: 1, Path: testBaseURL + "/missing.png"}
+	service.queue(cameraroll.JobProcessImage, 1)
+
+	// a job nobody knows how to run
+	service.images[2] = &cameraroll.Image{ID: 2}
+	service.queue("unknown", 2)
+
+	pool.Notify()
+	waitForJobs(t, service)
+
+	for _, id := range []int64{1, 2} {
+		job := service.job(id)
+		if job.Status != cameraroll.JobFailed || job.Attempts != MaxAttempts || len(job.Error) == 0 {
+			t.Errorf("job [%d] = %+v, want failed after %d attempts", id, job, MaxAttempts)
+		}
+
+		if status := service.image(id).ProcessingStatus; status != cameraroll.ProcessingFailed {
+			t.Errorf("image [%d] is %s, want %s", id, status, cameraroll.ProcessingFailed)
+		}
+	}
+}
+
+func TestPoolRecoversFromPanickingJobs(t *testing.T) {
+	service := newFakeService()
+	pool, processor := newTestPool(t, service)
+
+	service.panicking = 1
+	service.images[1] = &cameraroll.Image{ID: 1}
+	service.queue(cameraroll.JobProcessImage, 1)
+
+	addTestImage(t, service, processor, 2, 20, 30)
+	service.queue(cameraroll.JobProcessImage, 2)
+
+	pool.Notify()
+	waitForJobs(t, service)
+
+	// a panic would happen again, so the job isn't tried again
+	if job := service.job(1); job.Status != cameraroll.JobFailed || job.Attempts != 1 || len(job.Error) == 0 {
+		t.Errorf("job [1] = %+v, want failed on the first attempt", job)
+	}
+
+	// the workers carry on with the other jobs
+	if job := service.job(2); job.Status != cameraroll.JobDone {
+		t.Errorf("job [2] = %+v, want done", job)
+	}
+}
+
+func TestPoolTakesOverExpiredJobs(t *testing.T) {
+	service := newFakeService()
+	processor := newTestProcessor(t)
+
+	addTestImage(t, service, processor, 1, 20, 30)
+	addTestImage(t, service, processor, 2, 20, 30)
+	addTestImage(t, service, processor, 3, 20, 30)
+
+	// left running by processes that went quiet, and one that's still around
+	for i, lastHeartbeat := range []time.Duration{2 * LeaseDuration, 2 * LeaseDuration, 0} {
+		id := int64(i + 1)
+		job := service.queue(cameraroll.JobProcessImage, id)
+		job.Status = cameraroll.JobRunning
+		job.Owner = fmt.Sprintf("process-%d", id)
+		job.Attempts = 1
+		service.heartbeats[job.ID] = time.Now().Add(-lastHeartbeat)
+	}
+	service.jobs[1].Attempts = MaxAttempts
+
+	pool := startTestPool(t, service, processor)
+	pool.Notify()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for service.job(1).Status != cameraroll.JobDone {
+		if time.Now().After(deadline) {
+			t.Fatalf("job [1] = %+v, want it taken over and done", service.job(1))
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if job := service.job(1); job.Owner != pool.owner || job.Attempts != 2 {
+		t.Errorf("job [1] = %+v, want taken over on its second attempt", job)
+	}
+
+	if job, img := service.job(2), service.image(2); job.Status != cameraroll.JobFailed || img.ProcessingStatus != cameraroll.ProcessingFailed {
+		t.Errorf("job [2] = %+v, image = %+v, want both failed after the last attempt", job, img)
+	}
+
+	if job := service.job(3); job.Status != cameraroll.JobRunning || job.Owner != "process-3" {
+		t.Errorf("job [3] = %+v, want it left with its owner", job)
+	}
+}
+
+func TestPoolShutdownTwice(t *testing.T) {
+	service := newFakeService()
+	pool, _ := newTestPool(t, service)
+
+	// the cleanup of newTestPool shuts it down once more
+	if err := pool.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPoolFlagsNewerDuplicates(t *testing.T) {
+	service := newFakeService()
+	pool, processor := newTestPool(t, service)
+
+	addTestImage(t, service, processor, 1, 64, 48)
+	service.queue(cameraroll.JobProcessImage, 1)
+	pool.Notify()
+	waitForJobs(t, service)
+
+	addTestImage(t, service, processor, 2, 64, 48)
+	service.queue(cameraroll.JobProcessImage, 2)
+	pool.Notify()
+	waitForJobs(t, service)
+
+	// reprocessing the older image doesn't make it a duplicate of the newer one
+	service.queue(cameraroll.JobProcessImage, 1)
+	pool.Notify()
+	waitForJobs(t, service)
+
+	if img := service.image(1); img.DuplicateOf != 0 {
+		t.Errorf("image [1] is a duplicate of [%d], want none", img.DuplicateOf)
+	}
+
+	if img := service.image(2); img.DuplicateOf != 1 {
+		t.Errorf("image [2] is a duplicate of [%d], want [1]", img.DuplicateOf)
+	}
+}
@@ -0,0 +1,79 @@
+package worker
+
+import (
+	"context"
+	"log"
+
+	"chujungeng/camera-roll/pkg/cameraroll"
+)
+
+// processImage derives the thumbnail, renditions and metadata of an image from its original, edits and watermark,
+// replacing whatever had been derived before
+func (pool *Pool) processImage(ctx context.Context, imageID int64) error {
+	img, err := pool.service.GetImageByID(ctx, imageID)
+	if err != nil {
+		return err
+	}
+
+	if err := pool.service.UpdateProcessingStatusOfImage(ctx, imageID, cameraroll.ProcessingRunning); err != nil {
+		return err
+	}
+
+	edits, err := pool.service.GetEditsOfImage(ctx, imageID)
+	if err != nil {
+		return err
+	}
+
+	// albums may use other marks than the default one
+	watermarks, err := pool.service.GetWatermarksOfImage(ctx, imageID)
+	if err != nil {
+		return err
+	}
+
+	// the renditions carrying album marks are left out of the image, but have to go too
+	oldRenditions, err := pool.service.GetRenditionsOfImage(ctx, imageID)
+	if err != nil {
+		return err
+	}
+
+	original, _, err := pool.processor.OpenOriginal(ctx, pool.processor.KeyFromURL(img.Path))
+	if err != nil {
+		return err
+	}
+	defer original.Close()
+
+	derivatives, err := pool.processor.Process(ctx, original, edits, watermarks)
+	if err != nil {
+		return err
+	}
+
+	// the old derivatives stay around until the new ones are in the database
+	old := *img
+	old.Renditions = oldRenditions
+	derivatives.ApplyTo(img)
+	img.ProcessingStatus = cameraroll.ProcessingReady
+
+	// flag near-duplicates, which were only rejected upfront if asked to.
+	// Only the older image of a pair is pointed at, so that reprocessing either keeps the flag on the newer one.
+	img.DuplicateOf = 0
+	duplicates, err := pool.service.GetDuplicatesOfImage(ctx, imageID, derivatives.PerceptualHash, pool.duplicateDistance)
+	if err != nil {
+		log.Println(err)
+	}
+
+	for _, duplicate := range duplicates {
+		if duplicate.ID < imageID {
+			img.DuplicateOf = duplicate.ID
+			break
+		}
+	}
+
+	if err := pool.service.UpdateDerivativesOfImage(ctx, imageID, img); err != nil {
+		pool.processor.Discard(context.Background(), derivatives)
+		return err
+	}
+
+	pool.processor.DeleteDerivatives(context.Background(), &old)
+
+	return nil
+}